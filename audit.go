@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the audit log. Related actions share a prefix, so they can be filtered together.
+const (
+	auditLogin              = "login"
+	auditLoginFailed        = "login.failed"
+	auditLogout             = "logout"
+	auditUserCreate         = "user.create"
+	auditUserDelete         = "user.delete"
+	auditUserPermissions    = "user.permissions"
+	auditUserPassword       = "user.password"
+	auditUserEmail          = "user.email"
+	auditUserTwoFactor      = "user.twofactor"
+	auditUserSessions       = "user.sessions"
+	auditUserUnlock         = "user.unlock"
+	auditUserLock           = "user.lock"
+	auditUserApprove        = "user.approve"
+	auditUserReject         = "user.reject"
+	auditPasswordReset      = "password.reset"
+	auditTokenCreate        = "token.create"
+	auditTokenRevoke        = "token.revoke"
+	auditGroupCreate        = "group.create"
+	auditGroupDelete        = "group.delete"
+	auditGroupPermissions   = "group.permissions"
+	auditGroupMembers       = "group.members"
+	auditInviteCreate       = "invite.create"
+	auditInviteRevoke       = "invite.revoke"
+	auditConfigSite         = "config.site"
+	auditConfigAcl          = "config.acl"
+	auditConfigSecurity     = "config.security"
+	auditConfigRegistration = "config.registration"
+	auditConfigMail         = "config.mail"
+	auditConfigWebhooks     = "config.webhooks"
+	auditConfigRestore      = "config.restore"
+	auditConfigReset        = "config.reset"
+	auditConfigSecrets      = "config.secrets"
+)
+
+// AuditEvent is a security-relevant action, such as a login or a change to a user's permissions.
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+	// User is the user who performed the action, or who tried to log in. It's empty for anonymous actions.
+	User   string `json:"user,omitempty"`
+	IP     string `json:"ip"`
+	Action string `json:"action"`
+	// Target is the user, group or other thing the action was performed on, if any.
+	Target string `json:"target,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// AuditFilter selects events from the audit log. Empty fields match every event.
+type AuditFilter struct {
+	// User matches events performed by or on the named user.
+	User string
+	// Action matches events with the given action, or any action starting with it and a dot.
+	Action string
+	IP     string
+}
+
+// Matches determines whether the event is selected by the filter.
+func (f AuditFilter) Matches(event *AuditEvent) bool {
+	if f.User != "" && !strings.EqualFold(event.User, f.User) && !strings.EqualFold(event.Target, f.User) {
+		return false
+	}
+
+	if f.Action != "" && event.Action != f.Action && !strings.HasPrefix(event.Action, f.Action+".") {
+		return false
+	}
+
+	return f.IP == "" || event.IP == f.IP
+}
+
+// AuditLog records events to a file with one JSON object per line. Events are only ever appended, and the file is
+// kept outside of the wiki's repository, as it's specific to this instance of the wiki.
+type AuditLog struct {
+	path string
+
+	mutex sync.Mutex
+	file  *os.File
+	// size is the length of the file up to the end of the last complete event written, so that it can be read
+	// without stopping events being recorded.
+	size int64
+}
+
+// OpenAuditLog opens the audit log at the given path, creating it if necessary.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &AuditLog{
+		path: path,
+		file: file,
+		size: info.Size(),
+	}, nil
+}
+
+// Record appends the event to the log. Errors are logged, as they shouldn't stop the action from happening.
+func (a *AuditLog) Record(event AuditEvent) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Unable to encode audit event: %v", err)
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	n, err := a.file.Write(append(b, '\n'))
+	if err != nil {
+		log.Printf("Unable to write audit event %s: %v", b, err)
+	}
+	a.size += int64(n)
+}
+
+// Events returns the events matching the filter, newest first. If limit is greater than zero, at most that many are
+// returned. Events recorded while the log is being read aren't included.
+func (a *AuditLog) Events(filter AuditFilter, limit int) ([]AuditEvent, error) {
+	a.mutex.Lock()
+	size := a.size
+	a.mutex.Unlock()
+
+	file, err := os.Open(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var res []AuditEvent
+	scanner := bufio.NewScanner(io.LimitReader(file, size))
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		event := AuditEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("unable to read audit event on line %d: %w", line, err)
+		}
+
+		if filter.Matches(&event) {
+			res = append(res, event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// Close closes the log file. Events can't be recorded afterwards.
+func (a *AuditLog) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.file.Close()
+}
+
+type AuditRecorder interface {
+	Record(event AuditEvent)
+}
+
+// RecordAuditEvents makes the recorder available to handlers, so they can record events with audit.
+func RecordAuditEvents(ar AuditRecorder) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			next.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), contextAuditKey, ar)))
+		})
+	}
+}
+
+// audit records an event in the audit log, with the time and the address the request came from. The event is
+// attributed to the user making the request, unless it already names one.
+func audit(request *http.Request, event AuditEvent) {
+	ar, _ := request.Context().Value(contextAuditKey).(AuditRecorder)
+	if ar == nil {
+		return
+	}
+
+	if event.User == "" {
+		if user := getUserForRequest(request); user != nil {
+			event.User = user.Name
+		}
+	}
+
+	event.Time = time.Now()
+	event.IP = clientIP(request)
+	ar.Record(event)
+}
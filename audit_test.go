@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/mdbot/wiki/config"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auditLog.Record(AuditEvent{User: "alice", IP: "10.0.0.1", Action: auditLogin})
+	auditLog.Record(AuditEvent{User: "alice", IP: "10.0.0.1", Action: auditUserCreate, Target: "bob"})
+	auditLog.Record(AuditEvent{IP: "10.0.0.2", Action: auditLoginFailed, Target: "carol"})
+	if err := auditLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening the log should append to it
+	auditLog, err = OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+	auditLog.Record(AuditEvent{User: "bob", IP: "10.0.0.3", Action: auditUserPassword, Target: "bob"})
+
+	events, err := auditLog.Events(AuditFilter{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for i := range events {
+		actions = append(actions, events[i].Action)
+	}
+	want := []string{auditUserPassword, auditLoginFailed, auditUserCreate, auditLogin}
+	if len(actions) != len(want) {
+		t.Fatalf("Events() = %v, want %v", actions, want)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Fatalf("Events() = %v, want %v", actions, want)
+		}
+	}
+
+	events, err = auditLog.Events(AuditFilter{User: "Bob"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Action != auditUserPassword || events[1].Action != auditUserCreate {
+		t.Errorf("Events() for bob = %+v, want the events performed by and on bob", events)
+	}
+
+	events, err = auditLog.Events(AuditFilter{Action: "login"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Action != auditLoginFailed {
+		t.Errorf("Events() for logins = %+v, want only the most recent login", events)
+	}
+}
+
+func TestAuditFilter_Matches(t *testing.T) {
+	event := &AuditEvent{User: "alice", IP: "10.0.0.1", Action: auditUserPermissions, Target: "bob"}
+	tests := []struct {
+		name   string
+		filter AuditFilter
+		want   bool
+	}{
+		{"empty", AuditFilter{}, true},
+		{"actor", AuditFilter{User: "ALICE"}, true},
+		{"target", AuditFilter{User: "bob"}, true},
+		{"other user", AuditFilter{User: "carol"}, false},
+		{"exact action", AuditFilter{Action: auditUserPermissions}, true},
+		{"action prefix", AuditFilter{Action: "user"}, true},
+		{"partial action", AuditFilter{Action: "user.perm"}, false},
+		{"other action", AuditFilter{Action: "group"}, false},
+		{"ip", AuditFilter{IP: "10.0.0.1"}, true},
+		{"other ip", AuditFilter{IP: "10.0.0.10"}, false},
+		{"everything", AuditFilter{User: "alice", Action: "user", IP: "10.0.0.1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Matches(event); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type testAuditRecorder struct {
+	events []AuditEvent
+}
+
+func (t *testAuditRecorder) Record(event AuditEvent) {
+	t.events = append(t.events, event)
+}
+
+func TestRecordAuditEvents(t *testing.T) {
+	recorder := &testAuditRecorder{}
+	handler := RecordAuditEvents(recorder)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		audit(r, AuditEvent{Action: auditLoginFailed, Target: "bob"})
+		r = r.WithContext(context.WithValue(r.Context(), contextUserKey, &config.User{Name: "alice"}))
+		audit(r, AuditEvent{Action: auditUserDelete, Target: "bob"})
+		audit(r, AuditEvent{User: "bob", Action: auditLogin})
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/wiki/users", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(recorder.events) != 3 {
+		t.Fatalf("recorded %d events, want 3", len(recorder.events))
+	}
+	for i, want := range []string{"", "alice", "bob"} {
+		if got := recorder.events[i]; got.User != want || got.IP != "192.0.2.1" || got.Time.IsZero() {
+			t.Errorf("event %d = %+v, want user %q from 192.0.2.1", i, got, want)
+		}
+	}
+
+	// Without a recorder, events are dropped
+	audit(req, AuditEvent{Action: auditLogin})
+}
+
+func TestAuditExportHandler(t *testing.T) {
+	auditLog, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	rr := httptest.NewRecorder()
+	AuditExportHandler(auditLog).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/wiki/audit.json", nil))
+	if rr.Body.String() != "[]" {
+		t.Errorf("export of an empty log = %s, want []", rr.Body.String())
+	}
+
+	auditLog.Record(AuditEvent{User: "alice", IP: "10.0.0.1", Action: auditConfigAcl})
+	auditLog.Record(AuditEvent{User: "alice", IP: "10.0.0.1", Action: auditTokenCreate, Detail: "deploy"})
+
+	rr = httptest.NewRecorder()
+	AuditExportHandler(auditLog).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/wiki/audit.json?action=config", nil))
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var events []AuditEvent
+	if err := json.Unmarshal(rr.Body.Bytes(), &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Action != auditConfigAcl || events[0].User != "alice" {
+		t.Errorf("export = %+v, want the access control change", events)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mdbot/wiki/config"
+)
+
+// commandUser is recorded as the author of changes made by commands.
+const commandUser = "System"
+
+const commandUsage = `Usage: wiki [flags] <command>
+
+Commands change the wiki's settings in the working directory without starting the server:
+
+  users list                          List users and their permissions
+  users add <name> [password]         Add a user
+  users passwd <name> [password]      Change a user's password
+  users grant <name> <permission>     Set a user's permissions (none, read, write or admin)
+  users reset-2fa <name>              Turn off two-factor authentication for a user
+  config dump                         Write all settings, decrypted, to stdout as JSON
+  config restore <file>               Replace settings with those in a dump (or stdin if "-"), encrypted with -key
+  config reset <name>                 Discard the named settings, such as users if they can't be decrypted
+  secrets reset                       Replace the session and CSRF keys, which logs everyone out
+
+Passwords are read from stdin if not given. Changes are recorded in the audit log.`
+
+// validConfigName matches the names settings can be saved under.
+var validConfigName = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// ConfigLister lists the names of all the settings that have been saved.
+type ConfigLister interface {
+	ConfigNames() ([]string, error)
+}
+
+// runCommand runs one of the commands listed in commandUsage, reading any input from in and writing output to out.
+// Changes are recorded with ar, attributed to commandUser.
+func runCommand(args []string, store config.Store, cl ConfigLister, ar AuditRecorder, in io.Reader, out io.Writer) error {
+	if len(args) < 2 {
+		return errors.New(commandUsage)
+	}
+
+	record := func(action, target, detail string) {
+		ar.Record(AuditEvent{Time: time.Now(), User: commandUser, Action: action, Target: target, Detail: detail})
+	}
+
+	switch args[0] + " " + args[1] {
+	case "users list":
+		return listUsers(store, out)
+	case "users add":
+		return withUser(args, store, func(um *config.UserManager, name string) error {
+			password, err := commandPassword(args, in)
+			if err != nil {
+				return err
+			}
+			if err := um.AddUser(name, password, commandUser); err != nil {
+				return err
+			}
+			record(auditUserCreate, name, "command line")
+			return nil
+		})
+	case "users passwd":
+		return withUser(args, store, func(um *config.UserManager, name string) error {
+			password, err := commandPassword(args, in)
+			if err != nil {
+				return err
+			}
+			if err := um.SetPassword(name, password, commandUser); err != nil {
+				return err
+			}
+			record(auditUserPassword, name, "command line")
+			return nil
+		})
+	case "users grant":
+		return withUser(args, store, func(um *config.UserManager, name string) error {
+			if len(args) != 4 {
+				return errors.New(commandUsage)
+			}
+			permission, ok := permissionNames[strings.ToLower(args[3])]
+			if !ok {
+				return fmt.Errorf("invalid permission: %s", args[3])
+			}
+			if err := um.SetPermission(name, permission, commandUser); err != nil {
+				return err
+			}
+			record(auditUserPermissions, name, permission.String())
+			return nil
+		})
+	case "users reset-2fa":
+		return withUser(args, store, func(um *config.UserManager, name string) error {
+			if err := um.DisableTotp(name, commandUser); err != nil {
+				return err
+			}
+			record(auditUserTwoFactor, name, "turned off")
+			return nil
+		})
+	case "config dump":
+		return dumpConfig(store, cl, out)
+	case "config restore":
+		if len(args) != 3 {
+			return errors.New(commandUsage)
+		}
+		var dump []byte
+		var err error
+		if args[2] == "-" {
+			dump, err = io.ReadAll(in)
+		} else {
+			dump, err = os.ReadFile(args[2])
+		}
+		if err != nil {
+			return err
+		}
+		names, err := restoreConfig(store, dump)
+		for i := range names {
+			record(auditConfigRestore, names[i], "")
+		}
+		return err
+	case "config reset":
+		if len(args) != 3 {
+			return errors.New(commandUsage)
+		}
+		if err := resetConfig(store, cl, args[2]); err != nil {
+			return err
+		}
+		record(auditConfigReset, args[2], "")
+		return nil
+	case "secrets reset":
+		if err := config.ResetSecrets(store, commandUser); err != nil {
+			return err
+		}
+		record(auditConfigSecrets, "", "reset")
+		return nil
+	default:
+		return errors.New(commandUsage)
+	}
+}
+
+// withUser loads the users and calls f with the username given as the command's third argument.
+func withUser(args []string, store config.Store, f func(um *config.UserManager, name string) error) error {
+	if len(args) < 3 {
+		return errors.New(commandUsage)
+	}
+
+	um, err := config.NewUserManager(store)
+	if err != nil {
+		return err
+	}
+	return f(um, args[2])
+}
+
+// commandPassword returns the password given as the command's fourth argument, or else the first line of input.
+func commandPassword(args []string, in io.Reader) (string, error) {
+	if len(args) > 3 {
+		return args[3], nil
+	}
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", errors.New("no password given")
+	}
+	return password, nil
+}
+
+func listUsers(store config.Store, out io.Writer) error {
+	um, err := config.NewUserManager(store)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tPERMISSIONS\tTWO-FACTOR\tGROUPS")
+	for _, user := range um.Users() {
+		twoFactor := "off"
+		if user.TwoFactorEnabled() {
+			twoFactor = "on"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", user.Name, user.Permissions, twoFactor, strings.Join(user.Groups(), ","))
+	}
+	return w.Flush()
+}
+
+// dumpConfig writes every setting as a JSON object keyed by the settings' names.
+func dumpConfig(store config.Store, cl ConfigLister, out io.Writer) error {
+	names, err := cl.ConfigNames()
+	if err != nil {
+		return err
+	}
+
+	dump := make(map[string]json.RawMessage)
+	for i := range names {
+		var settings json.RawMessage
+		if err := store.GetSettings(names[i], &settings); err != nil {
+			return err
+		}
+		dump[names[i]] = settings
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(dump)
+}
+
+// restoreConfig saves each of the settings in a dump created by dumpConfig, returning the names of those that were
+// saved. Settings not in the dump are unchanged.
+func restoreConfig(store config.Store, b []byte) ([]string, error) {
+	dump := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &dump); err != nil {
+		return nil, fmt.Errorf("unable to read dump: %w", err)
+	}
+
+	names := make([]string, 0, len(dump))
+	for name := range dump {
+		if !validConfigName.MatchString(name) {
+			return nil, fmt.Errorf("invalid settings name in dump: %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i := range names {
+		if err := store.PutSettings(names[i], commandUser, fmt.Sprintf("Restoring %s settings", names[i]), dump[names[i]]); err != nil {
+			return names[:i], err
+		}
+	}
+	return names, nil
+}
+
+// resetConfig replaces the named settings with empty ones, without reading them first, so that settings encrypted
+// with a lost key can be started again. The wiki uses its defaults for anything that's missing.
+func resetConfig(store config.Store, cl ConfigLister, name string) error {
+	names, err := cl.ConfigNames()
+	if err != nil {
+		return err
+	}
+
+	for i := range names {
+		if names[i] == name {
+			return store.PutSettings(name, commandUser, fmt.Sprintf("Resetting %s settings", name), json.RawMessage("{}"))
+		}
+	}
+	return fmt.Errorf("no settings named %q", name)
+}
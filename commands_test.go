@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mdbot/wiki/config"
+)
+
+const testKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestRunCommand_Users(t *testing.T) {
+	backend := newTestBackend(t)
+	store, err := config.NewStore(backend, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &testAuditRecorder{}
+	run := func(input string, args ...string) (string, error) {
+		out := &bytes.Buffer{}
+		err := runCommand(args, store, backend, recorder, strings.NewReader(input), out)
+		return out.String(), err
+	}
+
+	steps := [][]string{
+		{"users", "add", "admin", "adminpass"},
+		{"users", "add", "alice", "alicepass"},
+		{"users", "grant", "alice", "write"},
+	}
+	for i := range steps {
+		if _, err := run("", steps[i]...); err != nil {
+			t.Fatalf("%v: %v", steps[i], err)
+		}
+	}
+	if _, err := run("newpass\n", "users", "passwd", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	um, err := config.NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.Authenticate("alice", "newpass"); err != nil {
+		t.Errorf("Authenticate() with password from stdin error = %v", err)
+	}
+	if !um.User("alice").Has(config.PermissionWrite) || um.User("alice").Has(config.PermissionAdmin) {
+		t.Errorf("alice has permissions %s, want write", um.User("alice").Permissions)
+	}
+
+	out, err := run("", "users", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "admin") || !strings.Contains(out, "alice  ") || !strings.Contains(out, "write") {
+		t.Errorf("users list = %q, want both users", out)
+	}
+
+	var actions []string
+	for i := range recorder.events {
+		if recorder.events[i].User != commandUser {
+			t.Errorf("event %+v not attributed to %s", recorder.events[i], commandUser)
+		}
+		actions = append(actions, recorder.events[i].Action+" "+recorder.events[i].Target)
+	}
+	want := "user.create admin,user.create alice,user.permissions alice,user.password alice"
+	if strings.Join(actions, ",") != want {
+		t.Errorf("recorded %v, want %s", actions, want)
+	}
+
+	failures := [][]string{
+		{"users", "grant", "alice", "superuser"},
+		{"users", "grant", "admin", "read"},
+		{"users", "passwd", "carol", "password"},
+		{"users", "add", "alice", "password"},
+		{"users", "add", "bob"},
+		{"users"},
+		{"pages", "delete"},
+	}
+	for i := range failures {
+		if _, err := run("", failures[i]...); err == nil {
+			t.Errorf("%v succeeded", failures[i])
+		}
+	}
+}
+
+func TestRunCommand_Config(t *testing.T) {
+	backend := newTestBackend(t)
+	store, err := config.NewStore(backend, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.LoadSecrets(store); err != nil {
+		t.Fatal(err)
+	}
+	site, err := config.LoadSite(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := site.Update(&config.Site{Name: "Test wiki"}, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	dump := &bytes.Buffer{}
+	recorder := &testAuditRecorder{}
+	if err := runCommand([]string{"config", "dump"}, store, backend, recorder, nil, dump); err != nil {
+		t.Fatal(err)
+	}
+	var settings map[string]json.RawMessage
+	if err := json.Unmarshal(dump.Bytes(), &settings); err != nil {
+		t.Fatalf("config dump wrote invalid JSON: %v", err)
+	}
+	if len(settings) != 2 || !strings.Contains(string(settings["site"]), "Test wiki") {
+		t.Errorf("config dump = %s, want the site and secrets settings", dump)
+	}
+
+	// Restore into a new store with a different key
+	restored, err := config.NewStore(backend, strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runCommand([]string{"config", "restore", "-"}, restored, backend, recorder, bytes.NewReader(dump.Bytes()), nil); err != nil {
+		t.Fatal(err)
+	}
+	site, err = config.LoadSite(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if site.Name != "Test wiki" {
+		t.Errorf("site name after restoring = %q, want Test wiki", site.Name)
+	}
+
+	bad := `{"../users": {}}`
+	if err := runCommand([]string{"config", "restore", "-"}, restored, backend, recorder, strings.NewReader(bad), nil); err == nil {
+		t.Error("config restore accepted an invalid settings name")
+	}
+
+	before, err := config.LoadSecrets(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runCommand([]string{"secrets", "reset"}, restored, backend, recorder, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	after, err := config.LoadSecrets(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(before.SessionKey, after.SessionKey) || bytes.Equal(before.CsrfKey, after.CsrfKey) {
+		t.Error("secrets reset didn't change the keys")
+	}
+}
+
+func TestRunCommand_ConfigReset(t *testing.T) {
+	backend := newTestBackend(t)
+	lost, err := config.NewStore(backend, strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runCommand([]string{"users", "add", "admin", "oldpass"}, lost, backend, &testAuditRecorder{}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// With the key lost, users can't be changed until their settings are reset
+	store, err := config.NewStore(backend, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &testAuditRecorder{}
+	if err := runCommand([]string{"users", "add", "admin", "newpass"}, store, backend, recorder, nil, nil); err == nil {
+		t.Fatal("users add succeeded with undecryptable users")
+	}
+	if err := runCommand([]string{"config", "reset", "missing"}, store, backend, recorder, nil, nil); err == nil {
+		t.Error("config reset succeeded for settings that don't exist")
+	}
+	if err := runCommand([]string{"config", "reset", "users"}, store, backend, recorder, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCommand([]string{"users", "add", "admin", "newpass"}, store, backend, recorder, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	um, err := config.NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.Authenticate("admin", "newpass"); err != nil {
+		t.Errorf("Authenticate() after reset error = %v", err)
+	}
+	if len(recorder.events) != 2 || recorder.events[0].Action != auditConfigReset || recorder.events[0].Target != "users" {
+		t.Errorf("recorded %+v, want the reset and the new user", recorder.events)
+	}
+}
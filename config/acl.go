@@ -0,0 +1,161 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const aclSettingsName = "acl"
+
+// AclRule restricts access to the pages and files under a path prefix. If Read or Write are non-empty, only the
+// users listed may perform that action; otherwise the rule doesn't restrict it. Groups can be listed by prefixing
+// their name with an @, e.g. "@editors".
+type AclRule struct {
+	Prefix string
+	Read   []string
+	Write  []string
+}
+
+// Matches determines whether the rule applies to the given page or file name.
+func (r *AclRule) Matches(name string) bool {
+	return name == r.Prefix || strings.HasPrefix(name, r.Prefix+"/")
+}
+
+// Acl restricts access to parts of the wiki, on top of the permissions granted to each user. Every rule that
+// matches a path must allow an action for it to be permitted.
+type Acl struct {
+	mutex sync.RWMutex
+	Rules []AclRule
+
+	store Store
+}
+
+func LoadAcl(store Store) (*Acl, error) {
+	a := &Acl{
+		store: store,
+	}
+
+	if err := store.GetSettings(aclSettingsName, &a); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Update replaces all rules with the given ones.
+func (a *Acl) Update(rules []AclRule, responsible string) error {
+	var newRules []AclRule
+	seen := make(map[string]bool)
+	for i := range rules {
+		rule := AclRule{
+			Prefix: NormalisePath(rules[i].Prefix),
+			Read:   normalisePrincipals(rules[i].Read),
+			Write:  normalisePrincipals(rules[i].Write),
+		}
+
+		if rule.Prefix == "" {
+			return fmt.Errorf("rules must have a path prefix")
+		}
+
+		if seen[rule.Prefix] {
+			return fmt.Errorf("multiple rules for prefix %s", rule.Prefix)
+		}
+		seen[rule.Prefix] = true
+
+		newRules = append(newRules, rule)
+	}
+
+	sort.Slice(newRules, func(i, j int) bool {
+		return newRules[i].Prefix < newRules[j].Prefix
+	})
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.Rules = newRules
+	return a.store.PutSettings(aclSettingsName, responsible, "Updating access control rules", a)
+}
+
+// AllRules returns a copy of all the rules.
+func (a *Acl) AllRules() []AclRule {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return append([]AclRule(nil), a.Rules...)
+}
+
+// HasReadRules determines whether any rules restrict who can read pages.
+func (a *Acl) HasReadRules() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	for i := range a.Rules {
+		if len(a.Rules[i].Read) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// CanRead determines whether the rules allow the user (which may be nil) to read the given page or file.
+func (a *Acl) CanRead(user *User, name string) bool {
+	return a.allowed(user, name, func(r *AclRule) []string { return r.Read })
+}
+
+// CanWrite determines whether the rules allow the user (which may be nil) to modify the given page or file.
+func (a *Acl) CanWrite(user *User, name string) bool {
+	return a.allowed(user, name, func(r *AclRule) []string { return r.Write })
+}
+
+func (a *Acl) allowed(user *User, name string, principals func(r *AclRule) []string) bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	name = NormalisePath(name)
+	for i := range a.Rules {
+		if !a.Rules[i].Matches(name) {
+			continue
+		}
+
+		allowed := principals(&a.Rules[i])
+		if len(allowed) > 0 && !matchesPrincipal(user, allowed) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesPrincipal(user *User, principals []string) bool {
+	if user == nil {
+		return false
+	}
+
+	for i := range principals {
+		if group, ok := strings.CutPrefix(principals[i], "@"); ok {
+			if user.InGroup(group) {
+				return true
+			}
+		} else if strings.EqualFold(principals[i], user.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalisePath converts a page or file name into the canonical form used when matching rules.
+func NormalisePath(name string) string {
+	return strings.ToLower(strings.TrimPrefix(path.Clean("/"+strings.TrimSpace(name)), "/"))
+}
+
+func normalisePrincipals(principals []string) []string {
+	var res []string
+	for i := range principals {
+		if p := strings.TrimSpace(principals[i]); p != "" {
+			res = append(res, p)
+		}
+	}
+	return res
+}
@@ -1,20 +1,19 @@
 package config
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"encoding/hex"
 	"encoding/json"
-	"errors"
-	"io"
-	"log"
+	"fmt"
 	"os"
+	"sync"
 )
 
 type Backend interface {
 	GetConfig(name string) ([]byte, error)
 	PutConfig(name string, content []byte, user, message string) error
+	// ConfigNames lists the names of all the settings that have been saved.
+	ConfigNames() ([]string, error)
+	// PutConfigs saves several settings at once, in a single change.
+	PutConfigs(contents map[string][]byte, user, message string) error
 }
 
 type Store interface {
@@ -22,22 +21,27 @@ type Store interface {
 	PutSettings(name, user, message string, val interface{}) error
 }
 
-func NewStore(backend Backend, key string) Store {
-	keyBytes, _ := hex.DecodeString(key)
-	if len(keyBytes) == 32 {
-		var k [32]byte
-		copy(k[:], keyBytes)
-		return &EncryptedStore{
-			key:     k,
-			backend: backend,
-		}
-	} else {
-		return &DummyStore{}
+// NewStore creates a store that encrypts settings with the given key. Settings that were encrypted with any of the
+// extra keys can still be read, until they're re-encrypted with Rotate. If no key is given, settings are only kept in
+// memory.
+func NewStore(backend Backend, key string, extraKeys ...string) (Store, error) {
+	if key == "" && len(extraKeys) == 0 {
+		return NewMemoryStore(), nil
+	}
+
+	keys, err := parseKeyring(append([]string{key}, extraKeys...)...)
+	if err != nil {
+		return nil, err
 	}
+
+	return &EncryptedStore{
+		keys:    keys,
+		backend: backend,
+	}, nil
 }
 
 type EncryptedStore struct {
-	key     [32]byte
+	keys    keyring
 	backend Backend
 }
 
@@ -50,27 +54,9 @@ func (c EncryptedStore) GetSettings(name string, val interface{}) error {
 		return err
 	}
 
-	block, err := aes.NewCipher(c.key[:])
-	if err != nil {
-		return err
-	}
-
-	gcm, err := cipher.NewGCM(block)
+	decrypted, _, err := c.keys.decrypt(data)
 	if err != nil {
-		return err
-	}
-
-	if len(data) < gcm.NonceSize() {
-		return errors.New("malformed ciphertext")
-	}
-
-	decrypted, err := gcm.Open(nil,
-		data[:gcm.NonceSize()],
-		data[gcm.NonceSize():],
-		nil,
-	)
-	if err != nil {
-		return err
+		return fmt.Errorf("unable to read %s settings: %w", name, err)
 	}
 
 	return json.Unmarshal(decrypted, &val)
@@ -82,33 +68,83 @@ func (c EncryptedStore) PutSettings(name, user, message string, val interface{})
 		return err
 	}
 
-	block, err := aes.NewCipher(c.key[:])
+	encrypted, err := c.keys.encrypt(data)
 	if err != nil {
 		return err
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	return c.backend.PutConfig(name, encrypted, user, message)
+}
+
+// Rotate re-encrypts any settings that weren't encrypted with the store's main key, in a single change, and returns
+// how many were re-encrypted. It fails without changing anything if some settings can't be decrypted.
+func (c EncryptedStore) Rotate(user string) (int, error) {
+	names, err := c.backend.ConfigNames()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	nonce := make([]byte, gcm.NonceSize())
-	_, err = io.ReadFull(rand.Reader, nonce)
-	if err != nil {
-		return err
+	contents := make(map[string][]byte)
+	for i := range names {
+		data, err := c.backend.GetConfig(names[i])
+		if err != nil {
+			return 0, err
+		}
+
+		decrypted, key, err := c.keys.decrypt(data)
+		if err != nil {
+			return 0, fmt.Errorf("unable to read %s settings: %w", names[i], err)
+		}
+		if key == 0 {
+			continue
+		}
+
+		if contents[names[i]], err = c.keys.encrypt(decrypted); err != nil {
+			return 0, err
+		}
 	}
 
-	encrypted := gcm.Seal(nonce, nonce, data, nil)
-	return c.backend.PutConfig(name, encrypted, user, message)
+	if len(contents) == 0 {
+		return 0, nil
+	}
+	return len(contents), c.backend.PutConfigs(contents, user, "Re-encrypt settings with new key")
 }
 
-type DummyStore struct{}
+// MemoryStore keeps settings in memory, so they're lost when the wiki stops. It's used when no encryption key is
+// given, so that the wiki is still usable for trying it out or development.
+type MemoryStore struct {
+	mutex    sync.Mutex
+	settings map[string][]byte
+}
 
-func (d DummyStore) GetSettings(name string, _ interface{}) error {
-	log.Printf("Warning: no encryption key specified, using a blank '%s' config\n", name)
-	return nil
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		settings: make(map[string][]byte),
+	}
+}
+
+func (m *MemoryStore) GetSettings(name string, val interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	data, ok := m.settings[name]
+	if !ok {
+		return nil
+	}
+
+	return json.Unmarshal(data, &val)
 }
 
-func (d DummyStore) PutSettings(string, string, string, interface{}) error {
-	return errors.New("no encryption key specified; config cannot be saved")
+func (m *MemoryStore) PutSettings(name, _, _ string, val interface{}) error {
+	// Settings are kept encoded, so later changes to val don't affect what was saved
+	data, err := json.Marshal(&val)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.settings[name] = data
+	return nil
 }
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const (
+	testOldKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	testNewKey = "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff000102030405060708090a0b0c0d0e0f"
+)
+
+// testBackend keeps config in memory, and counts the changes made to it.
+type testBackend struct {
+	files   map[string][]byte
+	changes int
+}
+
+func (b *testBackend) GetConfig(name string) ([]byte, error) {
+	if data, ok := b.files[name]; ok {
+		return data, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func (b *testBackend) PutConfig(name string, content []byte, _, _ string) error {
+	return b.PutConfigs(map[string][]byte{name: content}, "", "")
+}
+
+func (b *testBackend) ConfigNames() ([]string, error) {
+	var res []string
+	for name := range b.files {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (b *testBackend) PutConfigs(contents map[string][]byte, _, _ string) error {
+	for name := range contents {
+		b.files[name] = contents[name]
+	}
+	b.changes++
+	return nil
+}
+
+// legacyEncrypt encrypts data the way settings were before keyrings, with no key ID.
+func legacyEncrypt(t *testing.T, key string, data []byte) []byte {
+	b, _ := hex.DecodeString(key)
+	block, err := aes.NewCipher(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	return gcm.Seal(nonce, nonce, data, nil)
+}
+
+type testSettings struct {
+	Value string
+}
+
+func TestEncryptedStore(t *testing.T) {
+	backend := &testBackend{files: map[string][]byte{}}
+	old, err := NewStore(backend, testOldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.PutSettings("current", "test", "save", &testSettings{"current"}); err != nil {
+		t.Fatal(err)
+	}
+	backend.files["legacy"] = legacyEncrypt(t, testOldKey, []byte(`{"Value":"legacy"}`))
+
+	// The new key can't read the old settings on its own
+	store, err := NewStore(backend, testNewKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.GetSettings("current", &testSettings{}); err == nil {
+		t.Error("GetSettings() succeeded with the wrong key")
+	}
+
+	store, err = NewStore(backend, testNewKey, testOldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"current", "legacy"} {
+		settings := &testSettings{}
+		if err := store.GetSettings(name, settings); err != nil || settings.Value != name {
+			t.Errorf("GetSettings(%s) = %+v, %v", name, settings, err)
+		}
+	}
+
+	if err := store.PutSettings("new", "test", "save", &testSettings{"new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.GetSettings("new", &testSettings{}); err == nil {
+		t.Error("new settings were encrypted with the old key")
+	}
+
+	changes := backend.changes
+	count, err := store.(*EncryptedStore).Rotate("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || backend.changes != changes+1 {
+		t.Errorf("Rotate() re-encrypted %d settings in %d changes, want 2 in 1", count, backend.changes-changes)
+	}
+
+	// Once rotated, the old key is no longer needed
+	store, err = NewStore(backend, testNewKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"current", "legacy", "new"} {
+		settings := &testSettings{}
+		if err := store.GetSettings(name, settings); err != nil || settings.Value != name {
+			t.Errorf("GetSettings(%s) after rotating = %+v, %v", name, settings, err)
+		}
+	}
+
+	if count, err := store.(*EncryptedStore).Rotate("test"); err != nil || count != 0 {
+		t.Errorf("Rotate() again = %d, %v, want nothing to do", count, err)
+	}
+}
+
+func TestEncryptedStore_RotateUndecryptable(t *testing.T) {
+	backend := &testBackend{files: map[string][]byte{
+		"legacy": legacyEncrypt(t, testOldKey, []byte(`{}`)),
+		"other":  legacyEncrypt(t, strings.Repeat("ab", 32), []byte(`{}`)),
+	}}
+	store, err := NewStore(backend, testNewKey, testOldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.(*EncryptedStore).Rotate("test"); !errors.Is(err, errUndecryptable) {
+		t.Errorf("Rotate() error = %v, want %v", err, errUndecryptable)
+	}
+	if backend.changes != 0 {
+		t.Errorf("Rotate() made %d changes, want none", backend.changes)
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		extraKeys []string
+		wantErr   bool
+	}{
+		{"no key", "", nil, false},
+		{"key", testNewKey, nil, false},
+		{"extra keys", testNewKey, []string{testOldKey, " " + strings.Repeat("ab", 32)}, false},
+		{"short key", "0011", nil, true},
+		{"invalid extra key", testNewKey, []string{"not hex"}, true},
+		{"extra keys without a key", "", []string{testOldKey}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewStore(&testBackend{}, tt.key, tt.extraKeys...); (err != nil) != tt.wantErr {
+				t.Errorf("NewStore() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store, err := NewStore(&testBackend{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.(*MemoryStore); !ok {
+		t.Fatalf("NewStore() without a key = %T, want *MemoryStore", store)
+	}
+
+	settings := &testSettings{"default"}
+	if err := store.GetSettings("missing", settings); err != nil || settings.Value != "default" {
+		t.Errorf("GetSettings() for missing settings = %+v, %v, want them unchanged", settings, err)
+	}
+
+	settings.Value = "saved"
+	if err := store.PutSettings("test", "test", "save", settings); err != nil {
+		t.Fatal(err)
+	}
+	settings.Value = "changed"
+
+	loaded := &testSettings{}
+	if err := store.GetSettings("test", loaded); err != nil || loaded.Value != "saved" {
+		t.Errorf("GetSettings() = %+v, %v, want the saved value", loaded, err)
+	}
+
+	// Users added without a key should be kept until the wiki stops
+	um, err := NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := um.AddUser("admin", "password", "test"); err != nil {
+		t.Fatal(err)
+	}
+	um, err = NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.Authenticate("admin", "password"); err != nil {
+		t.Errorf("Authenticate() after reloading error = %v", err)
+	}
+}
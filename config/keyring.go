@@ -0,0 +1,108 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// keyringMagic starts settings encrypted with a keyring. It's followed by the ID of the key that encrypted them, and
+// then the nonce and ciphertext. Settings written before keyrings existed are just the nonce and ciphertext.
+var keyringMagic = []byte("wk1:")
+
+const keyIDSize = 8
+
+var errUndecryptable = errors.New("unable to decrypt with any key")
+
+type keyringKey struct {
+	id   [keyIDSize]byte
+	aead cipher.AEAD
+}
+
+// keyring holds the keys settings may be encrypted with. The first key is used to encrypt, and all of them are tried
+// when decrypting, so settings can still be read while they're being re-encrypted with a new key.
+type keyring []keyringKey
+
+// parseKeyring creates a keyring from hex encoded 32 byte keys, with the first being used for encryption.
+func parseKeyring(keys ...string) (keyring, error) {
+	var res keyring
+	for i := range keys {
+		b, err := hex.DecodeString(strings.TrimSpace(keys[i]))
+		if err != nil || len(b) != 32 {
+			return nil, fmt.Errorf("key %d is not 32 hex encoded bytes", i+1)
+		}
+
+		block, err := aes.NewCipher(b)
+		if err != nil {
+			return nil, err
+		}
+
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		k := keyringKey{aead: gcm}
+		sum := sha256.Sum256(b)
+		copy(k.id[:], sum[:])
+		res = append(res, k)
+	}
+
+	if len(res) == 0 {
+		return nil, errors.New("no keys specified")
+	}
+	return res, nil
+}
+
+// encrypt encrypts the data with the first key, prefixed by the key's ID.
+func (k keyring) encrypt(data []byte) ([]byte, error) {
+	key := k[0]
+	header := append(append([]byte{}, keyringMagic...), key.id[:]...)
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return key.aead.Seal(append(header, nonce...), nonce, data, header), nil
+}
+
+// decrypt decrypts data encrypted by any key in the keyring, and returns the index of the key that was used.
+func (k keyring) decrypt(data []byte) ([]byte, int, error) {
+	headerSize := len(keyringMagic) + keyIDSize
+	if len(data) > headerSize && bytes.HasPrefix(data, keyringMagic) {
+		header := data[:headerSize]
+		for i := range k {
+			if !bytes.Equal(k[i].id[:], header[len(keyringMagic):]) {
+				continue
+			}
+			if plain, err := open(k[i].aead, data[headerSize:], header); err == nil {
+				return plain, i, nil
+			}
+		}
+	}
+
+	// Fall back to the format used before keyrings, which doesn't say which key was used
+	for i := range k {
+		if plain, err := open(k[i].aead, data, nil); err == nil {
+			return plain, i, nil
+		}
+	}
+
+	return nil, 0, errUndecryptable
+}
+
+func open(aead cipher.AEAD, data, additionalData []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("malformed ciphertext")
+	}
+
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additionalData)
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
+)
+
+const mailSettingsName = "mail"
+
+// MailServer describes the SMTP server used to send email.
+type MailServer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is the address that email is sent from.
+	From string
+}
+
+type Mail struct {
+	mutex sync.RWMutex
+	MailServer
+
+	store Store
+}
+
+func LoadMail(store Store) (*Mail, error) {
+	m := &Mail{
+		store: store,
+	}
+
+	if err := store.GetSettings(mailSettingsName, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Server returns a copy of the current mail server settings.
+func (m *Mail) Server() MailServer {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.MailServer
+}
+
+// Configured determines whether enough settings have been provided to send email.
+func (m *Mail) Configured() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.Host != "" && m.From != ""
+}
+
+// Update replaces the mail server settings. If no password is given, the existing one is kept unless the username
+// has been cleared.
+func (m *Mail) Update(server MailServer, responsible string) error {
+	server.Host = strings.TrimSpace(server.Host)
+	server.Username = strings.TrimSpace(server.Username)
+
+	if server.Port == 0 {
+		server.Port = 25
+	} else if server.Port < 0 || server.Port > 65535 {
+		return fmt.Errorf("invalid port: %d", server.Port)
+	}
+
+	if server.From = strings.TrimSpace(server.From); server.From != "" {
+		if _, err := mail.ParseAddress(server.From); err != nil {
+			return fmt.Errorf("invalid from address")
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if server.Password == "" && server.Username != "" {
+		server.Password = m.Password
+	}
+
+	m.MailServer = server
+	return m.store.PutSettings(mailSettingsName, responsible, "Updating mail server settings", m)
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// passwordResetExpiry is how long a password reset link can be used for.
+	passwordResetExpiry = time.Hour
+	// passwordResetInterval is how long users must wait before requesting another reset, to stop the wiki being
+	// used to flood their inbox.
+	passwordResetInterval = 5 * time.Minute
+)
+
+// passwordReset describes a requested password reset. Only a hash of the token is kept, and as it isn't persisted
+// outstanding links stop working when the wiki restarts.
+type passwordReset struct {
+	hash      []byte
+	requested time.Time
+}
+
+// BeginPasswordReset finds the account with the given username or email address, and returns a token that can be
+// passed to ResetPassword to set a new password without knowing the old one. Any earlier token for the account
+// stops working.
+func (a *UserManager) BeginPasswordReset(nameOrEmail string) (*User, string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	nameOrEmail = strings.TrimSpace(nameOrEmail)
+	user := a.users[strings.ToLower(nameOrEmail)]
+	if user == nil && nameOrEmail != "" {
+		for _, u := range a.users {
+			if !strings.EqualFold(u.Email, nameOrEmail) {
+				continue
+			}
+
+			// Addresses should be unique, but if they're not we can't tell who the reset is for
+			if user != nil {
+				return nil, "", errors.New("email address is used by more than one account")
+			}
+			user = u
+		}
+	}
+
+	if user == nil {
+		return nil, "", errors.New("user does not exist")
+	}
+
+	if user.Email == "" {
+		return nil, "", errors.New("user has no email address")
+	}
+
+	if user.Subject != "" {
+		return nil, "", errors.New("user logs in with single sign-on")
+	}
+
+	if !user.Has(PermissionAuth) {
+		return nil, "", errors.New("account disabled")
+	}
+
+	if user.reset != nil && time.Since(user.reset.requested) < passwordResetInterval {
+		return nil, "", errors.New("a password reset was requested recently")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	hash := sha256.Sum256([]byte(token))
+	user = a.editUser(user.Name)
+	user.reset = &passwordReset{
+		hash:      hash[:],
+		requested: time.Now(),
+	}
+	return user, token, nil
+}
+
+// PasswordResetUser returns the user that a password reset token was issued for, if it is still valid.
+func (a *UserManager) PasswordResetUser(token string) (*User, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.passwordResetUser(token)
+}
+
+func (a *UserManager) passwordResetUser(token string) (*User, error) {
+	hash := sha256.Sum256([]byte(token))
+	for _, user := range a.users {
+		if user.reset != nil && subtle.ConstantTimeCompare(user.reset.hash, hash[:]) == 1 {
+			if time.Since(user.reset.requested) > passwordResetExpiry {
+				return nil, errors.New("the password reset link has expired")
+			}
+			return user, nil
+		}
+	}
+	return nil, errors.New("the password reset link is invalid or has already been used")
+}
+
+// ResetPassword sets a new password for the user that a password reset token was issued for. Each token can only be
+// used once.
+func (a *UserManager) ResetPassword(token, password string) (*User, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user, err := a.passwordResetUser(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if password == "" {
+		return nil, errors.New("password must not be empty")
+	}
+
+	user = a.editUser(user.Name)
+
+	if err := a.setPassword(user, password); err != nil {
+		return nil, err
+	}
+
+	user.reset = nil
+	return user, a.save(user.Name, fmt.Sprintf("Resetting password for user: %s", user.Name))
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUserManager_PasswordReset(t *testing.T) {
+	um, err := NewUserManager(testStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = um.AddUser("admin", "password", "test")
+	_ = um.AddUser("alice", "password", "test")
+	_ = um.AddUser("bob", "password", "test")
+	_ = um.SetEmail("alice", "Alice <alice@example.com>", "test")
+	_ = um.SetPermission("alice", PermissionRead, "test")
+	_ = um.SetPermission("bob", PermissionRead, "test")
+
+	if _, _, err := um.BeginPasswordReset("bob"); err == nil {
+		t.Error("BeginPasswordReset() succeeded for a user without an email address")
+	}
+	if _, _, err := um.BeginPasswordReset("nobody"); err == nil {
+		t.Error("BeginPasswordReset() succeeded for a user that doesn't exist")
+	}
+
+	user, token, err := um.BeginPasswordReset("ALICE@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "alice" {
+		t.Errorf("BeginPasswordReset() found user %s by email, want alice", user.Name)
+	}
+	if _, _, err := um.BeginPasswordReset("alice"); err == nil {
+		t.Error("BeginPasswordReset() allowed another reset straight away")
+	}
+
+	if user, err := um.PasswordResetUser(token); err != nil || user.Name != "alice" {
+		t.Errorf("PasswordResetUser() = %v, %v, want alice", user, err)
+	}
+	if _, err := um.PasswordResetUser("made up"); err == nil {
+		t.Error("PasswordResetUser() accepted an unknown token")
+	}
+
+	sessionKey := um.User("alice").SessionKey
+	if _, err := um.ResetPassword(token, "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.Authenticate("alice", "new password"); err != nil {
+		t.Errorf("Authenticate() rejected the new password: %v", err)
+	}
+	if string(um.User("alice").SessionKey) == string(sessionKey) {
+		t.Error("ResetPassword() didn't change the session key")
+	}
+	if _, err := um.ResetPassword(token, "another password"); err == nil {
+		t.Error("ResetPassword() accepted a token twice")
+	}
+
+	// Tokens stop working after they expire
+	um.User("alice").reset = nil
+	_, token, err = um.BeginPasswordReset("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	um.User("alice").reset.requested = time.Now().Add(-passwordResetExpiry - time.Minute)
+	if _, err := um.ResetPassword(token, "another password"); err == nil {
+		t.Error("ResetPassword() accepted an expired token")
+	}
+}
+
+func TestUserManager_PasswordResetSharedEmail(t *testing.T) {
+	um, err := NewUserManager(testStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = um.AddUser("admin", "password", "test")
+	_ = um.AddUser("alice", "password", "test")
+	_ = um.AddUser("bob", "password", "test")
+	if err := um.SetEmail("alice", "alice@example.com", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := um.SetEmail("bob", "ALICE@example.com", "test"); err == nil {
+		t.Error("SetEmail() accepted another account's address")
+	}
+	if err := um.SetNotifications("bob", "alice@example.com", false); err == nil {
+		t.Error("SetNotifications() accepted another account's address")
+	}
+	if err := um.SetNotifications("alice", "alice@example.com", true); err != nil {
+		t.Errorf("SetNotifications() rejected the account's own address: %v", err)
+	}
+
+	// Accounts saved before addresses had to be unique may still share them
+	um.User("bob").Email = "alice@example.com"
+	if _, _, err := um.BeginPasswordReset("alice@example.com"); err == nil {
+		t.Error("BeginPasswordReset() picked one of several accounts with the same address")
+	}
+}
+
+func TestUserManager_PasswordResetConcurrent(t *testing.T) {
+	um, err := NewUserManager(testStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = um.AddUser("admin", "password", "test")
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("user%d", i)
+		_ = um.AddUser(name, "password", "test")
+		_ = um.SetEmail(name, name+"@example.com", "test")
+		_ = um.SetPermission(name, PermissionRead, "test")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, token, err := um.BeginPasswordReset(fmt.Sprintf("user%d@example.com", i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := um.PasswordResetUser(token); err != nil {
+				t.Error(err)
+			}
+			if _, err := um.ResetPassword(token, "new password"); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,333 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Invite lets whoever has the link create an account with the given permissions, once, before it expires. Only a hash
+// of the invite's token is stored.
+type Invite struct {
+	Hash        []byte
+	Permissions Permission
+	Created     time.Time
+	CreatedBy   string
+	Expires     time.Time
+}
+
+// ID identifies the invite without revealing its token.
+func (i *Invite) ID() string {
+	return hex.EncodeToString(i.Hash[:8])
+}
+
+// RegistrationPolicy controls whether people can create their own accounts without an invite.
+type RegistrationPolicy struct {
+	Open bool
+	// Permissions are granted to new accounts.
+	Permissions Permission
+	// Approval indicates that new accounts must be approved by an admin before they can be used.
+	Approval bool
+}
+
+// PendingUser is an account that has been registered, but is waiting to be approved by an admin.
+type PendingUser struct {
+	Name      string
+	Email     string
+	Salt      []byte
+	Password  []byte
+	Requested time.Time
+}
+
+// CreateInvite generates an invite for a new account with the given permissions, returning the token that must be
+// passed to Redeem. The token can't be retrieved again later.
+func (a *UserManager) CreateInvite(permissions Permission, validFor time.Duration, responsible string) (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if permissions != PermissionAuth && permissions != PermissionRead && permissions != PermissionWrite && permissions != PermissionAdmin {
+		return "", errors.New("invalid invite permissions")
+	}
+
+	if validFor <= 0 {
+		return "", errors.New("invalid invite expiry")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	hash := sha256.Sum256([]byte(token))
+	now := time.Now()
+	a.pruneInvites()
+	a.invites = append(a.invites, &Invite{
+		Hash:        hash[:],
+		Permissions: permissions,
+		Created:     now,
+		CreatedBy:   responsible,
+		Expires:     now.Add(validFor),
+	})
+	return token, a.save(responsible, fmt.Sprintf("Creating invite with %s permissions", permissions))
+}
+
+// Invites returns the invites that haven't been used or expired, oldest first.
+func (a *UserManager) Invites() []*Invite {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	var res []*Invite
+	for i := range a.invites {
+		if time.Now().Before(a.invites[i].Expires) {
+			res = append(res, a.invites[i])
+		}
+	}
+	return res
+}
+
+// RevokeInvite deletes the invite with the given ID.
+func (a *UserManager) RevokeInvite(id, responsible string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for i := range a.invites {
+		if a.invites[i].ID() == id {
+			a.invites = append(a.invites[:i:i], a.invites[i+1:]...)
+			return a.save(responsible, "Revoking invite")
+		}
+	}
+	return errors.New("invite does not exist")
+}
+
+// Invite returns the invite with the given token, if it is still valid.
+func (a *UserManager) Invite(token string) (*Invite, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.invite(token)
+}
+
+func (a *UserManager) invite(token string) (*Invite, error) {
+	hash := sha256.Sum256([]byte(token))
+	for i := range a.invites {
+		if subtle.ConstantTimeCompare(a.invites[i].Hash, hash[:]) == 1 {
+			if !time.Now().Before(a.invites[i].Expires) {
+				return nil, errors.New("the invite has expired")
+			}
+			return a.invites[i], nil
+		}
+	}
+	return nil, errors.New("the invite is invalid or has already been used")
+}
+
+// RedeemInvite creates an account using an invite, which can't be used again.
+func (a *UserManager) RedeemInvite(token, username, password, email string) (*User, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	invite, err := a.invite(token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := a.newAccount(username, password, email)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Permissions = invite.Permissions
+	for i := range a.invites {
+		if a.invites[i] == invite {
+			a.invites = append(a.invites[:i:i], a.invites[i+1:]...)
+			break
+		}
+	}
+
+	a.users[strings.ToLower(user.Name)] = user
+	a.updateGroupMembership()
+	return a.users[strings.ToLower(user.Name)], a.save(user.Name, fmt.Sprintf("Adding user %s from invite created by %s", user.Name, invite.CreatedBy))
+}
+
+// RegistrationPolicy returns the current policy for self-registration.
+func (a *UserManager) RegistrationPolicy() RegistrationPolicy {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.registration
+}
+
+// SetRegistrationPolicy changes whether people can register, and what happens when they do. Self-registered accounts
+// can't be given admin permissions.
+func (a *UserManager) SetRegistrationPolicy(policy RegistrationPolicy, responsible string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if policy.Permissions != PermissionAuth && policy.Permissions != PermissionRead && policy.Permissions != PermissionWrite {
+		return errors.New("invalid permissions for new accounts")
+	}
+
+	a.registration = policy
+	return a.save(responsible, "Changing registration settings")
+}
+
+// Register creates an account for someone without an invite, if registration is open. If new accounts need approval,
+// the account is added to the queue of pending users and nil is returned.
+func (a *UserManager) Register(username, password, email string) (*User, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if !a.registration.Open {
+		return nil, errors.New("registration is closed")
+	}
+
+	user, err := a.newAccount(username, password, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.registration.Approval {
+		a.pending[strings.ToLower(user.Name)] = &PendingUser{
+			Name:      user.Name,
+			Email:     user.Email,
+			Salt:      user.Salt,
+			Password:  user.Password,
+			Requested: time.Now(),
+		}
+		return nil, a.save(user.Name, fmt.Sprintf("Registering user awaiting approval: %s", user.Name))
+	}
+
+	user.Permissions = a.registration.Permissions
+	a.users[strings.ToLower(user.Name)] = user
+	a.updateGroupMembership()
+	return a.users[strings.ToLower(user.Name)], a.save(user.Name, fmt.Sprintf("Registering user: %s", user.Name))
+}
+
+// PendingUsers returns the accounts waiting to be approved, oldest first.
+func (a *UserManager) PendingUsers() []*PendingUser {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	var res []*PendingUser
+	for i := range a.pending {
+		res = append(res, a.pending[i])
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Requested.Before(res[j].Requested)
+	})
+	return res
+}
+
+// ApproveUser turns a pending account into a real one, with the permissions given to newly registered accounts.
+func (a *UserManager) ApproveUser(username, responsible string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	pending := a.pending[strings.ToLower(username)]
+	if pending == nil {
+		return errors.New("no such user awaiting approval")
+	}
+
+	key, err := a.randomBytes()
+	if err != nil {
+		return err
+	}
+
+	delete(a.pending, strings.ToLower(username))
+	a.users[strings.ToLower(username)] = &User{
+		Name:        pending.Name,
+		Email:       pending.Email,
+		Salt:        pending.Salt,
+		Password:    pending.Password,
+		SessionKey:  key,
+		Permissions: a.registration.Permissions,
+	}
+	a.updateGroupMembership()
+	return a.save(responsible, fmt.Sprintf("Approving user: %s", pending.Name))
+}
+
+// RejectUser removes an account from the approval queue.
+func (a *UserManager) RejectUser(username, responsible string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	pending := a.pending[strings.ToLower(username)]
+	if pending == nil {
+		return errors.New("no such user awaiting approval")
+	}
+
+	delete(a.pending, strings.ToLower(username))
+	return a.save(responsible, fmt.Sprintf("Rejecting user: %s", pending.Name))
+}
+
+// newAccount validates the details for a new account, and returns a user with no permissions that hasn't been added.
+func (a *UserManager) newAccount(username, password, email string) (*User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" || strings.ContainsAny(username, ", @/") {
+		return nil, errors.New("invalid username")
+	}
+
+	if a.nameTaken(username) {
+		return nil, errors.New("user already exists")
+	}
+
+	if password == "" {
+		return nil, errors.New("password must not be empty")
+	}
+
+	email, err := parseEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.emailTaken(email, username) {
+		return nil, errors.New("email address is used by another account")
+	}
+
+	user := &User{Name: username, Email: email}
+	if err := a.setPassword(user, password); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// nameTaken determines whether an account or pending account already has the given name.
+func (a *UserManager) nameTaken(username string) bool {
+	_, user := a.users[strings.ToLower(username)]
+	_, pending := a.pending[strings.ToLower(username)]
+	return user || pending
+}
+
+// checkPending returns an error explaining that the account is waiting for approval, if the credentials match the
+// pending account.
+func checkPending(pending *PendingUser, password string) error {
+	if pending == nil {
+		return nil
+	}
+
+	salted := append([]byte(password), pending.Salt...)
+	if err := bcrypt.CompareHashAndPassword(pending.Password, salted); err != nil {
+		return nil
+	}
+	return errors.New("account is awaiting approval by an administrator")
+}
+
+// pruneInvites forgets invites that have expired.
+func (a *UserManager) pruneInvites() {
+	var res []*Invite
+	for i := range a.invites {
+		if time.Now().Before(a.invites[i].Expires) {
+			res = append(res, a.invites[i])
+		}
+	}
+	a.invites = res
+}
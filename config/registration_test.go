@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUserManager_Invites(t *testing.T) {
+	store := testStore{}
+	um, err := NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = um.AddUser("admin", "password", "test")
+
+	if _, err := um.CreateInvite(PermissionNone, time.Hour, "admin"); err == nil {
+		t.Error("CreateInvite() accepted an invite with no permissions")
+	}
+
+	token, err := um.CreateInvite(PermissionWrite, time.Hour, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := um.CreateInvite(PermissionRead, time.Hour, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	um.invites[1].Expires = time.Now().Add(-time.Minute)
+
+	if len(um.Invites()) != 1 {
+		t.Errorf("Invites() returned %d invites, want 1", len(um.Invites()))
+	}
+	if _, err := um.RedeemInvite(expired, "bob", "password", ""); err == nil {
+		t.Error("RedeemInvite() accepted an expired invite")
+	}
+	if _, err := um.RedeemInvite(token, "Admin", "password", ""); err == nil {
+		t.Error("RedeemInvite() created a user with an existing name")
+	}
+
+	user, err := um.RedeemInvite(token, "alice", "password", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Has(PermissionWrite) || user.Has(PermissionAdmin) || user.Email != "alice@example.com" {
+		t.Errorf("RedeemInvite() created user %+v, want write permission and an email address", user)
+	}
+	if _, err := um.RedeemInvite(token, "carol", "password", ""); err == nil {
+		t.Error("RedeemInvite() accepted an invite twice")
+	}
+
+	// Invites survive a reload
+	token, err = um.CreateInvite(PermissionRead, time.Hour, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	um, err = NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.Invite(token); err != nil {
+		t.Errorf("Invite() rejected an invite after reloading: %v", err)
+	}
+	if err := um.RevokeInvite(um.Invites()[0].ID(), "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.Invite(token); err == nil {
+		t.Error("Invite() accepted a revoked invite")
+	}
+}
+
+func TestUserManager_Register(t *testing.T) {
+	store := testStore{}
+	um, err := NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = um.AddUser("admin", "password", "test")
+
+	if _, err := um.Register("alice", "password", ""); err == nil {
+		t.Error("Register() succeeded while registration was closed")
+	}
+
+	if err := um.SetRegistrationPolicy(RegistrationPolicy{Open: true, Permissions: PermissionAdmin}, "admin"); err == nil {
+		t.Error("SetRegistrationPolicy() allowed new accounts to be admins")
+	}
+	if err := um.SetRegistrationPolicy(RegistrationPolicy{Open: true, Permissions: PermissionWrite, Approval: true}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	if user, err := um.Register("alice", "password", ""); err != nil || user != nil {
+		t.Fatalf("Register() = %v, %v, want the user to be awaiting approval", user, err)
+	}
+	if _, err := um.Register("ALICE", "password", ""); err == nil {
+		t.Error("Register() accepted the name of a pending user")
+	}
+	if err := um.AddUser("alice", "password", "admin"); err == nil {
+		t.Error("AddUser() accepted the name of a pending user")
+	}
+	if _, err := um.Authenticate("alice", "password"); err == nil || err.Error() != "account is awaiting approval by an administrator" {
+		t.Errorf("Authenticate() for pending user returned %v", err)
+	}
+
+	if _, err := um.Register("bob", "password", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := um.RejectUser("bob", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pending users survive a reload
+	um, err = NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending := um.PendingUsers(); len(pending) != 1 || pending[0].Name != "alice" {
+		t.Fatalf("PendingUsers() = %v, want alice", pending)
+	}
+	if err := um.ApproveUser("alice", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := um.Authenticate("alice", "password"); err != nil || !user.Has(PermissionWrite) {
+		t.Errorf("Authenticate() after approval = %v, %v, want user with write permission", user, err)
+	}
+
+	_ = um.SetRegistrationPolicy(RegistrationPolicy{Open: true, Permissions: PermissionRead}, "admin")
+	if user, err := um.Register("carol", "password", ""); err != nil || user == nil || !user.Has(PermissionRead) || user.Has(PermissionWrite) {
+		t.Errorf("Register() without approval = %v, %v, want user with read permission", user, err)
+	}
+
+	// Groups that already list the new user apply straight away
+	_ = um.AddGroup("editors", "admin")
+	_ = um.SetGroupPermission("editors", PermissionWrite, "admin")
+	um.groups["editors"].Members = []string{"dave"}
+	if user, err := um.Register("dave", "password", ""); err != nil || !user.InGroup("editors") || !user.Has(PermissionWrite) {
+		t.Errorf("Register() for a group member = %v, %v, want user with the group's permissions", user, err)
+	}
+}
+
+func TestUserManager_RedeemInviteConcurrent(t *testing.T) {
+	um, err := NewUserManager(testStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = um.AddUser("admin", "password", "test")
+	token, err := um.CreateInvite(PermissionRead, time.Hour, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An invite can only be used once, however many people try to use it at the same time
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	created := 0
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := um.RedeemInvite(token, fmt.Sprintf("user%d", i), "password", ""); err == nil {
+				mutex.Lock()
+				created++
+				mutex.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if created != 1 || len(um.Users()) != 2 {
+		t.Errorf("invite created %d accounts, want 1", created)
+	}
+}
@@ -21,8 +21,8 @@ func LoadSecrets(store Store) (*Secrets, error) {
 	dirty := false
 
 	if s.SessionKey == nil || len(s.SessionKey) < 32 {
-		newKey := make([]byte, 32)
-		if _, err := io.ReadFull(rand.Reader, newKey); err != nil {
+		newKey, err := newSecretKey()
+		if err != nil {
 			return nil, err
 		}
 		s.SessionKey = newKey
@@ -30,8 +30,8 @@ func LoadSecrets(store Store) (*Secrets, error) {
 	}
 
 	if s.CsrfKey == nil || len(s.CsrfKey) < 32 {
-		newKey := make([]byte, 32)
-		if _, err := io.ReadFull(rand.Reader, newKey); err != nil {
+		newKey, err := newSecretKey()
+		if err != nil {
 			return nil, err
 		}
 		s.CsrfKey = newKey
@@ -44,3 +44,25 @@ func LoadSecrets(store Store) (*Secrets, error) {
 
 	return s, nil
 }
+
+// ResetSecrets replaces the secrets with new random keys, without reading the old ones. This logs everyone out.
+func ResetSecrets(store Store, responsible string) error {
+	s := &Secrets{}
+	var err error
+	if s.SessionKey, err = newSecretKey(); err != nil {
+		return err
+	}
+	if s.CsrfKey, err = newSecretKey(); err != nil {
+		return err
+	}
+
+	return store.PutSettings(secretsSettingsName, responsible, "Resetting secrets", s)
+}
+
+func newSecretKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"sync"
+)
+
+const securitySettingsName = "security"
+
+// Security holds site-wide authentication policies.
+type Security struct {
+	mutex sync.RWMutex
+	// RequireTwoFactor forces users with write or admin permission to set up two-factor authentication.
+	RequireTwoFactor bool
+
+	store Store
+}
+
+func LoadSecurity(store Store) (*Security, error) {
+	s := &Security{
+		store: store,
+	}
+
+	if err := store.GetSettings(securitySettingsName, &s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// TwoFactorRequired determines whether the user must set up two-factor authentication before using the wiki.
+// Users who don't log in with a password are exempt, as their identity provider or reverse proxy is responsible for
+// how they authenticate.
+func (s *Security) TwoFactorRequired(user *User) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.RequireTwoFactor && user.LogsInWithPassword() && !user.TwoFactorEnabled() && user.Has(PermissionWrite)
+}
+
+// TwoFactorMandatory determines whether users with write or admin permission must use two-factor authentication.
+func (s *Security) TwoFactorMandatory() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.RequireTwoFactor
+}
+
+func (s *Security) SetRequireTwoFactor(required bool, responsible string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.RequireTwoFactor = required
+	return s.store.PutSettings(securitySettingsName, responsible, fmt.Sprintf("Setting two-factor requirement to %t", required), s)
+}
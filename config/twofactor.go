@@ -0,0 +1,225 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mdbot/wiki/totp"
+)
+
+const (
+	// recoveryCodeCount is the number of recovery codes generated at a time.
+	recoveryCodeCount = 10
+	// recoveryCodeAlphabet avoids characters that are easily confused with each other. It has 32 characters, so each
+	// random byte can be mapped to it without bias.
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz123456789"
+
+	// maxTwoFactorFailures is the number of incorrect codes allowed before further attempts are refused for
+	// twoFactorBackoff.
+	maxTwoFactorFailures = 5
+	twoFactorBackoff     = 5 * time.Minute
+)
+
+type twoFactorState struct {
+	// pending is the secret being enrolled, which isn't used until the user has proven they can generate codes.
+	pending []byte
+	// lastStep is the time step of the last code used, to stop codes being replayed.
+	lastStep int64
+	failures int
+	failedAt time.Time
+}
+
+// TwoFactorEnabled determines whether the user must provide a one-time password when logging in.
+func (u *User) TwoFactorEnabled() bool {
+	return len(u.TotpSecret) > 0
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes the user has.
+func (u *User) RecoveryCodesLeft() int {
+	return len(u.RecoveryCodes)
+}
+
+// PendingTotpSecret returns the secret the user is enrolling, or nil if they aren't.
+func (u *User) PendingTotpSecret() []byte {
+	if u.twoFactor == nil {
+		return nil
+	}
+	return u.twoFactor.pending
+}
+
+func (u *User) twoFactorState() *twoFactorState {
+	if u.twoFactor == nil {
+		u.twoFactor = &twoFactorState{}
+	}
+	return u.twoFactor
+}
+
+// BeginTotpEnrolment generates a new secret for the user, which takes effect once ConfirmTotpEnrolment is called with
+// a valid code.
+func (a *UserManager) BeginTotpEnrolment(username string) ([]byte, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user := a.editUser(username)
+	if user == nil {
+		return nil, errors.New("user does not exist")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	user.twoFactorState().pending = secret
+	return secret, nil
+}
+
+// ConfirmTotpEnrolment enables two-factor authentication using the pending secret, if the code is valid for it, and
+// returns a new set of recovery codes.
+func (a *UserManager) ConfirmTotpEnrolment(username, code string) ([]string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user := a.editUser(username)
+	if user == nil {
+		return nil, errors.New("user does not exist")
+	}
+
+	state := user.twoFactorState()
+	if state.pending == nil {
+		return nil, errors.New("two-factor authentication setup has not been started")
+	}
+
+	step, ok := totp.Validate(state.pending, code, time.Now())
+	if !ok {
+		return nil, errors.New("incorrect code")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	user.TotpSecret = state.pending
+	user.RecoveryCodes = hashes
+	state.pending = nil
+	state.lastStep = step
+	return codes, a.save(user.Name, fmt.Sprintf("Enabling two-factor authentication for user: %s", user.Name))
+}
+
+// DisableTotp turns off two-factor authentication for the user, and discards their recovery codes.
+func (a *UserManager) DisableTotp(username, responsible string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user := a.editUser(username)
+	if user == nil {
+		return errors.New("user does not exist")
+	}
+
+	if !user.TwoFactorEnabled() {
+		return errors.New("two-factor authentication is not enabled")
+	}
+
+	user.TotpSecret = nil
+	user.RecoveryCodes = nil
+	return a.save(responsible, fmt.Sprintf("Disabling two-factor authentication for user: %s", user.Name))
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes with a new set.
+func (a *UserManager) RegenerateRecoveryCodes(username string) ([]string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user := a.editUser(username)
+	if user == nil {
+		return nil, errors.New("user does not exist")
+	}
+
+	if !user.TwoFactorEnabled() {
+		return nil, errors.New("two-factor authentication is not enabled")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	user.RecoveryCodes = hashes
+	return codes, a.save(user.Name, fmt.Sprintf("Regenerating recovery codes for user: %s", user.Name))
+}
+
+// AuthenticateSecondFactor checks a one-time password or recovery code for a user who has already provided their
+// password. Each one-time password and recovery code can only be used once.
+func (a *UserManager) AuthenticateSecondFactor(username, code string) (*User, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user := a.editUser(username)
+	if user == nil || !user.TwoFactorEnabled() {
+		return nil, errors.New("invalid code")
+	}
+
+	if !user.Has(PermissionAuth) {
+		return nil, fmt.Errorf("account disabled")
+	}
+
+	state := user.twoFactorState()
+	if state.failures >= maxTwoFactorFailures && time.Since(state.failedAt) < twoFactorBackoff {
+		return nil, errors.New("too many incorrect codes, try again later")
+	}
+
+	if step, ok := totp.Validate(user.TotpSecret, code, time.Now()); ok && step > state.lastStep {
+		state.lastStep = step
+		state.failures = 0
+		return user, nil
+	}
+
+	hash := hashRecoveryCode(code)
+	for i := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare(user.RecoveryCodes[i], hash) == 1 {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			state.failures = 0
+			return user, a.save(user.Name, fmt.Sprintf("Using recovery code for user: %s", user.Name))
+		}
+	}
+
+	state.failures++
+	state.failedAt = time.Now()
+	return nil, errors.New("invalid code")
+}
+
+func generateRecoveryCodes() ([]string, [][]byte, error) {
+	var codes []string
+	var hashes [][]byte
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("unable to generate recovery codes: %w", err)
+		}
+
+		var sb strings.Builder
+		for j := range b {
+			if j == len(b)/2 {
+				sb.WriteByte('-')
+			}
+			sb.WriteByte(recoveryCodeAlphabet[b[j]&31])
+		}
+
+		codes = append(codes, sb.String())
+		hashes = append(hashes, hashRecoveryCode(sb.String()))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case, spaces and dashes.
+func hashRecoveryCode(code string) []byte {
+	code = strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mdbot/wiki/totp"
+)
+
+func TestUserManager_TwoFactor(t *testing.T) {
+	store := testStore{}
+	um, err := NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = um.AddUser("alice", "password", "test")
+
+	secret, err := um.BeginTotpEnrolment("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.ConfirmTotpEnrolment("alice", totp.Code(secret, time.Now().Add(-time.Hour))); err == nil {
+		t.Errorf("ConfirmTotpEnrolment() accepted an incorrect code")
+	}
+	if um.User("alice").TwoFactorEnabled() {
+		t.Fatalf("two-factor authentication enabled before enrolment was confirmed")
+	}
+
+	codes, err := um.ConfirmTotpEnrolment("alice", totp.Code(secret, time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || !um.User("alice").TwoFactorEnabled() {
+		t.Fatalf("ConfirmTotpEnrolment() returned %d codes, enabled = %v", len(codes), um.User("alice").TwoFactorEnabled())
+	}
+
+	// The code used to confirm enrolment can't be used again to log in
+	if _, err := um.AuthenticateSecondFactor("alice", totp.Code(secret, time.Now())); err == nil {
+		t.Errorf("AuthenticateSecondFactor() accepted a code that had already been used")
+	}
+	if _, err := um.AuthenticateSecondFactor("alice", totp.Code(secret, time.Now().Add(totp.Period))); err != nil {
+		t.Errorf("AuthenticateSecondFactor() rejected the next code: %v", err)
+	}
+
+	if _, err := um.AuthenticateSecondFactor("alice", codes[0]); err != nil {
+		t.Errorf("AuthenticateSecondFactor() rejected a recovery code: %v", err)
+	}
+	if _, err := um.AuthenticateSecondFactor("alice", codes[0]); err == nil {
+		t.Errorf("AuthenticateSecondFactor() accepted a recovery code twice")
+	}
+	if left := um.User("alice").RecoveryCodesLeft(); left != recoveryCodeCount-1 {
+		t.Errorf("RecoveryCodesLeft() = %d, want %d", left, recoveryCodeCount-1)
+	}
+
+	// Settings survive a reload, but the record of used codes doesn't need to
+	um, err = NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !um.User("alice").TwoFactorEnabled() || um.User("alice").RecoveryCodesLeft() != recoveryCodeCount-1 {
+		t.Fatalf("two-factor settings were not persisted")
+	}
+
+	for i := 0; i < maxTwoFactorFailures; i++ {
+		_, _ = um.AuthenticateSecondFactor("alice", "wrong")
+	}
+	if _, err := um.AuthenticateSecondFactor("alice", codes[1]); err == nil {
+		t.Errorf("AuthenticateSecondFactor() allowed attempts after too many failures")
+	}
+
+	if err := um.DisableTotp("alice", "test"); err != nil || um.User("alice").TwoFactorEnabled() {
+		t.Errorf("DisableTotp() error = %v", err)
+	}
+}
+
+func TestSecurity_TwoFactorRequired(t *testing.T) {
+	security := &Security{RequireTwoFactor: true}
+	tests := []struct {
+		name string
+		user *User
+		want bool
+	}{
+		{"local writer", &User{Permissions: PermissionWrite}, true},
+		{"local reader", &User{Permissions: PermissionRead}, false},
+		{"local writer with two-factor", &User{Permissions: PermissionWrite, TotpSecret: []byte("secret")}, false},
+		{"ldap writer", &User{Subject: LdapSubjectPrefix + "uid=alice", Permissions: PermissionWrite}, true},
+		{"single sign-on writer", &User{Subject: "1234", Permissions: PermissionWrite}, false},
+		{"proxy writer", &User{Subject: proxySubjectPrefix + "alice", Permissions: PermissionWrite}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := security.TwoFactorRequired(tt.user); got != tt.want {
+				t.Errorf("TwoFactorRequired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if (&Security{}).TwoFactorRequired(&User{Permissions: PermissionAdmin}) {
+		t.Error("TwoFactorRequired() when two-factor authentication isn't mandatory")
+	}
+}
@@ -2,10 +2,17 @@ package config
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -45,25 +52,144 @@ type User struct {
 	Password    []byte
 	SessionKey  []byte
 	Permissions Permission
+	// Subject identifies accounts that log in through single sign-on or an external directory, and is empty for local
+	// accounts.
+	Subject string
+	Tokens  []*Token
+	// Email is the address that notifications about watched pages and password reset links are sent to.
+	Email string
+	// Watches are the page and file paths that the user wants to be notified about. Each covers everything under it.
+	Watches []string
+	// Digest indicates that notifications should be collected and sent once a day, rather than immediately.
+	Digest bool
+	// TotpSecret is the secret used to generate one-time passwords. If set, logging in requires a one-time password
+	// or recovery code as well as the user's password.
+	TotpSecret []byte
+	// RecoveryCodes are hashes of the unused codes that can be used in place of a one-time password.
+	RecoveryCodes [][]byte
+
+	// groups and groupPermissions are derived from the groups the user is a member of.
+	groups           []string
+	groupPermissions Permission
+	// scope limits the user's permissions when they authenticate with a token; zero means unrestricted.
+	scope Permission
+	// twoFactor tracks enrolment and use of one-time passwords, which doesn't need to be persisted.
+	twoFactor *twoFactorState
+	// reset is the password reset the user has requested, if any.
+	reset *passwordReset
 }
 
+// Has determines whether the user has the given permission, either directly or through one of their groups. Group
+// permissions don't apply to disabled accounts.
 func (u *User) Has(permission Permission) bool {
-	return u.Permissions&permission == permission
+	permissions := u.Permissions
+	if permissions&PermissionAuth == PermissionAuth {
+		permissions |= u.groupPermissions
+	}
+	if u.scope != PermissionNone {
+		permissions &= u.scope
+	}
+	return permissions&permission == permission
+}
+
+// LogsInWithPassword determines whether the user logs in to the wiki with a password, either their own or one checked
+// against an LDAP directory, rather than through an identity provider or a reverse proxy.
+func (u *User) LogsInWithPassword() bool {
+	return u.Subject == "" || strings.HasPrefix(u.Subject, LdapSubjectPrefix)
+}
+
+// Token is a personal API token. Only a hash of the token is stored.
+type Token struct {
+	Name        string
+	Hash        []byte
+	Permissions Permission
+	Created     time.Time
+}
+
+// tokenPrefix is added to the start of every token, to make them easy to recognise.
+const tokenPrefix = "wiki_"
+
+// Groups returns the names of the groups the user is a member of.
+func (u *User) Groups() []string {
+	return u.groups
 }
 
+// InGroup determines whether the user is a member of the named group.
+func (u *User) InGroup(group string) bool {
+	for i := range u.groups {
+		if strings.EqualFold(u.groups[i], group) {
+			return true
+		}
+	}
+	return false
+}
+
+// Group is a named set of users, who are all granted the group's permissions.
+type Group struct {
+	Name        string
+	Members     []string
+	Permissions Permission
+}
+
+func (g *Group) copy() *Group {
+	res := *g
+	res.Members = append([]string(nil), g.Members...)
+	return &res
+}
+
+func (g *Group) hasMember(username string) bool {
+	for i := range g.Members {
+		if strings.EqualFold(g.Members[i], username) {
+			return true
+		}
+	}
+	return false
+}
+
+// UserManager manages user accounts and groups. Users it returns are never changed afterwards; changes are made to
+// copies which replace them, so they can be used without holding its lock.
 type UserManager struct {
-	users map[string]*User
-	store Store
+	mutex         sync.RWMutex
+	users         map[string]*User
+	groups        map[string]*Group
+	pending       map[string]*PendingUser
+	invites       []*Invite
+	registration  RegistrationPolicy
+	store         Store
+	authenticator PasswordAuthenticator
+}
+
+// PasswordAuthenticator checks the passwords of users whose accounts are managed by an external directory.
+type PasswordAuthenticator interface {
+	// AuthenticatePassword returns details of the user if the password is correct.
+	AuthenticatePassword(username, password string) (*ExternalUser, error)
+}
+
+// ExternalUser is a user authenticated by a PasswordAuthenticator.
+type ExternalUser struct {
+	// Subject uniquely identifies the user, and must not be shared with any other kind of account.
+	Subject  string
+	Username string
+	// Permissions replaces the permissions of the user's account, unless it's PermissionNone.
+	Permissions Permission
+	Groups      []string
 }
 
 type UserSettings struct {
-	Users []*User
+	Users        []*User
+	Groups       []*Group
+	PendingUsers []*PendingUser
+	Invites      []*Invite
+	Registration RegistrationPolicy
 }
 
 func NewUserManager(store Store) (*UserManager, error) {
 	am := &UserManager{
-		users: map[string]*User{},
-		store: store,
+		users:        map[string]*User{},
+		groups:       map[string]*Group{},
+		pending:      map[string]*PendingUser{},
+		registration: RegistrationPolicy{Permissions: PermissionRead, Approval: true},
+		store:        store,
 	}
 
 	if err := am.load(); err != nil {
@@ -74,6 +200,9 @@ func NewUserManager(store Store) (*UserManager, error) {
 }
 
 func (a *UserManager) Empty() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
 	return len(a.users) == 0
 }
 
@@ -83,14 +212,23 @@ func (a *UserManager) load() error {
 		return err
 	}
 
+	for i := range settings.Groups {
+		a.groups[strings.ToLower(settings.Groups[i].Name)] = settings.Groups[i]
+	}
+
+	for i := range settings.PendingUsers {
+		a.pending[strings.ToLower(settings.PendingUsers[i].Name)] = settings.PendingUsers[i]
+	}
+
+	a.invites = settings.Invites
+	if settings.Registration.Permissions != PermissionNone {
+		a.registration = settings.Registration
+	}
+
 	dirty := false
-	hasAdmin := false
 	for i := range settings.Users {
 		u := settings.Users[i]
 		a.users[strings.ToLower(u.Name)] = u
-		if u.Has(PermissionAdmin) {
-			hasAdmin = true
-		}
 		if u.SessionKey == nil {
 			key, err := a.randomBytes()
 			if err != nil {
@@ -105,7 +243,8 @@ func (a *UserManager) load() error {
 		_ = a.save("System", "Migration: adding session keys")
 	}
 
-	if len(a.users) > 0 && !hasAdmin {
+	a.updateGroupMembership()
+	if len(a.users) > 0 && !a.hasAdmin() {
 		log.Printf("No account has admin access, granting access to all...")
 		for n := range a.users {
 			a.users[n].Permissions |= PermissionAdmin
@@ -123,13 +262,43 @@ func (a *UserManager) save(user, message string) error {
 		settings.Users = append(settings.Users, a.users[i])
 	}
 
+	for i := range a.groups {
+		settings.Groups = append(settings.Groups, a.groups[i])
+	}
+
+	for i := range a.pending {
+		settings.PendingUsers = append(settings.PendingUsers, a.pending[i])
+	}
+
+	settings.Invites = a.invites
+	settings.Registration = a.registration
 	return a.store.PutSettings(userSettingsName, user, message, &settings)
 }
 
+// SetPasswordAuthenticator configures an external directory to check passwords against. Local accounts still use
+// their own passwords, while anyone else is checked against the directory and given an account which is kept in sync
+// with it each time they log in.
+func (a *UserManager) SetPasswordAuthenticator(authenticator PasswordAuthenticator) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.authenticator = authenticator
+}
+
 func (a *UserManager) Authenticate(username, password string) (*User, error) {
+	a.mutex.RLock()
 	user, ok := a.users[strings.ToLower(username)]
+	pending := a.pending[strings.ToLower(username)]
+	a.mutex.RUnlock()
+
 	if !ok {
-		return nil, fmt.Errorf("invalid username/password")
+		if err := checkPending(pending, password); err != nil {
+			return nil, err
+		}
+	}
+
+	if !ok || user.Subject != "" {
+		return a.authenticateExternal(username, password)
 	}
 
 	salted := append([]byte(password), user.Salt...)
@@ -144,19 +313,56 @@ func (a *UserManager) Authenticate(username, password string) (*User, error) {
 	return user, nil
 }
 
+func (a *UserManager) authenticateExternal(username, password string) (*User, error) {
+	a.mutex.RLock()
+	authenticator := a.authenticator
+	a.mutex.RUnlock()
+
+	if authenticator == nil {
+		return nil, fmt.Errorf("invalid username/password")
+	}
+
+	external, err := authenticator.AuthenticatePassword(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := a.SingleSignOnUser(external.Subject, external.Username, external.Permissions, external.Groups)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.Has(PermissionAuth) {
+		return nil, fmt.Errorf("account disabled")
+	}
+
+	return user, nil
+}
+
 func (a *UserManager) User(username string) *User {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
 	return a.users[strings.ToLower(username)]
 }
 
+// Users returns copies of all users.
 func (a *UserManager) Users() []*User {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
 	var res []*User
 	for i := range a.users {
-		res = append(res, a.users[i])
+		user := *a.users[i]
+		res = append(res, &user)
 	}
 	return res
 }
 
 func (a *UserManager) Delete(username, responsible string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
 	user := a.users[strings.ToLower(username)]
 	if user == nil {
 		return errors.New("user does not exist")
@@ -167,15 +373,25 @@ func (a *UserManager) Delete(username, responsible string) error {
 	}
 
 	delete(a.users, strings.ToLower(username))
-	return a.save(responsible, fmt.Sprintf("Deleting user: %s", user))
+	for i := range a.groups {
+		a.groups[i].Members = removeMember(a.groups[i].Members, username)
+	}
+	return a.save(responsible, fmt.Sprintf("Deleting user: %s", user.Name))
 }
 
 func (a *UserManager) SetPassword(user, password, responsible string) error {
-	u := a.users[strings.ToLower(user)]
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	u := a.editUser(user)
 	if u == nil {
 		return errors.New("user does not exist")
 	}
 
+	if u.Subject != "" {
+		return errors.New("the password for this account is managed externally")
+	}
+
 	if err := a.setPassword(u, password); err != nil {
 		return err
 	}
@@ -183,7 +399,10 @@ func (a *UserManager) SetPassword(user, password, responsible string) error {
 }
 
 func (a *UserManager) AddUser(user, password, responsible string) error {
-	if _, ok := a.users[strings.ToLower(user)]; ok {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.nameTaken(user) {
 		return fmt.Errorf("user already exists")
 	}
 
@@ -211,6 +430,9 @@ func (a *UserManager) AddUser(user, password, responsible string) error {
 }
 
 func (a *UserManager) SetPermission(username string, permissions Permission, responsible string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
 	user := a.users[strings.ToLower(username)]
 	if user == nil {
 		return errors.New("user does not exist")
@@ -220,10 +442,483 @@ func (a *UserManager) SetPermission(username string, permissions Permission, res
 		return errors.New("can't modify permissions of the only admin user")
 	}
 
-	user.Permissions = permissions
+	a.editUser(username).Permissions = permissions
 	return a.save(responsible, fmt.Sprintf("Changing permissions for user: %s", username))
 }
 
+// SingleSignOnUser finds the account for a user authenticated by an identity provider, creating it if necessary.
+// If permissions is not PermissionNone, it replaces the account's permissions; otherwise new accounts are only allowed
+// to authenticate. The account is made a member of each of the named groups that exist, and removed from all others.
+func (a *UserManager) SingleSignOnUser(subject, username string, permissions Permission, groups []string) (*User, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var user *User
+	for i := range a.users {
+		if a.users[i].Subject == subject {
+			user = a.users[i]
+			break
+		}
+	}
+
+	if user == nil {
+		if username == "" || strings.ContainsAny(username, ", @") {
+			return nil, fmt.Errorf("invalid username %q", username)
+		}
+
+		if a.nameTaken(username) {
+			return nil, fmt.Errorf("an account named %s already exists", username)
+		}
+
+		key, err := a.randomBytes()
+		if err != nil {
+			return nil, err
+		}
+
+		user = &User{
+			Name:        username,
+			SessionKey:  key,
+			Subject:     subject,
+			Permissions: PermissionAuth,
+		}
+		if permissions != PermissionNone {
+			user.Permissions = permissions
+		}
+
+		a.users[strings.ToLower(username)] = user
+		a.syncGroups(user, groups)
+		a.updateGroupMembership()
+		return a.users[strings.ToLower(username)], a.save("System", fmt.Sprintf("Adding single sign-on user: %s", username))
+	}
+
+	if user.Permissions == PermissionNone {
+		return nil, errors.New("account disabled")
+	}
+
+	oldMembers := make(map[*Group][]string)
+	for i := range a.groups {
+		oldMembers[a.groups[i]] = a.groups[i].Members
+	}
+
+	updated := *user
+	if permissions != PermissionNone {
+		updated.Permissions = permissions
+	}
+
+	key := strings.ToLower(user.Name)
+	if err := a.modifyGroups(func() {
+		a.users[key] = &updated
+		a.syncGroups(&updated, groups)
+	}, func() {
+		a.users[key] = user
+		for group, members := range oldMembers {
+			group.Members = members
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	changed := updated.Permissions != user.Permissions
+	for group, members := range oldMembers {
+		changed = changed || len(group.Members) != len(members)
+	}
+	if changed {
+		return a.users[key], a.save("System", fmt.Sprintf("Updating single sign-on user: %s", user.Name))
+	}
+	return a.users[key], nil
+}
+
+// LdapSubjectPrefix is added to the DNs of users from an LDAP directory to make their subjects, so that they can't be
+// confused with accounts from an identity provider.
+const LdapSubjectPrefix = "ldap:"
+
+// proxySubjectPrefix is added to the names of users authenticated by a reverse proxy to make their subjects, so that
+// they can't be confused with local accounts or those from an identity provider.
+const proxySubjectPrefix = "proxy:"
+
+// ProxyUser finds the account for a user authenticated by a trusted reverse proxy, creating it with the given
+// permissions if necessary. If groups is non-nil, the account is made a member of each of the named groups that exist,
+// and removed from all others. Local accounts with the same name are never used, as the proxy may not know about them.
+func (a *UserManager) ProxyUser(username string, permissions Permission, groups []string) (*User, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	subject := proxySubjectPrefix + strings.ToLower(username)
+	user := a.users[strings.ToLower(username)]
+	if user != nil && user.Subject != subject {
+		return nil, fmt.Errorf("an account named %s already exists", username)
+	}
+
+	if user == nil {
+		if username == "" || strings.ContainsAny(username, ", @/") {
+			return nil, fmt.Errorf("invalid username %q", username)
+		}
+
+		if a.nameTaken(username) {
+			return nil, fmt.Errorf("an account named %s already exists", username)
+		}
+
+		key, err := a.randomBytes()
+		if err != nil {
+			return nil, err
+		}
+
+		user = &User{
+			Name:        username,
+			SessionKey:  key,
+			Subject:     subject,
+			Permissions: permissions,
+		}
+		a.users[strings.ToLower(username)] = user
+		if groups != nil {
+			a.syncGroups(user, groups)
+		}
+		a.updateGroupMembership()
+		return a.users[strings.ToLower(username)], a.save("System", fmt.Sprintf("Adding reverse proxy user: %s", username))
+	}
+
+	if user.Permissions == PermissionNone {
+		return nil, errors.New("account disabled")
+	}
+
+	if groups == nil || sameGroups(user.Groups(), groups, a.groups) {
+		return user, nil
+	}
+
+	oldMembers := make(map[*Group][]string)
+	for i := range a.groups {
+		oldMembers[a.groups[i]] = a.groups[i].Members
+	}
+
+	if err := a.modifyGroups(func() {
+		a.syncGroups(user, groups)
+	}, func() {
+		for group, members := range oldMembers {
+			group.Members = members
+		}
+	}); err != nil {
+		return nil, err
+	}
+	return a.users[strings.ToLower(username)], a.save("System", fmt.Sprintf("Updating reverse proxy user: %s", user.Name))
+}
+
+// sameGroups determines whether a user who is a member of the current groups would be a member of exactly the same
+// ones after being synced with the wanted groups, ignoring those that don't exist.
+func sameGroups(current, wanted []string, existing map[string]*Group) bool {
+	want := make(map[string]bool)
+	for i := range wanted {
+		if _, ok := existing[strings.ToLower(wanted[i])]; ok {
+			want[strings.ToLower(wanted[i])] = true
+		}
+	}
+
+	if len(want) != len(current) {
+		return false
+	}
+	for i := range current {
+		if !want[strings.ToLower(current[i])] {
+			return false
+		}
+	}
+	return true
+}
+
+// syncGroups makes the user a member of exactly the named groups.
+func (a *UserManager) syncGroups(user *User, groups []string) {
+	wanted := make(map[string]bool)
+	for i := range groups {
+		wanted[strings.ToLower(groups[i])] = true
+	}
+
+	for name, group := range a.groups {
+		if member := group.hasMember(user.Name); wanted[name] && !member {
+			group.Members = append(group.Members, user.Name)
+			sort.Strings(group.Members)
+		} else if !wanted[name] && member {
+			group.Members = removeMember(group.Members, user.Name)
+		}
+	}
+}
+
+// AddToken creates a new API token for the user, limited to the given permissions, and returns it. The token can't
+// be retrieved again later.
+func (a *UserManager) AddToken(username, name string, permissions Permission) (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user := a.editUser(username)
+	if user == nil {
+		return "", errors.New("user does not exist")
+	}
+
+	if name = strings.TrimSpace(name); name == "" {
+		return "", errors.New("tokens must have a name")
+	}
+
+	for i := range user.Tokens {
+		if strings.EqualFold(user.Tokens[i].Name, name) {
+			return "", errors.New("a token with that name already exists")
+		}
+	}
+
+	if permissions != PermissionRead && permissions != PermissionWrite && permissions != PermissionAdmin {
+		return "", errors.New("invalid token permissions")
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	hash := sha256.Sum256([]byte(token))
+	user.Tokens = append(user.Tokens, &Token{
+		Name:        name,
+		Hash:        hash[:],
+		Permissions: permissions,
+		Created:     time.Now(),
+	})
+	return token, a.save(user.Name, fmt.Sprintf("Adding API token for user: %s", user.Name))
+}
+
+// RevokeToken deletes the user's token with the given name.
+func (a *UserManager) RevokeToken(username, name, responsible string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user := a.editUser(username)
+	if user == nil {
+		return errors.New("user does not exist")
+	}
+
+	for i := range user.Tokens {
+		if user.Tokens[i].Name == name {
+			user.Tokens = append(user.Tokens[:i:i], user.Tokens[i+1:]...)
+			return a.save(responsible, fmt.Sprintf("Revoking API token for user: %s", user.Name))
+		}
+	}
+	return errors.New("token does not exist")
+}
+
+// AuthenticateToken finds the user that owns the given API token. The returned user's permissions are limited to
+// those of the token.
+func (a *UserManager) AuthenticateToken(token string) (*User, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return nil, errors.New("invalid token")
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	for _, user := range a.users {
+		for _, t := range user.Tokens {
+			if subtle.ConstantTimeCompare(t.Hash, hash[:]) == 1 {
+				if !user.Has(PermissionAuth) {
+					return nil, errors.New("account disabled")
+				}
+
+				scoped := *user
+				scoped.scope = t.Permissions
+				return &scoped, nil
+			}
+		}
+	}
+	return nil, errors.New("invalid token")
+}
+
+// Groups returns copies of all groups, ordered by name.
+func (a *UserManager) Groups() []*Group {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	var res []*Group
+	for _, group := range a.sortedGroups() {
+		res = append(res, group.copy())
+	}
+	return res
+}
+
+// Group returns a copy of the named group, or nil if it doesn't exist.
+func (a *UserManager) Group(name string) *Group {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if group := a.groups[strings.ToLower(name)]; group != nil {
+		return group.copy()
+	}
+	return nil
+}
+
+func (a *UserManager) sortedGroups() []*Group {
+	var res []*Group
+	for i := range a.groups {
+		res = append(res, a.groups[i])
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+func (a *UserManager) AddGroup(name, responsible string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if name == "" || strings.ContainsAny(name, ", @") {
+		return errors.New("invalid group name")
+	}
+
+	if _, ok := a.groups[strings.ToLower(name)]; ok {
+		return errors.New("group already exists")
+	}
+
+	a.groups[strings.ToLower(name)] = &Group{Name: name}
+	return a.save(responsible, fmt.Sprintf("Adding new group: %s", name))
+}
+
+func (a *UserManager) DeleteGroup(name, responsible string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	group := a.groups[strings.ToLower(name)]
+	if group == nil {
+		return errors.New("group does not exist")
+	}
+
+	if err := a.modifyGroups(func() {
+		delete(a.groups, strings.ToLower(name))
+	}, func() {
+		a.groups[strings.ToLower(name)] = group
+	}); err != nil {
+		return err
+	}
+	return a.save(responsible, fmt.Sprintf("Deleting group: %s", group.Name))
+}
+
+func (a *UserManager) SetGroupPermission(name string, permissions Permission, responsible string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	group := a.groups[strings.ToLower(name)]
+	if group == nil {
+		return errors.New("group does not exist")
+	}
+
+	old := group.Permissions
+	if err := a.modifyGroups(func() {
+		group.Permissions = permissions
+	}, func() {
+		group.Permissions = old
+	}); err != nil {
+		return err
+	}
+	return a.save(responsible, fmt.Sprintf("Changing permissions for group: %s", group.Name))
+}
+
+func (a *UserManager) AddGroupMember(name, username, responsible string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	group := a.groups[strings.ToLower(name)]
+	if group == nil {
+		return errors.New("group does not exist")
+	}
+
+	user := a.users[strings.ToLower(username)]
+	if user == nil {
+		return errors.New("user does not exist")
+	}
+
+	if group.hasMember(user.Name) {
+		return errors.New("user is already a member of the group")
+	}
+
+	group.Members = append(group.Members, user.Name)
+	sort.Strings(group.Members)
+	a.updateGroupMembership()
+	return a.save(responsible, fmt.Sprintf("Adding user %s to group %s", user.Name, group.Name))
+}
+
+func (a *UserManager) RemoveGroupMember(name, username, responsible string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	group := a.groups[strings.ToLower(name)]
+	if group == nil {
+		return errors.New("group does not exist")
+	}
+
+	if !group.hasMember(username) {
+		return errors.New("user is not a member of the group")
+	}
+
+	old := group.Members
+	if err := a.modifyGroups(func() {
+		group.Members = removeMember(group.Members, username)
+	}, func() {
+		group.Members = old
+	}); err != nil {
+		return err
+	}
+	return a.save(responsible, fmt.Sprintf("Removing user %s from group %s", username, group.Name))
+}
+
+// modifyGroups applies a change to the groups, and reverts it if it would leave no users with admin permission.
+func (a *UserManager) modifyGroups(change, revert func()) error {
+	change()
+	a.updateGroupMembership()
+	if len(a.users) > 0 && !a.hasAdmin() {
+		revert()
+		a.updateGroupMembership()
+		return errors.New("can't remove admin permissions from the only admin user")
+	}
+	return nil
+}
+
+// updateGroupMembership recalculates the groups and group permissions of each user. Users whose groups have changed
+// are replaced with updated copies, rather than changed in place, as the existing ones may be in use elsewhere.
+func (a *UserManager) updateGroupMembership() {
+	groups := make(map[string][]string)
+	permissions := make(map[string]Permission)
+	for _, group := range a.sortedGroups() {
+		for _, member := range group.Members {
+			if name := strings.ToLower(member); a.users[name] != nil {
+				groups[name] = append(groups[name], group.Name)
+				permissions[name] |= group.Permissions
+			}
+		}
+	}
+
+	for name, user := range a.users {
+		if !slices.Equal(user.groups, groups[name]) || user.groupPermissions != permissions[name] {
+			updated := *user
+			updated.groups = groups[name]
+			updated.groupPermissions = permissions[name]
+			a.users[name] = &updated
+		}
+	}
+}
+
+func (a *UserManager) hasAdmin() bool {
+	for i := range a.users {
+		if a.users[i].Has(PermissionAdmin) {
+			return true
+		}
+	}
+	return false
+}
+
+func removeMember(members []string, username string) []string {
+	var res []string
+	for i := range members {
+		if !strings.EqualFold(members[i], username) {
+			res = append(res, members[i])
+		}
+	}
+	return res
+}
+
 func (a *UserManager) canRemoveAdmin(user *User) bool {
 	for n := range a.users {
 		if n != user.Name && a.users[n].Has(PermissionAdmin) {
@@ -233,6 +928,23 @@ func (a *UserManager) canRemoveAdmin(user *User) bool {
 	return false
 }
 
+// editUser replaces the named user with a copy that can be changed, and returns it. The existing user isn't changed,
+// as it may be in use elsewhere. It returns nil if the user doesn't exist.
+func (a *UserManager) editUser(username string) *User {
+	user := a.users[strings.ToLower(username)]
+	if user == nil {
+		return nil
+	}
+
+	edited := *user
+	if user.twoFactor != nil {
+		state := *user.twoFactor
+		edited.twoFactor = &state
+	}
+	a.users[strings.ToLower(username)] = &edited
+	return &edited
+}
+
 func (a *UserManager) randomBytes() ([]byte, error) {
 	res := make([]byte, 16)
 	n, err := rand.Read(res)
@@ -0,0 +1,404 @@
+package config
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testStore keeps gob-encoded settings in memory, so values don't share state with what was saved.
+type testStore map[string][]byte
+
+func (s testStore) GetSettings(name string, settings interface{}) error {
+	if data, ok := s[name]; ok {
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(settings)
+	}
+	return nil
+}
+
+func (s testStore) PutSettings(name, _, _ string, settings interface{}) error {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(settings); err != nil {
+		return err
+	}
+	s[name] = buf.Bytes()
+	return nil
+}
+
+func TestUserManager_Groups(t *testing.T) {
+	store := testStore{}
+	um, err := NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"admin", "alice", "bob"} {
+		if err := um.AddUser(name, "password", "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = um.SetPermission("alice", PermissionAuth, "test")
+	_ = um.SetPermission("bob", PermissionNone, "test")
+
+	if err := um.AddGroup("Editors", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := um.AddGroup("editors", "test"); err == nil {
+		t.Errorf("AddGroup() with duplicate name succeeded")
+	}
+	_ = um.SetGroupPermission("editors", PermissionWrite, "test")
+	_ = um.AddGroupMember("editors", "Alice", "test")
+	_ = um.AddGroupMember("editors", "bob", "test")
+
+	if alice := um.User("alice"); !alice.Has(PermissionWrite) || !alice.InGroup("EDITORS") {
+		t.Errorf("alice should have write permission through the editors group")
+	}
+	if um.User("bob").Has(PermissionRead) {
+		t.Errorf("disabled user bob should not gain permissions from groups")
+	}
+
+	// Group membership and permissions should survive a reload.
+	um, err = NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice := um.User("alice"); !alice.Has(PermissionWrite) {
+		t.Errorf("alice lost group permissions after reloading")
+	}
+
+	before := um.User("alice")
+	_ = um.RemoveGroupMember("editors", "alice", "test")
+	if um.User("alice").Has(PermissionRead) {
+		t.Errorf("alice kept group permissions after being removed")
+	}
+	// Users already handed out are replaced rather than changed, as they may be in use by other requests.
+	if !before.Has(PermissionWrite) || !before.InGroup("editors") {
+		t.Errorf("removing alice from a group changed an existing copy of the account")
+	}
+
+	// Removing the only route to admin access must be refused.
+	_ = um.AddGroup("admins", "test")
+	_ = um.SetGroupPermission("admins", PermissionAdmin, "test")
+	_ = um.AddGroupMember("admins", "alice", "test")
+	_ = um.SetPermission("admin", PermissionWrite, "test")
+	if err := um.DeleteGroup("admins", "test"); err == nil {
+		t.Errorf("DeleteGroup() removed the only admin")
+	}
+	if !um.User("alice").Has(PermissionAdmin) {
+		t.Errorf("alice lost admin permissions after a refused change")
+	}
+
+	acl := &Acl{Rules: []AclRule{{Prefix: "handbook", Write: []string{"@admins"}}}}
+	if !acl.CanWrite(um.User("alice"), "handbook/leave") || acl.CanWrite(um.User("admin"), "handbook/leave") {
+		t.Errorf("access control rules should match group members only")
+	}
+}
+
+func TestUserManager_SingleSignOnUser(t *testing.T) {
+	um, err := NewUserManager(testStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = um.AddUser("admin", "password", "test")
+	_ = um.AddGroup("hr", "test")
+	_ = um.AddGroup("finance", "test")
+
+	user, err := um.SingleSignOnUser("sub-1", "alice", PermissionNone, []string{"HR", "unknown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "alice" || user.Permissions != PermissionAuth || !user.InGroup("hr") || user.InGroup("finance") {
+		t.Errorf("SingleSignOnUser() created %s with permissions %s and groups %v", user.Name, user.Permissions, user.Groups())
+	}
+
+	if _, err := um.Authenticate("alice", ""); err == nil {
+		t.Errorf("single sign-on user could log in without a password")
+	}
+
+	// The subject identifies the user, even if their username changes
+	user, err = um.SingleSignOnUser("sub-1", "alice2", PermissionWrite, []string{"finance"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "alice" || user.Permissions != PermissionWrite || user.InGroup("hr") || !user.InGroup("finance") {
+		t.Errorf("SingleSignOnUser() updated %s with permissions %s and groups %v", user.Name, user.Permissions, user.Groups())
+	}
+
+	if _, err := um.SingleSignOnUser("sub-2", "Admin", PermissionNone, nil); err == nil {
+		t.Errorf("SingleSignOnUser() took over an existing local account")
+	}
+
+	_ = um.SetPermission("alice", PermissionNone, "test")
+	if _, err := um.SingleSignOnUser("sub-1", "alice", PermissionWrite, nil); err == nil {
+		t.Errorf("SingleSignOnUser() allowed a disabled account to log in")
+	}
+}
+
+func TestUserManager_ProxyUser(t *testing.T) {
+	store := testStore{}
+	um, err := NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = um.AddUser("admin", "password", "test")
+	_ = um.AddGroup("editors", "test")
+	_ = um.SetGroupPermission("editors", PermissionWrite, "test")
+
+	user, err := um.ProxyUser("alice", PermissionRead, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Permissions != PermissionRead || user.Subject == "" || len(user.Groups()) != 0 {
+		t.Errorf("ProxyUser() created %s with permissions %s, subject %q and groups %v", user.Name, user.Permissions, user.Subject, user.Groups())
+	}
+
+	// The default permissions only apply to new accounts
+	_ = um.SetPermission("alice", PermissionAuth, "test")
+	user, err = um.ProxyUser("Alice", PermissionRead, []string{"Editors", "unknown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Permissions != PermissionAuth || !user.InGroup("editors") || !user.Has(PermissionWrite) {
+		t.Errorf("ProxyUser() updated %s with permissions %s and groups %v", user.Name, user.Permissions, user.Groups())
+	}
+
+	// Groups aren't changed if the proxy doesn't send them
+	if user, err = um.ProxyUser("alice", PermissionRead, nil); err != nil || !user.InGroup("editors") {
+		t.Errorf("ProxyUser() without groups = %v, %v, want membership unchanged", user, err)
+	}
+	if user, err = um.ProxyUser("alice", PermissionRead, []string{}); err != nil || user.InGroup("editors") {
+		t.Errorf("ProxyUser() with no groups = %v, %v, want removed from editors", user, err)
+	}
+
+	if _, err := um.ProxyUser("admin", PermissionRead, nil); err == nil {
+		t.Errorf("ProxyUser() took over an existing local account")
+	}
+	if _, err := um.SingleSignOnUser("sub-1", "bob", PermissionNone, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.ProxyUser("bob", PermissionRead, nil); err == nil {
+		t.Errorf("ProxyUser() took over a single sign-on account")
+	}
+
+	_ = um.SetPermission("alice", PermissionNone, "test")
+	if _, err := um.ProxyUser("alice", PermissionRead, nil); err == nil {
+		t.Errorf("ProxyUser() allowed a disabled account to log in")
+	}
+}
+
+// testPasswordAuthenticator accepts "secret" as the password of anyone in its directory.
+type testPasswordAuthenticator map[string]*ExternalUser
+
+func (d testPasswordAuthenticator) AuthenticatePassword(username, password string) (*ExternalUser, error) {
+	if user, ok := d[username]; ok && password == "secret" {
+		return user, nil
+	}
+	return nil, errors.New("invalid username/password")
+}
+
+// TestUserManager_Concurrent checks that users can be created and updated while others are being looked up; it's
+// most useful when run with the race detector.
+func TestUserManager_Concurrent(t *testing.T) {
+	um, err := NewUserManager(testStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = um.AddUser("admin", "password", "test")
+	_ = um.AddGroup("editors", "test")
+	token, err := um.AddToken("admin", "test", PermissionRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := um.ProxyUser(fmt.Sprintf("user%d-%d", i, j), PermissionRead, []string{"editors"}); err != nil {
+					t.Error(err)
+				}
+				if _, err := um.SingleSignOnUser(fmt.Sprintf("sub-%d", i), fmt.Sprintf("sso%d", i), PermissionWrite, []string{"editors"}); err != nil {
+					t.Error(err)
+				}
+				if user, err := um.AuthenticateToken(token); err != nil || !user.Has(PermissionRead) {
+					t.Errorf("AuthenticateToken() = %v, %v", user, err)
+				}
+				for _, user := range um.Users() {
+					_ = user.Has(PermissionWrite)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if users := um.Users(); len(users) != 85 {
+		t.Errorf("Users() returned %d users, want 85", len(users))
+	}
+}
+
+func TestUserManager_PasswordAuthenticator(t *testing.T) {
+	store := testStore{}
+	um, err := NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = um.AddUser("admin", "password", "test")
+	_ = um.AddGroup("editors", "test")
+
+	directory := testPasswordAuthenticator{
+		"alice": {Subject: "ldap:uid=alice", Username: "alice", Permissions: PermissionRead, Groups: []string{"editors"}},
+		"admin": {Subject: "ldap:uid=admin", Username: "admin", Permissions: PermissionRead},
+		"bob":   {Subject: "ldap:uid=bob", Username: "bob"},
+	}
+
+	if _, err := um.Authenticate("alice", "secret"); err == nil {
+		t.Errorf("Authenticate() succeeded without a password authenticator")
+	}
+
+	um.SetPasswordAuthenticator(directory)
+	user, err := um.Authenticate("alice", "secret")
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if user.Subject != "ldap:uid=alice" || user.Permissions != PermissionRead || !user.InGroup("editors") {
+		t.Errorf("Authenticate() created %s with permissions %s, subject %q and groups %v", user.Name, user.Permissions, user.Subject, user.Groups())
+	}
+	if _, err := um.Authenticate("alice", "wrong"); err == nil {
+		t.Errorf("Authenticate() accepted the wrong password for a directory user")
+	}
+	if err := um.SetPassword("alice", "local", "test"); err == nil {
+		t.Errorf("SetPassword() changed the password of a directory user")
+	}
+
+	// The account is kept in sync with the directory, and survives a reload
+	directory["alice"].Groups = nil
+	um, err = NewUserManager(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	um.SetPasswordAuthenticator(directory)
+	if user, err := um.Authenticate("alice", "secret"); err != nil || user.InGroup("editors") {
+		t.Errorf("Authenticate() = %v, %v, want alice removed from editors", user, err)
+	}
+
+	// Local accounts take precedence over the directory
+	if _, err := um.Authenticate("admin", "secret"); err == nil {
+		t.Errorf("Authenticate() used the directory for a local account")
+	}
+	if user, err := um.Authenticate("admin", "password"); err != nil || !user.Has(PermissionAdmin) {
+		t.Errorf("Authenticate() for local account = %v, %v", user, err)
+	}
+
+	// Users who aren't granted any permissions can only authenticate, until an admin changes them
+	if user, err := um.Authenticate("bob", "secret"); err != nil || user.Permissions != PermissionAuth {
+		t.Errorf("Authenticate() for user without permissions = %v, %v, want auth permission", user, err)
+	}
+	_ = um.SetPermission("bob", PermissionNone, "test")
+	if _, err := um.Authenticate("bob", "secret"); err == nil {
+		t.Errorf("Authenticate() allowed a disabled directory user to log in")
+	}
+}
+
+func TestUserManager_Tokens(t *testing.T) {
+	um, err := NewUserManager(testStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = um.AddUser("admin", "password", "test")
+	_ = um.AddUser("alice", "password", "test")
+	_ = um.SetPermission("alice", PermissionWrite, "test")
+
+	token, err := um.AddToken("alice", "bot", PermissionRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.AddToken("alice", "BOT", PermissionRead); err == nil {
+		t.Errorf("AddToken() with a duplicate name succeeded")
+	}
+	if !strings.HasPrefix(token, tokenPrefix) {
+		t.Errorf("AddToken() = %q, want prefix %s", token, tokenPrefix)
+	}
+
+	user, err := um.AuthenticateToken(token)
+	if err != nil {
+		t.Fatalf("AuthenticateToken() error = %v", err)
+	}
+	if user.Name != "alice" || !user.Has(PermissionRead) || user.Has(PermissionWrite) {
+		t.Errorf("AuthenticateToken() returned %s with the wrong permissions", user.Name)
+	}
+	if !um.User("alice").Has(PermissionWrite) {
+		t.Errorf("Token scope leaked into the stored user")
+	}
+
+	if _, err := um.AuthenticateToken(token + "x"); err == nil {
+		t.Errorf("AuthenticateToken() accepted an invalid token")
+	}
+
+	// Tokens can't grant more than the user has
+	adminToken, _ := um.AddToken("alice", "admin", PermissionAdmin)
+	if user, _ := um.AuthenticateToken(adminToken); user == nil || user.Has(PermissionAdmin) || !user.Has(PermissionWrite) {
+		t.Errorf("Admin token for a writer should only allow writing")
+	}
+
+	if err := um.RevokeToken("alice", "bot", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := um.AuthenticateToken(token); err == nil {
+		t.Errorf("AuthenticateToken() accepted a revoked token")
+	}
+}
+
+func TestUserManager_Watch(t *testing.T) {
+	um, err := NewUserManager(testStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := um.AddUser("alice", "password", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := um.Watch("alice", "/Projects/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := um.Watch("alice", "projects"); err != nil {
+		t.Fatal(err)
+	}
+
+	user := um.User("alice")
+	if len(user.Watches) != 1 {
+		t.Errorf("Watches = %v, want one entry", user.Watches)
+	}
+
+	for path, want := range map[string]bool{"projects": true, "projects/wiki": true, "projectsx": false, "other": false} {
+		if got := user.Watching(path); got != want {
+			t.Errorf("Watching(%q) = %v, want %v", path, got, want)
+		}
+	}
+
+	if err := um.Unwatch("alice", "projects"); err != nil {
+		t.Fatal(err)
+	}
+	if um.User("alice").Watching("projects/wiki") {
+		t.Error("Still watching after Unwatch")
+	}
+	if err := um.Unwatch("alice", "projects"); err == nil {
+		t.Error("Unwatching a path that isn't watched should fail")
+	}
+
+	if err := um.SetNotifications("alice", "not an address", false); err == nil {
+		t.Error("SetNotifications should reject invalid addresses")
+	}
+	if err := um.SetNotifications("alice", "Alice <alice@example.com>", true); err != nil {
+		t.Fatal(err)
+	}
+	if user = um.User("alice"); user.Email != "alice@example.com" || !user.Digest {
+		t.Errorf("after SetNotifications() user = %+v", user)
+	}
+}
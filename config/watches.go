@@ -0,0 +1,149 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+)
+
+// Watching determines whether the user is watching the given page or file, either directly or through one of the
+// paths above it.
+func (u *User) Watching(name string) bool {
+	name = NormalisePath(name)
+	for i := range u.Watches {
+		if name == u.Watches[i] || strings.HasPrefix(name, u.Watches[i]+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// SetNotifications sets the address that the user's notifications are sent to, and whether they're sent as a daily
+// digest. An empty address disables notifications.
+func (a *UserManager) SetNotifications(username, email string, digest bool) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user := a.editUser(username)
+	if user == nil {
+		return errors.New("user does not exist")
+	}
+
+	email, err := parseEmail(email)
+	if err != nil {
+		return err
+	}
+
+	if a.emailTaken(email, user.Name) {
+		return errors.New("email address is used by another account")
+	}
+
+	user.Email = email
+	user.Digest = digest
+	return a.save(user.Name, fmt.Sprintf("Changing notification settings for user: %s", user.Name))
+}
+
+// SetEmail sets the user's email address. An empty address removes it.
+func (a *UserManager) SetEmail(username, email, responsible string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user := a.editUser(username)
+	if user == nil {
+		return errors.New("user does not exist")
+	}
+
+	email, err := parseEmail(email)
+	if err != nil {
+		return err
+	}
+
+	if a.emailTaken(email, user.Name) {
+		return errors.New("email address is used by another account")
+	}
+
+	user.Email = email
+	return a.save(responsible, fmt.Sprintf("Changing email address for user: %s", user.Name))
+}
+
+// emailTaken determines whether an account other than the named one, or a pending account, uses the email address.
+// Addresses identify accounts for password resets, so they can't be shared.
+func (a *UserManager) emailTaken(email, username string) bool {
+	if email == "" {
+		return false
+	}
+
+	for name, u := range a.users {
+		if name != strings.ToLower(username) && strings.EqualFold(u.Email, email) {
+			return true
+		}
+	}
+	for name, p := range a.pending {
+		if name != strings.ToLower(username) && strings.EqualFold(p.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEmail validates an email address, returning it without any display name.
+func parseEmail(email string) (string, error) {
+	if email = strings.TrimSpace(email); email == "" {
+		return "", nil
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return "", fmt.Errorf("invalid email address")
+	}
+	return address.Address, nil
+}
+
+// Watch adds a path to the user's watch list.
+func (a *UserManager) Watch(username, path string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user := a.editUser(username)
+	if user == nil {
+		return errors.New("user does not exist")
+	}
+
+	path = NormalisePath(path)
+	if path == "" {
+		return errors.New("a path must be given")
+	}
+
+	for i := range user.Watches {
+		if user.Watches[i] == path {
+			return nil
+		}
+	}
+
+	watches := append([]string{path}, user.Watches...)
+	sort.Strings(watches)
+	user.Watches = watches
+	return a.save(user.Name, fmt.Sprintf("Watching %s for user: %s", path, user.Name))
+}
+
+// Unwatch removes a path from the user's watch list.
+func (a *UserManager) Unwatch(username, path string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user := a.editUser(username)
+	if user == nil {
+		return errors.New("user does not exist")
+	}
+
+	path = NormalisePath(path)
+	for i := range user.Watches {
+		if user.Watches[i] == path {
+			user.Watches = append(user.Watches[:i:i], user.Watches[i+1:]...)
+			return a.save(user.Name, fmt.Sprintf("Unwatching %s for user: %s", path, user.Name))
+		}
+	}
+	return errors.New("path is not being watched")
+}
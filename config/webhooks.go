@@ -0,0 +1,139 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const webhookSettingsName = "webhooks"
+
+// Webhook sends details of changes made to the wiki to an external URL.
+type Webhook struct {
+	ID  string
+	URL string
+	// Secret is used to sign the body of each request, so the receiver can verify it came from the wiki.
+	Secret string
+	// Events lists the types of change that trigger the webhook. If empty, all changes do.
+	Events []string
+}
+
+// Wants determines whether the webhook should be triggered by the given type of change.
+func (w *Webhook) Wants(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+
+	for i := range w.Events {
+		if w.Events[i] == event {
+			return true
+		}
+	}
+	return false
+}
+
+type Webhooks struct {
+	mutex sync.RWMutex
+	Hooks []Webhook
+
+	store Store
+}
+
+func LoadWebhooks(store Store) (*Webhooks, error) {
+	w := &Webhooks{
+		store: store,
+	}
+
+	if err := store.GetSettings(webhookSettingsName, &w); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// AllWebhooks returns a copy of all the configured webhooks.
+func (w *Webhooks) AllWebhooks() []Webhook {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	return append([]Webhook(nil), w.Hooks...)
+}
+
+// Webhook returns the webhook with the given ID, or nil if it doesn't exist.
+func (w *Webhooks) Webhook(id string) *Webhook {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	for i := range w.Hooks {
+		if w.Hooks[i].ID == id {
+			hook := w.Hooks[i]
+			return &hook
+		}
+	}
+	return nil
+}
+
+// AddWebhook adds a new webhook for the given URL. If no secret is given, a random one is generated.
+func (w *Webhooks) AddWebhook(target string, secret string, events []string, responsible string) error {
+	u, err := url.Parse(strings.TrimSpace(target))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook URL must be an absolute http or https URL")
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return err
+	}
+
+	if secret == "" {
+		secret, err = randomHex(32)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.Hooks = append(w.Hooks, Webhook{
+		ID:     id,
+		URL:    u.String(),
+		Secret: secret,
+		Events: events,
+	})
+	return w.store.PutSettings(webhookSettingsName, responsible, fmt.Sprintf("Adding webhook for %s", u.Redacted()), w)
+}
+
+// DeleteWebhook removes the webhook with the given ID.
+func (w *Webhooks) DeleteWebhook(id string, responsible string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for i := range w.Hooks {
+		if w.Hooks[i].ID == id {
+			target := w.Hooks[i].URL
+			w.Hooks = append(w.Hooks[:i], w.Hooks[i+1:]...)
+			return w.store.PutSettings(webhookSettingsName, responsible, fmt.Sprintf("Deleting webhook for %s", redactURL(target)), w)
+		}
+	}
+	return fmt.Errorf("webhook not found")
+}
+
+func redactURL(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	return u.Redacted()
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("unable to generate random bytes: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -8,17 +8,50 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 )
 
+// ChangeType describes what caused a change to the repository.
+type ChangeType string
+
+const (
+	ChangeCreate ChangeType = "create"
+	ChangeEdit   ChangeType = "edit"
+	ChangeDelete ChangeType = "delete"
+	ChangeRename ChangeType = "rename"
+	ChangeRevert ChangeType = "revert"
+	ChangeUpload ChangeType = "upload"
+	ChangeConfig ChangeType = "config"
+	ChangePush   ChangeType = "push"
+	ChangePull   ChangeType = "pull"
+)
+
+// Change describes a change made to the repository. For pushes and pulls, which may include several commits, the
+// user, message and time are those of the new HEAD commit.
+type Change struct {
+	Type    ChangeType
+	Commit  plumbing.Hash
+	User    string
+	Message string
+	Time    time.Time
+	Paths   []string
+}
+
+// CommitHook is called after the backend has changed the repository, with details of the change including the new
+// HEAD commit and the paths that were modified. Hooks are called with the backend's lock held, so must not call back
+// into it.
+type CommitHook func(change *Change)
+
 type GitBackend struct {
 	// mutex guards access to git commands. A read or write lock should be acquired in all exported methods,
 	// and released at the end (via a deferral).
 	mutex sync.RWMutex
 	dir   string
 	repo  *git.Repository
+	hooks []CommitHook
 }
 
 func NewGitBackend(dataDirectory string) (*GitBackend, error) {
@@ -33,6 +66,37 @@ func NewGitBackend(dataDirectory string) (*GitBackend, error) {
 	}, nil
 }
 
+// AddCommitHook registers a hook to be called with the details of every change made to the repository.
+func (g *GitBackend) AddCommitHook(hook CommitHook) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.hooks = append(g.hooks, hook)
+}
+
+func (g *GitBackend) runHooks(changeType ChangeType, commit plumbing.Hash, paths ...string) {
+	if len(g.hooks) == 0 {
+		return
+	}
+
+	change := &Change{
+		Type:   changeType,
+		Commit: commit,
+		Paths:  paths,
+	}
+	if c, err := g.repo.CommitObject(commit); err != nil {
+		log.Printf("Unable to read commit %s for commit hooks: %v", commit, err)
+	} else {
+		change.User = c.Author.Name
+		change.Message = c.Message
+		change.Time = c.Author.When
+	}
+
+	for i := range g.hooks {
+		g.hooks[i](change)
+	}
+}
+
 func openOrInit(dataDirectory string) (*git.Repository, error) {
 	gitRepo, err := git.PlainOpen(dataDirectory)
 	if err == nil {
@@ -99,7 +99,7 @@ func (g *GitBackend) RevertPage(title, revision, user, message string) error {
 		return err
 	}
 
-	return g.writeFile(filePath, gitPath, bytes.NewReader(b), user, message)
+	return g.writeFile(ChangeRevert, filePath, gitPath, bytes.NewReader(b), user, message)
 }
 
 // pathAtRevision gets the contents of the given path at the given revision, along the with commit object.
@@ -180,8 +180,10 @@ func (g *GitBackend) RecentChanges(start string, count int) ([]*RecentChange, er
 				entry.Config = strings.TrimSuffix(filepath.Base(name), ".json.enc")
 			} else if path.Ext(name) == ".md" {
 				entry.Page = strings.TrimSuffix(name, ".md")
+				entry.Paths = append(entry.Paths, entry.Page)
 			} else {
 				entry.File = name
+				entry.Paths = append(entry.Paths, entry.File)
 			}
 		}
 
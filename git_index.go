@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PageIndex is something that needs to be kept up to date with the content of every page in the wiki.
+type PageIndex interface {
+	Put(name string, content []byte)
+	Remove(name string)
+}
+
+// AddPageIndex adds all existing pages to the index, and registers a hook to keep it updated as further changes
+// are made.
+func (g *GitBackend) AddPageIndex(index PageIndex) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.hooks = append(g.hooks, func(change *Change) {
+		g.updatePages(index, change.Paths)
+	})
+
+	return g.walkFiles(func(filePath, webPath string, info fs.DirEntry) error {
+		if filepath.Ext(filePath) != ".md" {
+			return nil
+		}
+
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		index.Put(strings.TrimSuffix(webPath, ".md"), content)
+		return nil
+	})
+}
+
+// updatePages updates the index with the current content of the pages at the given paths, removing any that no
+// longer exist. Paths that aren't pages are ignored.
+func (g *GitBackend) updatePages(index PageIndex, paths []string) {
+	for i := range paths {
+		if !strings.HasSuffix(paths[i], ".md") || strings.HasPrefix(paths[i], ".wiki/") {
+			continue
+		}
+
+		name := strings.TrimSuffix(paths[i], ".md")
+		content, err := os.ReadFile(filepath.Join(g.dir, filepath.FromSlash(paths[i])))
+		if errors.Is(err, fs.ErrNotExist) {
+			index.Remove(name)
+		} else if err != nil {
+			log.Printf("Unable to index page %s: %v", name, err)
+		} else {
+			index.Put(name, content)
+		}
+	}
+}
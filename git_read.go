@@ -5,8 +5,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-
-	"github.com/go-git/go-git/v5"
+	"strings"
 )
 
 func (g *GitBackend) GetPage(title string) (*Page, error) {
@@ -17,16 +16,35 @@ func (g *GitBackend) GetFile(name string) (io.ReadCloser, error) {
 	g.mutex.RLock()
 	defer g.mutex.RUnlock()
 
-	_, gitPath, err := g.resolvePath(g.dir, name)
-	_, b, err := g.pathAtRevision(gitPath, revision)
+	filePath, _, err := g.resolvePath(g.dir, name)
 	if err != nil {
 		return nil, err
 	}
 
-	return b
+	return os.Open(filePath)
 }
 
 func (g *GitBackend) GetConfig(name string) ([]byte, error) {
 	filePath := filepath.Join(g.dir, ".wiki", fmt.Sprintf("%s.json.enc", name))
 	return os.ReadFile(filePath)
 }
+
+func (g *GitBackend) ConfigNames() ([]string, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	entries, err := os.ReadDir(filepath.Join(g.dir, ".wiki"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for i := range entries {
+		if name, ok := strings.CutSuffix(entries[i].Name(), ".json.enc"); ok && entries[i].Type().IsRegular() {
+			res = append(res, name)
+		}
+	}
+	return res, nil
+}
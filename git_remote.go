@@ -0,0 +1,370 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+const remoteName = "origin"
+
+// ErrDiverged is returned when pulling from a remote whose history has diverged from the local repository.
+var ErrDiverged = errors.New("local and remote histories have diverged")
+
+// SetRemote configures the remote repository that will be used for pushing and pulling.
+func (g *GitBackend) SetRemote(url string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if remote, err := g.repo.Remote(remoteName); err == nil {
+		if urls := remote.Config().URLs; len(urls) == 1 && urls[0] == url {
+			return nil
+		}
+
+		if err := g.repo.DeleteRemote(remoteName); err != nil {
+			return err
+		}
+	}
+
+	_, err := g.repo.CreateRemote(&gitconfig.RemoteConfig{
+		Name: remoteName,
+		URLs: []string{url},
+	})
+	return err
+}
+
+// Push sends the current branch to the remote repository. Only fast-forward updates are performed.
+func (g *GitBackend) Push() error {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	branch, err := g.currentBranch()
+	if err != nil {
+		return err
+	}
+
+	if _, err := g.repo.Reference(branch, false); err != nil {
+		// Nothing has been committed yet, so there's nothing to push.
+		return nil
+	}
+
+	err = g.repo.Push(&git.PushOptions{
+		RemoteName: remoteName,
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec(fmt.Sprintf("%s:%s", branch, branch))},
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
+
+// Pull fetches the current branch from the remote repository and, if it contains new commits, fast-forwards the
+// local repository to match it. If both the local and remote repositories have new commits, ErrDiverged is
+// returned and nothing is changed. Returns true if any changes were pulled.
+func (g *GitBackend) Pull() (bool, error) {
+	g.mutex.RLock()
+	branch, err := g.currentBranch()
+	g.mutex.RUnlock()
+	if err != nil {
+		return false, err
+	}
+
+	trackingBranch := plumbing.NewRemoteReferenceName(remoteName, branch.Short())
+	if fetched, err := g.fetch(branch, trackingBranch); err != nil || !fetched {
+		return false, err
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if current, err := g.currentBranch(); err != nil {
+		return false, err
+	} else if current != branch {
+		return false, fmt.Errorf("branch changed from %s to %s while fetching", branch.Short(), current.Short())
+	}
+
+	// The fetch added objects behind the repository's back, so it has to look for them again
+	if storer, ok := g.repo.Storer.(interface{ Reindex() }); ok {
+		storer.Reindex()
+	}
+
+	remoteRef, err := g.repo.Reference(trackingBranch, true)
+	if err != nil {
+		return false, nil
+	}
+
+	remoteCommit, err := g.repo.CommitObject(remoteRef.Hash())
+	if err != nil {
+		return false, err
+	}
+
+	var localCommit *object.Commit
+	if localRef, err := g.repo.Reference(branch, true); err == nil {
+		if localRef.Hash() == remoteRef.Hash() {
+			return false, nil
+		}
+
+		localCommit, err = g.repo.CommitObject(localRef.Hash())
+		if err != nil {
+			return false, err
+		}
+
+		if isAncestor, err := remoteCommit.IsAncestor(localCommit); err != nil {
+			return false, err
+		} else if isAncestor {
+			// We're ahead of the remote; the changes will be pushed.
+			return false, nil
+		}
+
+		if isAncestor, err := localCommit.IsAncestor(remoteCommit); err != nil {
+			return false, err
+		} else if !isAncestor {
+			return false, ErrDiverged
+		}
+	}
+
+	paths, err := changedPaths(localCommit, remoteCommit)
+	if err != nil {
+		return false, err
+	}
+
+	if localCommit == nil {
+		// The local branch doesn't exist yet, so create it before resetting the worktree to it.
+		if err := g.repo.Storer.SetReference(plumbing.NewHashReference(branch, remoteCommit.Hash)); err != nil {
+			return false, err
+		}
+	}
+
+	worktree, err := g.repo.Worktree()
+	if err != nil {
+		return false, err
+	}
+
+	if err := worktree.Reset(&git.ResetOptions{Commit: remoteCommit.Hash, Mode: git.HardReset}); err != nil {
+		return false, err
+	}
+
+	log.Printf("Fast-forwarded to %s from remote, %d paths changed", remoteCommit.Hash, len(paths))
+	g.runHooks(ChangePull, remoteCommit.Hash, paths...)
+	return true, nil
+}
+
+// fetch updates the tracking branch from the remote repository, returning false if the branch doesn't exist there
+// yet. It doesn't need the backend's lock, as it uses a separate copy of the repository that only adds objects and
+// changes the tracking branch, so slow remotes don't hold up everything else.
+func (g *GitBackend) fetch(branch, trackingBranch plumbing.ReferenceName) (bool, error) {
+	repo, err := git.PlainOpen(g.dir)
+	if err != nil {
+		return false, err
+	}
+
+	err = repo.Fetch(&git.FetchOptions{
+		RemoteName: remoteName,
+		RefSpecs:   []gitconfig.RefSpec{gitconfig.RefSpec(fmt.Sprintf("+%s:%s", branch, trackingBranch))},
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		if errors.Is(err, git.NoMatchingRefSpecError{}) || errors.Is(err, transport.ErrEmptyRemoteRepository) {
+			// The branch doesn't exist on the remote yet.
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// currentBranch returns the name of the branch that HEAD points to.
+func (g *GitBackend) currentBranch() (plumbing.ReferenceName, error) {
+	head, err := g.repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return "", err
+	}
+
+	if head.Type() != plumbing.SymbolicReference {
+		return "", errors.New("HEAD is detached")
+	}
+
+	return head.Target(), nil
+}
+
+// changedPaths returns all paths that differ between the two commits. If from is nil, all paths in the second
+// commit are returned.
+func changedPaths(from, to *object.Commit) ([]string, error) {
+	toTree, err := to.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	var fromTree *object.Tree
+	if from != nil {
+		fromTree, err = from.Tree()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := object.DiffTree(fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var paths []string
+	for i := range changes {
+		for _, name := range []string{changes[i].From.Name, changes[i].To.Name} {
+			if name != "" && !seen[name] {
+				seen[name] = true
+				paths = append(paths, name)
+			}
+		}
+	}
+	return paths, nil
+}
+
+type SyncStatus struct {
+	// Remote is the URL of the remote repository, without any credentials it contains.
+	Remote       string
+	PushOnCommit bool
+	Interval     time.Duration
+	LastPush     time.Time
+	LastPull     time.Time
+	LastError    string
+	LastErrorAt  time.Time
+	Diverged     bool
+}
+
+// RemoteSync periodically pulls changes from a remote repository, and pushes local changes to it either
+// immediately after they are committed or on the same interval.
+type RemoteSync struct {
+	backend   *GitBackend
+	remoteUrl string
+	interval  time.Duration
+	trigger   chan struct{}
+
+	mutex  sync.RWMutex
+	status SyncStatus
+}
+
+func NewRemoteSync(backend *GitBackend, remoteUrl string, interval time.Duration, pushOnCommit bool) (*RemoteSync, error) {
+	if err := backend.SetRemote(remoteUrl); err != nil {
+		return nil, fmt.Errorf("unable to configure remote: %w", err)
+	}
+
+	s := &RemoteSync{
+		backend:   backend,
+		remoteUrl: remoteUrl,
+		interval:  interval,
+		trigger:   make(chan struct{}, 1),
+		status: SyncStatus{
+			Remote:       redactURL(remoteUrl),
+			PushOnCommit: pushOnCommit,
+			Interval:     interval,
+		},
+	}
+
+	if pushOnCommit {
+		backend.AddCommitHook(func(*Change) {
+			select {
+			case s.trigger <- struct{}{}:
+			default:
+				// A push is already pending, and it will include this commit.
+			}
+		})
+	}
+
+	return s, nil
+}
+
+// Run pushes and pulls changes until the process exits. If the interval is zero, changes are only pushed after
+// they're committed, and never pulled.
+func (s *RemoteSync) Run() {
+	var tick <-chan time.Time
+	if s.interval > 0 {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			_ = s.Sync()
+		case <-s.trigger:
+			_ = s.push()
+		}
+	}
+}
+
+// Sync pulls any changes from the remote, and then pushes any local changes to it.
+func (s *RemoteSync) Sync() error {
+	if err := s.pull(); err != nil {
+		return err
+	}
+	return s.push()
+}
+
+func (s *RemoteSync) pull() error {
+	if _, err := s.backend.Pull(); err != nil {
+		s.recordError("pull", err)
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status.LastPull = time.Now()
+	s.status.Diverged = false
+	return nil
+}
+
+func (s *RemoteSync) push() error {
+	if err := s.backend.Push(); err != nil {
+		s.recordError("push", err)
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status.LastPush = time.Now()
+	return nil
+}
+
+func (s *RemoteSync) recordError(action string, err error) {
+	// Errors may quote the URL, which shouldn't reveal its credentials any more than the status does
+	message := strings.ReplaceAll(err.Error(), s.remoteUrl, s.status.Remote)
+	log.Printf("Unable to %s remote %s: %s", action, s.status.Remote, message)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status.LastError = fmt.Sprintf("Unable to %s: %s", action, message)
+	s.status.LastErrorAt = time.Now()
+	if errors.Is(err, ErrDiverged) {
+		s.status.Diverged = true
+	}
+}
+
+func (s *RemoteSync) Status() SyncStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.status
+}
+
+// redactURL removes any username and password from a remote's URL, so it can be logged and shown to users. URLs that
+// can't be parsed, such as scp-style addresses, can't contain a password so are returned unchanged.
+func redactURL(remoteUrl string) string {
+	u, err := url.Parse(remoteUrl)
+	if err != nil || u.User == nil {
+		return remoteUrl
+	}
+
+	u.User = nil
+	return u.String()
+}
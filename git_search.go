@@ -1,74 +1,66 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"errors"
-	"io/fs"
-	"os"
-	"path/filepath"
-	"strings"
+	"log"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mdbot/wiki/search"
 )
 
-func (g *GitBackend) SearchWiki(pattern string) []SearchResult {
-	trimPrefix := filepath.Clean(g.dir) + string(filepath.Separator)
-	patternBytes := bytes.ToLower([]byte(pattern))
-	results := searchDirectory(g.dir, patternBytes)
-	var output []SearchResult
-	for index := range results {
-		output = append(output, SearchResult{
-			Filename:   strings.TrimSuffix(strings.TrimPrefix(results[index].Filename, trimPrefix), ".md"),
-			FoundLines: results[index].FoundLines,
-		})
-	}
-	return output
-}
+// IndexPages brings the search index up to date with the repository, and registers a hook to keep it updated as
+// further changes are made.
+func (g *GitBackend) IndexPages(index *search.Index) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
 
-type SearchResult struct {
-	Filename   string
-	FoundLines []string
-}
+	g.hooks = append(g.hooks, func(change *Change) {
+		g.updateIndex(index, change.Commit, change.Paths)
+	})
 
-func searchDirectory(path string, pattern []byte) []SearchResult {
-	results := make([]SearchResult, 0)
-	_ = filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
-		if d.IsDir() ||
-			strings.HasPrefix(path, ".git") ||
-			strings.HasPrefix(path, ".wiki") ||
-			!strings.HasSuffix(path, ".md") {
-			return nil
-		}
-		result, err := searchFile(path, pattern)
-		if err == nil {
-			results = append(results, result)
-		}
+	head, err := g.repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		// Nothing has been committed yet
+		index.Clear()
 		return nil
-	})
-	return results
-}
+	} else if err != nil {
+		return err
+	}
 
-func searchFile(file string, pattern []byte) (SearchResult, error) {
-	f, err := os.Open(file)
+	headCommit, err := g.repo.CommitObject(head.Hash())
 	if err != nil {
-		return SearchResult{}, err
-	}
-	defer func() {
-		_ = f.Close()
-	}()
-	result := SearchResult{
-		Filename:   file,
-		FoundLines: nil,
+		return err
 	}
-	found := false
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		if bytes.Contains(bytes.ToLower(scanner.Bytes()), pattern) {
-			found = true
-			result.FoundLines = append(result.FoundLines, scanner.Text())
+
+	var indexCommit *object.Commit
+	if rev := index.Commit(); rev != "" {
+		if rev == head.Hash().String() {
+			return nil
+		}
+
+		indexCommit, err = g.repo.CommitObject(plumbing.NewHash(rev))
+		if err != nil {
+			log.Printf("Search index was built from unknown revision %s, rebuilding", rev)
 		}
 	}
-	if found {
-		return result, nil
+
+	if indexCommit == nil {
+		index.Clear()
 	}
-	return SearchResult{}, errors.New("no result")
+
+	paths, err := changedPaths(indexCommit, headCommit)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Updating search index with %d changed paths", len(paths))
+	g.updateIndex(index, head.Hash(), paths)
+	return nil
+}
+
+// updateIndex re-indexes the pages at the given paths, and records the commit the index is now up to date with.
+func (g *GitBackend) updateIndex(index *search.Index, commit plumbing.Hash, paths []string) {
+	g.updatePages(index, paths)
+	index.SetCommit(commit.String())
 }
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+)
+
+// PushRejectedError is returned when a push is refused because of the changes it contains.
+type PushRejectedError struct {
+	Reason string
+}
+
+func (e *PushRejectedError) Error() string {
+	return e.Reason
+}
+
+// storerLoader is a server.Loader that always provides the same storer.
+type storerLoader struct {
+	storer storer.Storer
+}
+
+func (s storerLoader) Load(*transport.Endpoint) (storer.Storer, error) {
+	return s.storer, nil
+}
+
+func (g *GitBackend) gitServer() transport.Transport {
+	return server.NewServer(storerLoader{storer: g.repo.Storer})
+}
+
+// AdvertisedReferences returns the references advertised to git clients for the given service, which should be
+// either transport.UploadPackServiceName or transport.ReceivePackServiceName.
+func (g *GitBackend) AdvertisedReferences(ctx context.Context, service string) (*packp.AdvRefs, error) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	endpoint, _ := transport.NewEndpoint("/")
+	switch service {
+	case transport.UploadPackServiceName:
+		session, err := g.gitServer().NewUploadPackSession(endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer session.Close()
+		return session.AdvertisedReferencesContext(ctx)
+	case transport.ReceivePackServiceName:
+		session, err := g.gitServer().NewReceivePackSession(endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer session.Close()
+
+		refs, err := session.AdvertisedReferencesContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Refs can't be deleted, only the current branch updated.
+		refs.Capabilities.Delete(capability.DeleteRefs)
+		return refs, nil
+	default:
+		return nil, fmt.Errorf("unsupported service: %s", service)
+	}
+}
+
+// UploadPack handles a single stateless upload-pack request from a git client, as used for fetching and cloning.
+func (g *GitBackend) UploadPack(ctx context.Context, body io.Reader, w io.Writer) error {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	req := packp.NewUploadPackRequest()
+	if err := req.UploadRequest.Decode(body); err != nil {
+		return err
+	}
+
+	done, err := readHaves(body, &req.UploadHaves)
+	if err != nil {
+		return err
+	}
+
+	if !done {
+		// The client is still negotiating which objects it needs. We don't support multi_ack, so just acknowledge
+		// the first object in common (at which point the client will stop), or tell it to carry on.
+		for i := range req.Haves {
+			if _, err := g.repo.Storer.EncodedObject(plumbing.AnyObject, req.Haves[i]); err == nil {
+				return pktline.NewEncoder(w).Encodef("ACK %s\n", req.Haves[i])
+			}
+		}
+		return pktline.NewEncoder(w).Encodef("NAK\n")
+	}
+
+	// Only tell the pack generator about the objects we actually have.
+	var haves []plumbing.Hash
+	for i := range req.Haves {
+		if _, err := g.repo.Storer.EncodedObject(plumbing.AnyObject, req.Haves[i]); err == nil {
+			haves = append(haves, req.Haves[i])
+		}
+	}
+	req.Haves = haves
+
+	endpoint, _ := transport.NewEndpoint("/")
+	session, err := g.gitServer().NewUploadPackSession(endpoint, nil)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	res, err := session.UploadPack(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	return res.Encode(w)
+}
+
+// readHaves reads the "have" lines sent by the client during negotiation, and reports whether the client has
+// finished negotiating.
+func readHaves(r io.Reader, haves *packp.UploadHaves) (bool, error) {
+	scanner := pktline.NewScanner(r)
+	for scanner.Scan() {
+		line := bytes.TrimSuffix(scanner.Bytes(), []byte("\n"))
+		if len(line) == 0 {
+			// Flush packets separate batches of haves.
+			continue
+		}
+
+		if bytes.Equal(line, []byte("done")) {
+			return true, nil
+		}
+
+		if hash, ok := bytes.CutPrefix(line, []byte("have ")); ok {
+			haves.Haves = append(haves.Haves, plumbing.NewHash(string(hash)))
+			continue
+		}
+
+		return false, fmt.Errorf("unexpected line in upload-pack request: %q", line)
+	}
+	return false, scanner.Err()
+}
+
+// ReceivePack handles a receive-pack request from a git client, as used for pushing. Only fast-forward updates to
+// the current branch are accepted, and any changes to private data will be rejected. The check function is given
+// all paths changed by the push, and may return an error to reject it. Once accepted, the working tree is updated
+// to match the pushed commit.
+func (g *GitBackend) ReceivePack(ctx context.Context, body io.Reader, w io.Writer, check func(paths []string) error) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	reader := bufio.NewReader(body)
+	req := packp.NewReferenceUpdateRequest()
+	if err := req.Decode(io.NopCloser(reader)); err != nil {
+		return err
+	}
+
+	status := packp.NewReportStatus()
+	status.UnpackStatus = "ok"
+
+	// The packfile is omitted entirely if there are no objects to send
+	if _, err := reader.Peek(1); err == nil {
+		if err := packfile.UpdateObjectStorage(g.repo.Storer, req.Packfile); err != nil {
+			status.UnpackStatus = err.Error()
+		}
+		_ = req.Packfile.Close()
+	}
+
+	var head plumbing.Hash
+	var updated []string
+	for i := range req.Commands {
+		cmdStatus := "ok"
+		if status.UnpackStatus != "ok" {
+			cmdStatus = "unpacker error"
+		} else if paths, err := g.receiveCommand(req.Commands[i], check); err != nil {
+			log.Printf("Rejected push to %s: %v", req.Commands[i].Name, err)
+			cmdStatus = err.Error()
+		} else {
+			log.Printf("Accepted push to %s: %s -> %s", req.Commands[i].Name, req.Commands[i].Old, req.Commands[i].New)
+			head = req.Commands[i].New
+			updated = append(updated, paths...)
+		}
+
+		status.CommandStatuses = append(status.CommandStatuses, &packp.CommandStatus{
+			ReferenceName: req.Commands[i].Name,
+			Status:        cmdStatus,
+		})
+	}
+
+	if !head.IsZero() {
+		g.runHooks(ChangePush, head, updated...)
+	}
+
+	if req.Capabilities.Supports(capability.ReportStatus) {
+		return status.Encode(w)
+	}
+	return nil
+}
+
+// receiveCommand validates and applies a single reference update from a push, returning the paths that changed.
+func (g *GitBackend) receiveCommand(cmd *packp.Command, check func(paths []string) error) ([]string, error) {
+	branch, err := g.currentBranch()
+	if err != nil {
+		return nil, err
+	}
+
+	if cmd.Name != branch {
+		return nil, fmt.Errorf("only the %s branch can be pushed to", branch.Short())
+	}
+
+	if cmd.Action() == packp.Delete {
+		return nil, errors.New("the branch can't be deleted")
+	}
+
+	var oldCommit *object.Commit
+	if ref, err := g.repo.Reference(branch, true); err == nil {
+		if ref.Hash() != cmd.Old {
+			return nil, errors.New("stale info, fetch first")
+		}
+
+		oldCommit, err = g.repo.CommitObject(ref.Hash())
+		if err != nil {
+			return nil, err
+		}
+	} else if !cmd.Old.IsZero() {
+		return nil, errors.New("stale info, fetch first")
+	}
+
+	newCommit, err := g.repo.CommitObject(cmd.New)
+	if err != nil {
+		return nil, err
+	}
+
+	if oldCommit != nil {
+		if isAncestor, err := oldCommit.IsAncestor(newCommit); err != nil {
+			return nil, err
+		} else if !isAncestor {
+			return nil, errors.New("non-fast-forward")
+		}
+	}
+
+	paths, err := changedPaths(oldCommit, newCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range paths {
+		if strings.HasPrefix(paths[i], ".wiki/") {
+			return nil, &PushRejectedError{Reason: "changes to .wiki are not permitted"}
+		}
+
+		if _, gitPath, err := g.resolvePath(g.dir, paths[i]); err != nil || gitPath != paths[i] {
+			return nil, &PushRejectedError{Reason: fmt.Sprintf("invalid path %s (paths must be lower case)", paths[i])}
+		}
+	}
+
+	if err := check(paths); err != nil {
+		return nil, err
+	}
+
+	if err := g.repo.Storer.SetReference(plumbing.NewHashReference(branch, newCommit.Hash)); err != nil {
+		return nil, err
+	}
+
+	worktree, err := g.repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := worktree.Reset(&git.ResetOptions{Commit: newCommit.Hash, Mode: git.HardReset}); err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
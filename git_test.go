@@ -1,7 +1,11 @@
 package main
 
 import (
+	"errors"
+	"io"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -127,3 +131,170 @@ func Test_resolvePath(t *testing.T) {
 		})
 	}
 }
+
+func TestGitBackend_RenamePage(t *testing.T) {
+	backend := newTestBackend(t)
+	pages := map[string]string{
+		"old":       "I link to [[old#top|myself]].",
+		"linker":    "See [[Old]] and [[Old|the old page]].",
+		"unchanged": "See [[old]] too.",
+	}
+	for name, content := range pages {
+		if err := backend.PutPage(name, "", []byte(content), "user", "create"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var changed []string
+	backend.AddCommitHook(func(change *Change) {
+		changed = append(changed, change.Paths...)
+	})
+
+	if err := backend.RenamePage("old", "new", []string{"old", "linker"}, false, "rename", "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	assertPageContent(t, backend, "new", "I link to [[new#top|myself]].")
+	assertPageContent(t, backend, "linker", "See [[new]] and [[new|the old page]].")
+	assertPageContent(t, backend, "unchanged", "See [[old]] too.")
+
+	if backend.PageExists("old") {
+		t.Errorf("old page still exists after rename")
+	}
+
+	if want := []string{"old.md", "new.md", "linker.md"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("rename notified hooks of %v, want %v", changed, want)
+	}
+
+	history, err := backend.PageHistory("linker", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Entries) != 2 || history.Entries[0].Message != "rename" {
+		t.Errorf("link rewrite wasn't committed with the rename")
+	}
+
+	if err := backend.RenamePage("new", "newer", nil, true, "rename again", "user"); err != nil {
+		t.Fatal(err)
+	}
+
+	assertPageContent(t, backend, "new", "#REDIRECT [[newer]]\n")
+	assertPageContent(t, backend, "newer", "I link to [[new#top|myself]].")
+}
+
+func TestGitBackend_CommitHooks(t *testing.T) {
+	backend := newTestBackend(t)
+
+	var changes []*Change
+	backend.AddCommitHook(func(change *Change) {
+		changes = append(changes, change)
+	})
+
+	steps := []func() error{
+		func() error { return backend.PutPage("page", "", []byte("one"), "alice", "create") },
+		func() error { return backend.PutPage("page", "", []byte("two"), "bob", "edit") },
+		func() error { return backend.RevertPage("page", "HEAD~1", "alice", "revert") },
+		func() error { return backend.RenamePage("page", "moved", nil, false, "rename", "alice") },
+		func() error {
+			return backend.PutFile("notes.txt", io.NopCloser(strings.NewReader("notes")), "alice", "upload")
+		},
+		func() error { return backend.PutConfig("test", []byte("{}"), "alice", "config") },
+		func() error { return backend.DeletePage("moved", "delete", "alice") },
+	}
+	for i := range steps {
+		if err := steps[i](); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []ChangeType{ChangeCreate, ChangeEdit, ChangeRevert, ChangeRename, ChangeUpload, ChangeConfig, ChangeDelete}
+	var got []ChangeType
+	for i := range changes {
+		got = append(got, changes[i].Type)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("change hooks got %v, want %v", got, want)
+	}
+
+	if c := changes[1]; c.User != "bob" || c.Message != "edit" || c.Commit.IsZero() || !reflect.DeepEqual(c.Paths, []string{"page.md"}) {
+		t.Errorf("edit change = %+v", c)
+	}
+}
+
+func TestGitBackend_PutConfigs(t *testing.T) {
+	backend := newTestBackend(t)
+
+	if names, err := backend.ConfigNames(); err != nil || len(names) != 0 {
+		t.Fatalf("ConfigNames() = %v, %v, want none", names, err)
+	}
+
+	if err := backend.PutConfig("users", []byte("one"), "alice", "config"); err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []*Change
+	backend.AddCommitHook(func(change *Change) {
+		changes = append(changes, change)
+	})
+
+	if err := backend.PutConfigs(map[string][]byte{"users": []byte("two"), "secrets": []byte("three")}, "alice", "rotate"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes) != 1 || !reflect.DeepEqual(changes[0].Paths, []string{".wiki/secrets.json.enc", ".wiki/users.json.enc"}) {
+		t.Fatalf("PutConfigs() made changes %+v, want a single change to both files", changes)
+	}
+
+	names, err := backend.ConfigNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"secrets", "users"}) {
+		t.Errorf("ConfigNames() = %v, want [secrets users]", names)
+	}
+
+	if content, err := backend.GetConfig("users"); err != nil || string(content) != "two" {
+		t.Errorf("GetConfig() = %s, %v, want two", content, err)
+	}
+}
+
+func TestGitBackend_RenamePageFailure(t *testing.T) {
+	backend := newTestBackend(t)
+	pages := map[string]string{
+		"old":    "Old page",
+		"taken":  "Already here",
+		"linker": "See [[old]].",
+	}
+	for name, content := range pages {
+		if err := backend.PutPage(name, "", []byte(content), "user", "create"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := backend.RenamePage("old", "taken", []string{"linker"}, false, "rename", "user"); !errors.Is(err, ErrPageExists) {
+		t.Errorf("RenamePage() onto an existing page error = %v, want %v", err, ErrPageExists)
+	}
+
+	// Fail after the page has been moved and links rewritten
+	if err := backend.RenamePage("old", "new", []string{"linker", "missing"}, false, "rename", "user"); err == nil {
+		t.Fatal("RenamePage() succeeded rewriting links in a missing page")
+	}
+
+	var changed []string
+	backend.AddCommitHook(func(change *Change) {
+		changed = append(changed, change.Paths...)
+	})
+	if err := backend.PutPage("other", "", []byte("Unrelated"), "user", "create"); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"other.md"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("next commit changed %v, want %v", changed, want)
+	}
+	assertPageContent(t, backend, "old", "Old page")
+	assertPageContent(t, backend, "taken", "Already here")
+	assertPageContent(t, backend, "linker", "See [[old]].")
+	if backend.PageExists("new") {
+		t.Error("failed rename left the new page behind")
+	}
+}
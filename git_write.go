@@ -2,18 +2,41 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mdbot/wiki/markdown"
 )
 
-func (g *GitBackend) PutPage(title string, content []byte, user string, message string) error {
+// ErrPageExists is returned when renaming a page to the name of one that already exists.
+var ErrPageExists = errors.New("page already exists")
+
+// EditConflictError is returned by PutPage when a page has been changed since the revision an edit was based on,
+// and the two sets of changes could not be merged automatically.
+type EditConflictError struct {
+	// Head is the current revision of the page, which any resolved edit should be based on.
+	Head string
+	// Content is the result of merging the two sets of changes, with conflicting regions delimited by markers.
+	Content []byte
+}
+
+func (e *EditConflictError) Error() string {
+	return fmt.Sprintf("page has been modified since it was opened for editing (now at %s)", e.Head)
+}
+
+// PutPage saves the content of the given page. If base is non-empty, it should be the revision that the edit started
+// from; if the page has been modified since then the changes are merged, or an EditConflictError returned if
+// a merge isn't possible.
+func (g *GitBackend) PutPage(title string, base string, content []byte, user string, message string) error {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 
@@ -22,7 +45,53 @@ func (g *GitBackend) PutPage(title string, content []byte, user string, message
 		return err
 	}
 
-	return g.writeFile(filePath, gitPath, bytes.NewReader(content), user, message)
+	if base != "" {
+		content, err = g.mergeChanges(gitPath, base, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	changeType := ChangeEdit
+	if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
+		changeType = ChangeCreate
+	}
+
+	return g.writeFile(changeType, filePath, gitPath, bytes.NewReader(content), user, message)
+}
+
+// mergeChanges checks if the given path has been modified since the base revision, and if so attempts to perform
+// a three-way merge of the changes.
+func (g *GitBackend) mergeChanges(gitPath, base string, content []byte) ([]byte, error) {
+	head, err := g.resolveRevision("HEAD")
+	if err != nil {
+		// No commits yet, so nothing can have changed.
+		return content, nil
+	}
+
+	_, theirs, err := g.pathAtRevision(gitPath, head.String())
+	if err != nil {
+		// The page doesn't currently exist, so there's nothing to conflict with.
+		return content, nil
+	}
+
+	// If the page didn't exist at the base revision, it's been created concurrently and we merge against nothing.
+	_, original, _ := g.pathAtRevision(gitPath, base)
+
+	if bytes.Equal(original, theirs) || bytes.Equal(content, theirs) {
+		return content, nil
+	}
+
+	log.Printf("Page %s was modified since revision %s, attempting to merge", gitPath, base)
+	merged, clean := mergeLines(string(original), string(content), string(theirs))
+	if !clean {
+		return nil, &EditConflictError{
+			Head:    head.String(),
+			Content: []byte(merged),
+		}
+	}
+
+	return []byte(merged), nil
 }
 
 func (g *GitBackend) PutFile(name string, content io.ReadCloser, user string, message string) error {
@@ -35,7 +104,7 @@ func (g *GitBackend) PutFile(name string, content io.ReadCloser, user string, me
 		return err
 	}
 
-	return g.writeFile(filePath, gitPath, content, user, message)
+	return g.writeFile(ChangeUpload, filePath, gitPath, content, user, message)
 }
 
 func (g *GitBackend) PutConfig(name string, content []byte, user string, message string) error {
@@ -45,10 +114,46 @@ func (g *GitBackend) PutConfig(name string, content []byte, user string, message
 	filePath := filepath.Join(g.dir, ".wiki", fmt.Sprintf("%s.json.enc", name))
 	gitPath := filepath.Join(".wiki", fmt.Sprintf("%s.json.enc", name))
 
-	return g.writeFile(filePath, gitPath, bytes.NewReader(content), user, message)
+	return g.writeFile(ChangeConfig, filePath, gitPath, bytes.NewReader(content), user, message)
 }
 
-func (g *GitBackend) writeFile(filePath, gitPath string, content io.Reader, user, message string) error {
+// PutConfigs writes several config files in a single commit.
+func (g *GitBackend) PutConfigs(contents map[string][]byte, user string, message string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	worktree, err := g.repo.Worktree()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(contents))
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var paths []string
+	for i := range names {
+		filePath := filepath.Join(g.dir, ".wiki", fmt.Sprintf("%s.json.enc", names[i]))
+		gitPath := filepath.Join(".wiki", fmt.Sprintf("%s.json.enc", names[i]))
+
+		if err := os.MkdirAll(filepath.Dir(filePath), os.FileMode(0755)); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filePath, contents[names[i]], os.FileMode(0644)); err != nil {
+			return err
+		}
+		if _, err := worktree.Add(gitPath); err != nil {
+			return err
+		}
+		paths = append(paths, gitPath)
+	}
+
+	return g.commit(worktree, ChangeConfig, message, user, paths...)
+}
+
+func (g *GitBackend) writeFile(changeType ChangeType, filePath, gitPath string, content io.Reader, user, message string) error {
 	if err := os.MkdirAll(filepath.Dir(filePath), os.FileMode(0755)); err != nil {
 		return err
 	}
@@ -76,48 +181,135 @@ func (g *GitBackend) writeFile(filePath, gitPath string, content io.Reader, user
 		return err
 	}
 
-	_, err = worktree.Commit(message, &git.CommitOptions{
+	return g.commit(worktree, changeType, message, user, gitPath)
+}
+
+// commit commits any staged changes in the worktree, and then notifies hooks that the given paths have changed.
+func (g *GitBackend) commit(worktree *git.Worktree, changeType ChangeType, message, user string, paths ...string) error {
+	hash, err := worktree.Commit(message, &git.CommitOptions{
 		Author: &object.Signature{
 			Name:  user,
 			Email: user + "@wiki",
 			When:  time.Now(),
 		},
 	})
-	return err
+	if err != nil {
+		return err
+	}
+
+	g.runHooks(changeType, hash, paths...)
+	return nil
 }
 
-func (g *GitBackend) RenamePage(name string, newName string, message string, user string) error {
+// RenamePage moves a page to a new name. Any wikilinks to the page from the pages listed in rewrite are updated
+// to point to the new name, and if redirect is true a page is left at the old name that redirects to the new one.
+// All changes are made in a single commit.
+func (g *GitBackend) RenamePage(name string, newName string, rewrite []string, redirect bool, message string, user string) (err error) {
 	g.mutex.Lock()
 	defer g.mutex.Unlock()
 
-	_, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", name))
+	filePath, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", name))
 	if err != nil {
 		log.Printf("Unable to resolve old path: %s -> %s: %s", name, newName, err.Error())
 		return err
 	}
-	_, newGitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", newName))
+	newFilePath, newGitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", newName))
 	if err != nil {
 		log.Printf("Unable to resolve new path: %s -> %s: %s", name, newName, err.Error())
 		return err
 	}
+	if _, err := os.Stat(newFilePath); err == nil {
+		return ErrPageExists
+	}
 	worktree, err := g.repo.Worktree()
 	if err != nil {
 		log.Printf("Unable to get worktree: %s", err.Error())
 		return err
 	}
+
+	// Don't leave a partial rename staged, or it would be included in the next commit
+	defer func() {
+		if err != nil {
+			if resetErr := worktree.Reset(&git.ResetOptions{Mode: git.HardReset}); resetErr != nil {
+				log.Printf("Unable to undo failed rename: %s -> %s: %s", name, newName, resetErr.Error())
+			}
+		}
+	}()
+
 	_, err = worktree.Move(gitPath, newGitPath)
 	if err != nil {
 		log.Printf("Unable to rename git: %s -> %s: %s", name, newName, err.Error())
 		return err
 	}
-	_, err = worktree.Commit(message, &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  user,
-			Email: user + "@wiki",
-			When:  time.Now(),
-		},
+
+	paths := []string{gitPath, newGitPath}
+	for i := range rewrite {
+		page := rewrite[i]
+		if _, pagePath, _ := g.resolvePath(g.dir, fmt.Sprintf("%s.md", page)); pagePath == gitPath {
+			// The page links to itself, and has already been moved
+			page = newName
+		}
+
+		changedPath, err := g.rewriteLinks(worktree, page, name, newName)
+		if err != nil {
+			log.Printf("Unable to rewrite links in %s: %s -> %s: %s", page, name, newName, err.Error())
+			return err
+		}
+		if changedPath != "" && changedPath != newGitPath {
+			paths = append(paths, changedPath)
+		}
+	}
+
+	if redirect {
+		if err := os.WriteFile(filePath, markdown.Redirect(newName), os.FileMode(0644)); err != nil {
+			log.Printf("Unable to write redirect: %s -> %s: %s", name, newName, err.Error())
+			return err
+		}
+		if _, err := worktree.Add(gitPath); err != nil {
+			log.Printf("Unable to add redirect: %s -> %s: %s", name, newName, err.Error())
+			return err
+		}
+	}
+
+	return g.commit(worktree, ChangeRename, message, user, paths...)
+}
+
+// rewriteLinks updates any wikilinks to the page from in the given page to point to the page to instead, and
+// stages the change. Returns the path of the page if it was modified.
+func (g *GitBackend) rewriteLinks(worktree *git.Worktree, page, from, to string) (string, error) {
+	filePath, gitPath, err := g.resolvePath(g.dir, fmt.Sprintf("%s.md", page))
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	from = normaliseLinkTarget(from)
+	newContent := markdown.RewriteWikiLinks(content, func(target string) (string, bool) {
+		if normaliseLinkTarget(target) != from {
+			return "", false
+		}
+		if _, anchor, ok := strings.Cut(target, "#"); ok {
+			return to + "#" + anchor, true
+		}
+		return to, true
 	})
-	return nil
+
+	if bytes.Equal(content, newContent) {
+		return "", nil
+	}
+
+	if err := os.WriteFile(filePath, newContent, os.FileMode(0644)); err != nil {
+		return "", err
+	}
+
+	if _, err := worktree.Add(gitPath); err != nil {
+		return "", err
+	}
+	return gitPath, nil
 }
 
 func (g *GitBackend) DeletePage(name string, message string, user string) error {
@@ -135,10 +327,14 @@ func (g *GitBackend) DeleteFile(name string, message string, user string) error
 }
 
 func (g *GitBackend) delete(name, message, user string) error {
-	_, gitPath, err := g.resolvePath(g.dir, name)
+	filePath, gitPath, err := g.resolvePath(g.dir, name)
 	if err != nil {
 		return err
 	}
+	// Let callers tell a missing file apart from other failures, which git doesn't
+	if _, err := os.Stat(filePath); err != nil {
+		return err
+	}
 	worktree, err := g.repo.Worktree()
 	if err != nil {
 		return err
@@ -147,12 +343,5 @@ func (g *GitBackend) delete(name, message, user string) error {
 	if err != nil {
 		return err
 	}
-	_, err = worktree.Commit(message, &git.CommitOptions{
-		Author: &object.Signature{
-			Name:  user,
-			Email: user + "@wiki",
-			When:  time.Now(),
-		},
-	})
-	return nil
+	return g.commit(worktree, ChangeDelete, message, user, gitPath)
 }
@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/gorilla/handlers"
@@ -43,3 +45,35 @@ func StripSlashes(next http.Handler) http.Handler {
 		next.ServeHTTP(writer, request)
 	})
 }
+
+// localRedirect returns the redirect if it's a path on this site, or else the fallback. Browsers treat backslashes
+// like slashes, so "/\example.com" is as much another site as "//example.com" is.
+func localRedirect(redirect, fallback string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") || strings.HasPrefix(redirect, "/\\") {
+		return fallback
+	}
+
+	u, err := url.Parse(redirect)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return fallback
+	}
+	return redirect
+}
+
+// clientIP returns the IP address the request came from. This is the address given by a trusted proxy if the
+// ForwardedFor middleware found one, otherwise the address of the connection.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(contextClientIPKey).(string); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// peerIP returns the IP address of the connection the request was made over, which may be a proxy.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mdbot/wiki/config"
+)
+
+type AclProvider interface {
+	AllRules() []config.AclRule
+}
+
+func AclHandler(t *Templates, ap AclProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t.RenderAcl(w, r, ap.AllRules())
+	}
+}
+
+type AclUpdater interface {
+	Update(rules []config.AclRule, responsible string) error
+}
+
+func UpdateAclHandler(updater AclUpdater) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseForm(); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Each rule is submitted as a row of prefix, read and write fields; rows without a prefix are discarded.
+		prefixes := request.PostForm["prefix"]
+		read := request.PostForm["read"]
+		write := request.PostForm["write"]
+		if len(read) != len(prefixes) || len(write) != len(prefixes) {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var rules []config.AclRule
+		for i := range prefixes {
+			if strings.TrimSpace(prefixes[i]) == "" {
+				continue
+			}
+
+			rules = append(rules, config.AclRule{
+				Prefix: prefixes[i],
+				Read:   strings.Split(read[i], ","),
+				Write:  strings.Split(write[i], ","),
+			})
+		}
+
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		if err := updater.Update(rules, username); err != nil {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to update access control rules: %v", err))
+		} else {
+			audit(request, AuditEvent{Action: auditConfigAcl, Detail: fmt.Sprintf("%d rules", len(rules))})
+			putSessionKey(writer, request, sessionNoticeKey, "Access control rules updated")
+		}
+
+		writer.Header().Set("location", "/wiki/acl")
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
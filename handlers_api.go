@@ -11,7 +11,7 @@ type Lister interface {
 	ListFiles() ([]File, error)
 }
 
-func ApiListHandler(l Lister) http.HandlerFunc {
+func ApiListHandler(l Lister, pm *PermissionChecker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var res []string
 
@@ -35,7 +35,7 @@ func ApiListHandler(l Lister) http.HandlerFunc {
 			res = pages
 		}
 
-		b, err := json.Marshal(res)
+		b, err := json.Marshal(pm.FilterReadable(r, res))
 		if err != nil {
 			log.Printf("Failed to marshal list contents: %v\n", err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	apiPagesPrefix   = "/api/v1/pages/"
+	apiFilesPrefix   = "/api/v1/files/"
+	apiHistoryPrefix = "/api/v1/history/"
+	apiDiffPrefix    = "/api/v1/diff/"
+	apiRenamePrefix  = "/api/v1/rename/"
+	apiRevertPrefix  = "/api/v1/revert/"
+
+	// apiDefaultLimit and apiMaxLimit control how many entries are returned by paginated endpoints.
+	apiDefaultLimit = 50
+	apiMaxLimit     = 500
+
+	// apiMaxRequestSize is the largest JSON request body that will be accepted.
+	apiMaxRequestSize = 10 << 20
+)
+
+type ApiLogEntry struct {
+	ChangeId string    `json:"changeId"`
+	User     string    `json:"user"`
+	Time     time.Time `json:"time"`
+	Message  string    `json:"message"`
+}
+
+type ApiPage struct {
+	Name         string       `json:"name"`
+	Content      string       `json:"content"`
+	LastModified *ApiLogEntry `json:"lastModified"`
+}
+
+type ApiChange struct {
+	Page   string `json:"page,omitempty"`
+	File   string `json:"file,omitempty"`
+	Config string `json:"config,omitempty"`
+	ApiLogEntry
+}
+
+type ApiFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+type ApiDiff struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type ApiSearchResult struct {
+	Name    string  `json:"name"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+func newApiLogEntry(e *LogEntry) ApiLogEntry {
+	return ApiLogEntry{
+		ChangeId: e.ChangeId,
+		User:     e.User,
+		Time:     e.Time,
+		Message:  e.Message,
+	}
+}
+
+// requestUsername returns the name that changes made by the request should be attributed to.
+func requestUsername(r *http.Request) string {
+	if user := getUserForRequest(r); user != nil {
+		return user.Name
+	}
+	return "Anonymoose"
+}
+
+// readApiRequest decodes the JSON body of the request into target, sending an error response if it's not valid.
+func readApiRequest(w http.ResponseWriter, r *http.Request, target interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		writeApiError(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+// apiPagination parses the "after" and "limit" query parameters, sending an error response if they're not valid.
+// Paginated responses start with the entry after the "after" revision, and include the revision to pass as "after"
+// to get the next page, if there is one.
+func apiPagination(w http.ResponseWriter, r *http.Request) (string, int, bool) {
+	limit := apiDefaultLimit
+	if l := r.FormValue("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			writeApiError(w, http.StatusBadRequest, "Limit must be a positive number")
+			return "", 0, false
+		}
+		limit = min(limit, apiMaxLimit)
+	}
+	return r.FormValue("after"), limit, true
+}
+
+func ApiListPagesHandler(pl PageLister, pm *PermissionChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pages, err := pl.ListPages()
+		if err != nil {
+			log.Printf("Failed to list pages: %v\n", err)
+			writeApiError(w, http.StatusInternalServerError, "Unable to list pages")
+			return
+		}
+		writeApiResponse(w, http.StatusOK, pm.FilterReadable(r, pages))
+	}
+}
+
+func ApiGetPageHandler(pp PageProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, apiPagesPrefix)
+
+		var page *Page
+		var err error
+		if rev := r.FormValue("rev"); rev != "" {
+			page, err = pp.GetPageAt(name, rev)
+		} else {
+			page, err = pp.GetPage(name)
+		}
+		if err != nil {
+			writeApiError(w, http.StatusNotFound, fmt.Sprintf("Page %s not found", name))
+			return
+		}
+
+		lastModified := newApiLogEntry(page.LastModified)
+		writeApiResponse(w, http.StatusOK, &ApiPage{
+			Name:         name,
+			Content:      string(page.Content),
+			LastModified: &lastModified,
+		})
+	}
+}
+
+type ApiPageEditor interface {
+	PageProvider
+	PageEditor
+	PageExists
+}
+
+// ApiPutPageHandler creates or updates a page. If the request includes the revision the edit was based on, changes
+// made since then are merged, and a conflict response returned if that's not possible.
+func ApiPutPageHandler(pe ApiPageEditor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, apiPagesPrefix)
+
+		var body struct {
+			Content string `json:"content"`
+			Message string `json:"message"`
+			Base    string `json:"base"`
+		}
+		if !readApiRequest(w, r, &body) {
+			return
+		}
+
+		status := http.StatusOK
+		if !pe.PageExists(name) {
+			status = http.StatusCreated
+		}
+
+		if err := pe.PutPage(name, body.Base, []byte(body.Content), requestUsername(r), body.Message); err != nil {
+			var conflict *EditConflictError
+			if errors.As(err, &conflict) {
+				writeApiResponse(w, http.StatusConflict, struct {
+					Error   string `json:"error"`
+					Head    string `json:"head"`
+					Content string `json:"content"`
+				}{conflict.Error(), conflict.Head, string(conflict.Content)})
+				return
+			}
+
+			log.Printf("Error saving page: %v\n", err)
+			writeApiError(w, http.StatusInternalServerError, "Unable to save page")
+			return
+		}
+
+		page, err := pe.GetPage(name)
+		if err != nil {
+			log.Printf("Error reading saved page: %v\n", err)
+			writeApiError(w, http.StatusInternalServerError, "Unable to read saved page")
+			return
+		}
+
+		lastModified := newApiLogEntry(page.LastModified)
+		writeApiResponse(w, status, &ApiPage{
+			Name:         name,
+			Content:      string(page.Content),
+			LastModified: &lastModified,
+		})
+	}
+}
+
+type ApiPageDeleter interface {
+	DeletePageProvider
+	PageExists
+}
+
+func ApiDeletePageHandler(pd ApiPageDeleter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, apiPagesPrefix)
+		if !pd.PageExists(name) {
+			writeApiError(w, http.StatusNotFound, fmt.Sprintf("Page %s not found", name))
+			return
+		}
+
+		if err := pd.DeletePage(name, r.FormValue("message"), requestUsername(r)); err != nil {
+			log.Printf("Error deleting page: %v\n", err)
+			writeApiError(w, http.StatusInternalServerError, "Unable to delete page")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type ApiPageRenamer interface {
+	RenamePageProvider
+	PageExists
+}
+
+func ApiRenamePageHandler(pr ApiPageRenamer, bp BacklinkProvider, pm *PermissionChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, apiRenamePrefix)
+
+		var body struct {
+			NewName       string `json:"newName"`
+			Message       string `json:"message"`
+			RewriteLinks  bool   `json:"rewriteLinks"`
+			LeaveRedirect bool   `json:"leaveRedirect"`
+		}
+		if !readApiRequest(w, r, &body) {
+			return
+		}
+
+		newName := strings.ToLower(strings.Trim(body.NewName, "/"))
+		if newName == "" {
+			writeApiError(w, http.StatusBadRequest, "A new name must be given")
+			return
+		}
+
+		if !pr.PageExists(name) {
+			writeApiError(w, http.StatusNotFound, fmt.Sprintf("Page %s not found", name))
+			return
+		}
+
+		if pr.PageExists(newName) {
+			writeApiError(w, http.StatusConflict, fmt.Sprintf("Page %s already exists", newName))
+			return
+		}
+
+		user := getUserForRequest(r)
+		if !pm.CanWritePath(user, newName) {
+			writeApiError(w, http.StatusForbidden, fmt.Sprintf("You don't have permission to create %s", newName))
+			return
+		}
+
+		var rewrite []string
+		if body.RewriteLinks {
+			// Only touch pages that the user could have edited themselves
+			for _, page := range bp.LinksTo(name) {
+				if pm.CanWritePath(user, page) {
+					rewrite = append(rewrite, page)
+				}
+			}
+		}
+
+		if err := pr.RenamePage(name, newName, rewrite, body.LeaveRedirect, body.Message, requestUsername(r)); errors.Is(err, ErrPageExists) {
+			writeApiError(w, http.StatusConflict, fmt.Sprintf("Page %s already exists", newName))
+			return
+		} else if err != nil {
+			log.Printf("Error renaming page: %v\n", err)
+			writeApiError(w, http.StatusInternalServerError, "Unable to rename page")
+			return
+		}
+		w.Header().Set("Location", apiPagesPrefix+newName)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type ApiPageReverter interface {
+	RevertPageProvider
+	PageProvider
+}
+
+func ApiRevertPageHandler(pr ApiPageReverter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, apiRevertPrefix)
+
+		var body struct {
+			Revision string `json:"revision"`
+			Message  string `json:"message"`
+		}
+		if !readApiRequest(w, r, &body) {
+			return
+		}
+
+		if body.Revision == "" {
+			writeApiError(w, http.StatusBadRequest, "A revision must be given")
+			return
+		}
+
+		if _, err := pr.GetPageAt(name, body.Revision); err != nil {
+			writeApiError(w, http.StatusNotFound, fmt.Sprintf("Page %s not found at revision %s", name, body.Revision))
+			return
+		}
+
+		if err := pr.RevertPage(name, body.Revision, requestUsername(r), body.Message); err != nil {
+			log.Printf("Error reverting page: %v\n", err)
+			writeApiError(w, http.StatusInternalServerError, "Unable to revert page")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func ApiPageHistoryHandler(hp HistoryProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, apiHistoryPrefix)
+		start, limit, ok := apiPagination(w, r)
+		if !ok {
+			return
+		}
+
+		// Request the start entry, the ones we want to return, then an extra one to tell if there's another page
+		history, err := hp.PageHistory(name, start, limit+2)
+		if err != nil || (start == "" && len(history.Entries) == 0) {
+			writeApiError(w, http.StatusNotFound, fmt.Sprintf("No history found for %s", name))
+			return
+		}
+
+		entries := history.Entries
+		if start != "" && len(entries) > 0 && entries[0].ChangeId == start {
+			entries = entries[1:]
+		}
+
+		res := struct {
+			Entries []ApiLogEntry `json:"entries"`
+			Next    string        `json:"next,omitempty"`
+		}{Entries: []ApiLogEntry{}}
+		if len(entries) > limit {
+			entries = entries[:limit]
+			res.Next = entries[limit-1].ChangeId
+		}
+		for i := range entries {
+			res.Entries = append(res.Entries, newApiLogEntry(entries[i]))
+		}
+		writeApiResponse(w, http.StatusOK, res)
+	}
+}
+
+func ApiRecentChangesHandler(rp RecentChangesProvider, pm *PermissionChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start, limit, ok := apiPagination(w, r)
+		if !ok {
+			return
+		}
+
+		// Request the start entry, the ones we want to return, then an extra one to tell if there's another page.
+		// RecentChanges returns one fewer entry than requested.
+		changes, err := rp.RecentChanges(start, limit+3)
+		if err != nil {
+			writeApiError(w, http.StatusNotFound, "Unable to find changes")
+			return
+		}
+
+		if start != "" && len(changes) > 0 && changes[0].ChangeId == start {
+			changes = changes[1:]
+		}
+
+		res := struct {
+			Changes []ApiChange `json:"changes"`
+			Next    string      `json:"next,omitempty"`
+		}{Changes: []ApiChange{}}
+		if len(changes) > limit {
+			changes = changes[:limit]
+			res.Next = changes[limit-1].ChangeId
+		}
+		for _, c := range filterChanges(r, pm, changes) {
+			res.Changes = append(res.Changes, ApiChange{
+				Page:        c.Page,
+				File:        c.File,
+				Config:      c.Config,
+				ApiLogEntry: newApiLogEntry(&c.LogEntry),
+			})
+		}
+		writeApiResponse(w, http.StatusOK, res)
+	}
+}
+
+func ApiDiffHandler(dp DiffProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, apiDiffPrefix)
+		startRevision := r.FormValue("startrev")
+		endRevision := r.FormValue("endrev")
+		if startRevision == "" || endRevision == "" {
+			writeApiError(w, http.StatusBadRequest, "Both startrev and endrev must be given")
+			return
+		}
+
+		diff, err := dp.PathDiff(name, startRevision, endRevision)
+		if err != nil {
+			writeApiError(w, http.StatusNotFound, fmt.Sprintf("Page %s not found at both revisions", name))
+			return
+		}
+
+		types := map[diffmatchpatch.Operation]string{
+			diffmatchpatch.DiffEqual:  "equal",
+			diffmatchpatch.DiffInsert: "insert",
+			diffmatchpatch.DiffDelete: "delete",
+		}
+		res := make([]ApiDiff, len(diff))
+		for i := range diff {
+			res[i] = ApiDiff{Type: types[diff[i].Type], Text: diff[i].Text}
+		}
+		writeApiResponse(w, http.StatusOK, res)
+	}
+}
+
+func ApiListFilesHandler(fl FileLister, pm *PermissionChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		files, err := fl.ListFiles()
+		if err != nil {
+			log.Printf("Failed to list files: %v\n", err)
+			writeApiError(w, http.StatusInternalServerError, "Unable to list files")
+			return
+		}
+
+		user := getUserForRequest(r)
+		res := []ApiFile{}
+		for i := range files {
+			if pm.CanReadPath(user, files[i].Name) {
+				res = append(res, ApiFile{Name: files[i].Name, Size: files[i].Size})
+			}
+		}
+		writeApiResponse(w, http.StatusOK, res)
+	}
+}
+
+func ApiGetFileHandler(provider FileProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveFile(w, provider, strings.TrimPrefix(r.URL.Path, apiFilesPrefix))
+	}
+}
+
+// ApiPutFileHandler uploads a file, taking its content from the raw request body.
+func ApiPutFileHandler(store FileStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, apiFilesPrefix)
+		if !strings.ContainsRune(name, '.') {
+			writeApiError(w, http.StatusBadRequest, "File names must have an extension")
+			return
+		}
+
+		content := http.MaxBytesReader(w, r.Body, 1<<30)
+		if err := store.PutFile(name, content, requestUsername(r), r.URL.Query().Get("message")); err != nil {
+			log.Printf("Upload failed: couldn't save file: %v", err)
+			writeApiError(w, http.StatusInternalServerError, "Unable to save file")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func ApiDeleteFileHandler(provider DeleteFileProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, apiFilesPrefix)
+		if err := provider.DeleteFile(name, r.FormValue("message"), requestUsername(r)); errors.Is(err, os.ErrNotExist) {
+			writeApiError(w, http.StatusNotFound, fmt.Sprintf("File %s not found", name))
+			return
+		} else if err != nil {
+			log.Printf("Error deleting file: %v\n", err)
+			writeApiError(w, http.StatusInternalServerError, "Unable to delete file")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func ApiSearchHandler(searcher Searcher, pm *PermissionChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.FormValue("q")
+		if query == "" {
+			writeApiError(w, http.StatusBadRequest, "A query must be given")
+			return
+		}
+
+		_, limit, ok := apiPagination(w, r)
+		if !ok {
+			return
+		}
+
+		user := getUserForRequest(r)
+		results, total := searcher.Search(query, limit, func(name string) bool {
+			return pm.CanReadPath(user, name)
+		})
+
+		res := struct {
+			Results []ApiSearchResult `json:"results"`
+			Total   int               `json:"total"`
+		}{Results: []ApiSearchResult{}, Total: total}
+		for i := range results {
+			res.Results = append(res.Results, ApiSearchResult{
+				Name:    results[i].Name,
+				Score:   results[i].Score,
+				Snippet: results[i].Snippet,
+			})
+		}
+		writeApiResponse(w, http.StatusOK, res)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func newTestApiServer(t *testing.T) *httptest.Server {
+	backend := newTestBackend(t)
+	links := NewLinkGraph()
+	pm := &PermissionChecker{requireAuthForWrites: false}
+
+	router := mux.NewRouter()
+	router.Path("/api/v1/pages").Handler(ApiListPagesHandler(backend, pm)).Methods(http.MethodGet)
+	router.PathPrefix(apiPagesPrefix).Handler(ApiGetPageHandler(backend)).Methods(http.MethodGet)
+	router.PathPrefix(apiPagesPrefix).Handler(ApiPutPageHandler(backend)).Methods(http.MethodPut)
+	router.PathPrefix(apiPagesPrefix).Handler(ApiDeletePageHandler(backend)).Methods(http.MethodDelete)
+	router.PathPrefix(apiRenamePrefix).Handler(ApiRenamePageHandler(backend, links, pm)).Methods(http.MethodPost)
+	router.PathPrefix(apiRevertPrefix).Handler(ApiRevertPageHandler(backend)).Methods(http.MethodPost)
+	router.PathPrefix(apiHistoryPrefix).Handler(ApiPageHistoryHandler(backend)).Methods(http.MethodGet)
+	router.PathPrefix(apiDiffPrefix).Handler(ApiDiffHandler(backend)).Methods(http.MethodGet)
+	router.PathPrefix(apiFilesPrefix).Handler(ApiGetFileHandler(backend)).Methods(http.MethodGet)
+	router.PathPrefix(apiFilesPrefix).Handler(ApiPutFileHandler(backend)).Methods(http.MethodPut)
+	router.PathPrefix(apiFilesPrefix).Handler(ApiDeleteFileHandler(backend)).Methods(http.MethodDelete)
+	router.Path("/api/v1/forbidden").Handler((&PermissionChecker{requireAuthForReads: true}).RequireRead(ApiListPagesHandler(backend, pm)))
+
+	server := httptest.NewServer(ApiErrorHandler(router))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// apiRequest makes a request to the test server, and decodes the JSON response into target if it's not nil.
+func apiRequest(t *testing.T, server *httptest.Server, method, path, body string, target interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if target != nil {
+		if err := json.NewDecoder(res.Body).Decode(target); err != nil {
+			t.Fatalf("%s %s returned invalid JSON: %v", method, path, err)
+		}
+	}
+	return res.StatusCode
+}
+
+func TestApi_Pages(t *testing.T) {
+	server := newTestApiServer(t)
+
+	var page ApiPage
+	if status := apiRequest(t, server, http.MethodPut, "/api/v1/pages/test", `{"content": "one\ntwo\n", "message": "first"}`, &page); status != http.StatusCreated {
+		t.Fatalf("Creating page returned status %d, want 201", status)
+	}
+	first := page.LastModified.ChangeId
+
+	if status := apiRequest(t, server, http.MethodPut, "/api/v1/pages/test", `{"content": "one\nthree\n", "base": "`+first+`"}`, &page); status != http.StatusOK {
+		t.Fatalf("Updating page returned status %d, want 200", status)
+	}
+
+	var conflict struct {
+		Error   string `json:"error"`
+		Head    string `json:"head"`
+		Content string `json:"content"`
+	}
+	if status := apiRequest(t, server, http.MethodPut, "/api/v1/pages/test", `{"content": "one\nfour\n", "base": "`+first+`"}`, &conflict); status != http.StatusConflict {
+		t.Fatalf("Conflicting edit returned status %d, want 409", status)
+	}
+	if conflict.Head != page.LastModified.ChangeId || !strings.Contains(conflict.Content, "four") {
+		t.Errorf("Conflict response = %+v", conflict)
+	}
+
+	if status := apiRequest(t, server, http.MethodGet, "/api/v1/pages/test?rev="+first, "", &page); status != http.StatusOK || page.Content != "one\ntwo\n" {
+		t.Errorf("Getting old revision returned status %d, content %q", status, page.Content)
+	}
+
+	var diff []ApiDiff
+	if status := apiRequest(t, server, http.MethodGet, "/api/v1/diff/test?startrev="+first+"&endrev=HEAD", "", &diff); status != http.StatusOK || len(diff) == 0 {
+		t.Errorf("Diff returned status %d, diff %v", status, diff)
+	}
+
+	var history struct {
+		Entries []ApiLogEntry `json:"entries"`
+		Next    string        `json:"next"`
+	}
+	if status := apiRequest(t, server, http.MethodGet, "/api/v1/history/test?limit=1", "", &history); status != http.StatusOK || len(history.Entries) != 1 || history.Next == "" {
+		t.Fatalf("History returned status %d, history %+v", status, history)
+	}
+	after := history.Next
+	history.Next = ""
+	if status := apiRequest(t, server, http.MethodGet, "/api/v1/history/test?limit=1&after="+after, "", &history); status != http.StatusOK || len(history.Entries) != 1 || history.Entries[0].ChangeId != first || history.Next != "" {
+		t.Fatalf("Second page of history returned status %d, history %+v", status, history)
+	}
+
+	if status := apiRequest(t, server, http.MethodPost, "/api/v1/revert/test", `{"revision": "`+first+`"}`, nil); status != http.StatusNoContent {
+		t.Errorf("Revert returned status %d, want 204", status)
+	}
+	if status := apiRequest(t, server, http.MethodGet, "/api/v1/pages/test", "", &page); status != http.StatusOK || page.Content != "one\ntwo\n" {
+		t.Errorf("Getting reverted page returned status %d, content %q", status, page.Content)
+	}
+
+	if status := apiRequest(t, server, http.MethodPost, "/api/v1/rename/test", `{"newName": "renamed"}`, nil); status != http.StatusNoContent {
+		t.Errorf("Rename returned status %d, want 204", status)
+	}
+
+	if status := apiRequest(t, server, http.MethodPut, "/api/v1/pages/other", `{"content": "other"}`, &page); status != http.StatusCreated {
+		t.Fatalf("Creating page returned status %d, want 201", status)
+	}
+
+	var pages []string
+	if status := apiRequest(t, server, http.MethodGet, "/api/v1/pages", "", &pages); status != http.StatusOK || strings.Join(pages, ",") != "other,renamed" {
+		t.Errorf("Listing pages returned status %d, pages %v", status, pages)
+	}
+
+	if status := apiRequest(t, server, http.MethodDelete, "/api/v1/pages/renamed", "", nil); status != http.StatusNoContent {
+		t.Errorf("Delete returned status %d, want 204", status)
+	}
+
+	var apiError struct {
+		Error string `json:"error"`
+	}
+	if status := apiRequest(t, server, http.MethodGet, "/api/v1/pages/renamed", "", &apiError); status != http.StatusNotFound || apiError.Error == "" {
+		t.Errorf("Getting deleted page returned status %d, error %q", status, apiError.Error)
+	}
+	if status := apiRequest(t, server, http.MethodPut, "/api/v1/pages/test", `{"content": 1}`, &apiError); status != http.StatusBadRequest || apiError.Error == "" {
+		t.Errorf("Invalid request returned status %d, error %q", status, apiError.Error)
+	}
+}
+
+func TestApi_Files(t *testing.T) {
+	server := newTestApiServer(t)
+
+	// Deleting the only thing in the repository leaves nothing to commit
+	if status := apiRequest(t, server, http.MethodPut, "/api/v1/pages/test", `{"content": "test"}`, &ApiPage{}); status != http.StatusCreated {
+		t.Fatalf("Creating page returned status %d, want 201", status)
+	}
+
+	if status := apiRequest(t, server, http.MethodPut, "/api/v1/files/notes.txt?message=upload", "some notes", nil); status != http.StatusNoContent {
+		t.Fatalf("Upload returned status %d, want 204", status)
+	}
+
+	res, err := http.Get(server.URL + "/api/v1/files/notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK || string(b) != "some notes" {
+		t.Errorf("Download returned status %d, content %q", res.StatusCode, b)
+	}
+
+	if status := apiRequest(t, server, http.MethodDelete, "/api/v1/files/notes.txt", "", nil); status != http.StatusNoContent {
+		t.Errorf("Delete returned status %d, want 204", status)
+	}
+
+	var apiError struct {
+		Error string `json:"error"`
+	}
+	if status := apiRequest(t, server, http.MethodGet, "/api/v1/files/notes.txt", "", &apiError); status != http.StatusNotFound || apiError.Error != "Not Found" {
+		t.Errorf("Getting deleted file returned status %d, error %q", status, apiError.Error)
+	}
+	if status := apiRequest(t, server, http.MethodDelete, "/api/v1/files/notes.txt", "", nil); status != http.StatusNotFound {
+		t.Errorf("Deleting missing file returned status %d, want 404", status)
+	}
+}
+
+func TestApiErrorHandler(t *testing.T) {
+	server := newTestApiServer(t)
+
+	var apiError struct {
+		Error string `json:"error"`
+	}
+	if status := apiRequest(t, server, http.MethodGet, "/api/v1/forbidden", "", &apiError); status != http.StatusUnauthorized || apiError.Error != "Unauthorized" {
+		t.Errorf("Unauthorised request returned status %d, error %q", status, apiError.Error)
+	}
+	if status := apiRequest(t, server, http.MethodPatch, "/api/v1/pages/test", "", &apiError); status != http.StatusMethodNotAllowed || apiError.Error != "Method Not Allowed" {
+		t.Errorf("Request with wrong method returned status %d, error %q", status, apiError.Error)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// auditPageLimit is the number of events shown on the audit page. Exports include every matching event.
+const auditPageLimit = 500
+
+type AuditProvider interface {
+	Events(filter AuditFilter, limit int) ([]AuditEvent, error)
+}
+
+func AuditHandler(t *Templates, ap AuditProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter := auditFilter(r)
+		events, err := ap.Events(filter, auditPageLimit)
+		if err != nil {
+			log.Printf("Failed to read audit log: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		t.RenderAudit(w, r, filter, events, r.URL.RawQuery, len(events) == auditPageLimit)
+	}
+}
+
+// AuditExportHandler downloads the events matching the same filters as the audit page, as a JSON array.
+func AuditExportHandler(ap AuditProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		events, err := ap.Events(auditFilter(r), 0)
+		if err != nil {
+			log.Printf("Failed to read audit log: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if events == nil {
+			events = []AuditEvent{}
+		}
+
+		b, err := json.Marshal(events)
+		if err != nil {
+			log.Printf("Failed to marshal audit events: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.json"`)
+		_, _ = w.Write(b)
+	}
+}
+
+func auditFilter(r *http.Request) AuditFilter {
+	return AuditFilter{
+		User:   strings.TrimSpace(r.FormValue("user")),
+		Action: strings.TrimSpace(r.FormValue("action")),
+		IP:     strings.TrimSpace(r.FormValue("ip")),
+	}
+}
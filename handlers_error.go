@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
+
+	"github.com/gorilla/csrf"
 )
 
 type errorInterceptingWriter struct {
@@ -39,6 +43,11 @@ func (w *errorInterceptingWriter) shouldProxy() bool {
 func PageErrorHandler(t *Templates) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isApiRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			fakeWriter := &errorInterceptingWriter{realWriter: w}
 
 			next.ServeHTTP(fakeWriter, r)
@@ -63,3 +72,78 @@ func PageErrorHandler(t *Templates) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+func isApiRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// apiErrorWriter swallows error responses that aren't already JSON, so they can be replaced with a JSON error.
+type apiErrorWriter struct {
+	realWriter http.ResponseWriter
+	status     int
+	replace    bool
+}
+
+func (w *apiErrorWriter) Header() http.Header {
+	return w.realWriter.Header()
+}
+
+func (w *apiErrorWriter) WriteHeader(status int) {
+	w.status = status
+	if status >= http.StatusBadRequest && !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.replace = true
+		return
+	}
+	w.realWriter.WriteHeader(status)
+}
+
+func (w *apiErrorWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replace {
+		return len(p), nil
+	}
+	return w.realWriter.Write(p)
+}
+
+// ApiErrorHandler makes sure that error responses to API requests have a JSON body, rather than the HTML pages
+// rendered by PageErrorHandler or the plain text used by other handlers.
+func ApiErrorHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isApiRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		fakeWriter := &apiErrorWriter{realWriter: w}
+		next.ServeHTTP(fakeWriter, r)
+		if fakeWriter.replace {
+			writeApiError(w, fakeWriter.status, http.StatusText(fakeWriter.status))
+		}
+	})
+}
+
+// CsrfErrorHandler responds to requests that failed CSRF validation, using a JSON error for API requests.
+func CsrfErrorHandler(w http.ResponseWriter, r *http.Request) {
+	message := fmt.Sprintf("%s - %s", http.StatusText(http.StatusForbidden), csrf.FailureReason(r))
+	if isApiRequest(r) {
+		writeApiError(w, http.StatusForbidden, message)
+	} else {
+		http.Error(w, message, http.StatusForbidden)
+	}
+}
+
+// writeApiError sends a JSON response describing an error to an API client.
+func writeApiError(w http.ResponseWriter, status int, message string) {
+	writeApiResponse(w, status, struct {
+		Error string `json:"error"`
+	}{message})
+}
+
+// writeApiResponse sends the given value to an API client as JSON.
+func writeApiResponse(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
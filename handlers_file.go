@@ -15,7 +15,7 @@ type FileLister interface {
 	ListFiles() ([]File, error)
 }
 
-func ListFilesHandler(t *Templates, fl FileLister) http.HandlerFunc {
+func ListFilesHandler(t *Templates, fl FileLister, pm *PermissionChecker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		files, err := fl.ListFiles()
 		if err != nil {
@@ -24,7 +24,15 @@ func ListFilesHandler(t *Templates, fl FileLister) http.HandlerFunc {
 			return
 		}
 
-		t.RenderFileList(w, r, files)
+		user := getUserForRequest(r)
+		var readable []File
+		for i := range files {
+			if pm.CanReadPath(user, files[i].Name) {
+				readable = append(readable, files[i])
+			}
+		}
+
+		t.RenderFileList(w, r, readable)
 	}
 }
 
@@ -32,7 +40,7 @@ type FileStore interface {
 	PutFile(name string, content io.ReadCloser, user string, message string) error
 }
 
-func UploadHandler(store FileStore) http.HandlerFunc {
+func UploadHandler(store FileStore, pm *PermissionChecker) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		if err := request.ParseMultipartForm(1 << 30); err != nil {
 			log.Printf("Upload failed: couldn't parse multipart data: %v", err)
@@ -54,9 +62,16 @@ func UploadHandler(store FileStore) http.HandlerFunc {
 			return
 		}
 
+		user := getUserForRequest(request)
+		if !pm.CanWritePath(user, name) {
+			log.Printf("Upload failed: user doesn't have permission to write to %v", name)
+			writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+
 		message := request.FormValue("message")
 		username := "Anonymoose"
-		if user := getUserForRequest(request); user != nil {
+		if user != nil {
 			username = user.Name
 		}
 
@@ -80,36 +95,39 @@ type FileProvider interface {
 
 func FileHandler(provider FileProvider) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		name := strings.TrimPrefix(request.URL.Path, "/files/view/")
-		reader, err := provider.GetFile(name)
-		if err != nil {
-			writer.WriteHeader(http.StatusNotFound)
-			return
-		}
-		defer reader.Close()
+		serveFile(writer, provider, strings.TrimPrefix(request.URL.Path, "/files/view/"))
+	}
+}
 
-		mimeType := mime.TypeByExtension(filepath.Ext(name))
-		if mimeType == "" {
-			mimeType = "application/octet-stream"
-		}
+func serveFile(writer http.ResponseWriter, provider FileProvider, name string) {
+	reader, err := provider.GetFile(name)
+	if err != nil {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer reader.Close()
 
-		writer.Header().Add("Content-Type", mimeType)
-		writer.Header().Add("X-Content-Type-Options", "nosniff")
-		if !markdown.CanEmbed(mimeType) {
-			writer.Header().Add("Content-Disposition", "attachment")
-		}
-		_, _ = io.Copy(writer, reader)
+	mimeType := mime.TypeByExtension(filepath.Ext(name))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	writer.Header().Add("Content-Type", mimeType)
+	writer.Header().Add("X-Content-Type-Options", "nosniff")
+	if !markdown.CanEmbed(mimeType) {
+		writer.Header().Add("Content-Disposition", "attachment")
 	}
+	_, _ = io.Copy(writer, reader)
 }
 
 type DeleteFileProvider interface {
 	DeleteFile(name string, message string, user string) error
 }
 
-func DeleteFileConfirmHandler(t *Templates) http.HandlerFunc {
+func DeleteFileConfirmHandler(t *Templates, ep EmbedProvider, pm *PermissionChecker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		name := strings.TrimPrefix(r.URL.Path, "/files/delete/")
-		t.RenderDeleteFile(w, r, name)
+		t.RenderDeleteFile(w, r, name, pm.FilterReadable(r, ep.EmbedsOf(name)))
 	}
 }
 
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/mdbot/wiki/config"
+)
+
+const gitPathPrefix = "/repo.git/"
+
+type GitServer interface {
+	AdvertisedReferences(ctx context.Context, service string) (*packp.AdvRefs, error)
+	UploadPack(ctx context.Context, body io.Reader, w io.Writer) error
+	ReceivePack(ctx context.Context, body io.Reader, w io.Writer, check func(paths []string) error) error
+}
+
+type GitAuthenticator interface {
+	Authenticator
+	AuthenticateToken(token string) (*config.User, error)
+}
+
+// GitHandler serves the wiki's repository to git clients using the smart HTTP protocol. Clients authenticate using HTTP
+// basic auth, with either their password or an API token, and need read permission to fetch and write permission to
+// push. Users with two-factor authentication enabled, or who are required to enable it, must use a token, and failed
+// passwords count towards the same limits as logging in to the wiki. A clone contains every page, so only admins may
+// fetch if any access control rules restrict reading; pushes are rejected if they touch anything the user couldn't edit
+// through the wiki.
+func GitHandler(gs GitServer, auth GitAuthenticator, throttle LoginThrottle, pm *PermissionChecker, policy TwoFactorPolicy) http.Handler {
+	// authorise checks the user has the required permission, and if not writes an appropriate response.
+	authorise := func(w http.ResponseWriter, r *http.Request, service string) (*config.User, bool) {
+		var user *config.User
+		if username, password, ok := r.BasicAuth(); ok {
+			ip := clientIP(r)
+			if u, err := auth.AuthenticateToken(password); err == nil {
+				user = u
+			} else if err := throttle.Check(ip, username); err != nil {
+				log.Printf("Refused git authentication for user %s from %s: %v", username, ip, err)
+				http.Error(w, "Too many failed logins", http.StatusTooManyRequests)
+				return nil, false
+			} else if u, err := auth.Authenticate(username, password); err != nil {
+				log.Printf("Failed git authentication for user %s: %v", username, err)
+				throttle.Failed(ip, username)
+				audit(r, AuditEvent{User: username, Action: auditLoginFailed, Detail: "git: " + err.Error()})
+			} else if u.TwoFactorEnabled() {
+				log.Printf("Failed git authentication for user %s: password used with two-factor authentication enabled", username)
+			} else if policy.TwoFactorRequired(u) {
+				log.Printf("Failed git authentication for user %s: password used without required two-factor authentication", username)
+			} else {
+				throttle.Succeeded(ip, username)
+				user = u
+			}
+		}
+
+		allowed := pm.CanRead(user)
+		if service == transport.ReceivePackServiceName {
+			allowed = allowed && pm.CanWrite(user)
+		} else if pm.acl != nil && pm.acl.HasReadRules() {
+			allowed = allowed && pm.CanAdmin(user)
+		}
+
+		if allowed {
+			return user, true
+		}
+
+		if user == nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="wiki", charset="UTF-8"`)
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+		} else {
+			log.Printf("User %s (permissions: %s) tried to use git service %s", user.Name, user.Permissions.String(), service)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		}
+		return nil, false
+	}
+
+	requestBody := func(r *http.Request) (io.Reader, error) {
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			return gzip.NewReader(r.Body)
+		}
+		return r.Body, nil
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		action := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(gitPathPrefix, "/"))
+
+		switch {
+		case action == "/info/refs" && r.Method == http.MethodGet:
+			service := r.URL.Query().Get("service")
+			if service != transport.UploadPackServiceName && service != transport.ReceivePackServiceName {
+				http.Error(w, "Only the smart HTTP protocol is supported", http.StatusForbidden)
+				return
+			}
+
+			if _, ok := authorise(w, r, service); !ok {
+				return
+			}
+
+			refs, err := gs.AdvertisedReferences(r.Context(), service)
+			if err != nil {
+				log.Printf("Unable to advertise git references: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			refs.Prefix = [][]byte{[]byte(fmt.Sprintf("# service=%s", service)), pktline.Flush}
+			w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", service))
+			if err := refs.Encode(w); err != nil {
+				log.Printf("Unable to write git references: %v", err)
+			}
+
+		case action == "/"+transport.UploadPackServiceName && r.Method == http.MethodPost:
+			if _, ok := authorise(w, r, transport.UploadPackServiceName); !ok {
+				return
+			}
+
+			body, err := requestBody(r)
+			if err != nil {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+			if err := gs.UploadPack(r.Context(), body, w); err != nil {
+				log.Printf("Unable to handle git upload-pack: %v", err)
+			}
+
+		case action == "/"+transport.ReceivePackServiceName && r.Method == http.MethodPost:
+			user, ok := authorise(w, r, transport.ReceivePackServiceName)
+			if !ok {
+				return
+			}
+
+			body, err := requestBody(r)
+			if err != nil {
+				http.Error(w, "Bad request", http.StatusBadRequest)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/x-git-receive-pack-result")
+			err = gs.ReceivePack(r.Context(), body, w, func(paths []string) error {
+				for i := range paths {
+					if name := strings.TrimSuffix(paths[i], ".md"); !pm.CanWritePath(user, name) {
+						return &PushRejectedError{Reason: fmt.Sprintf("permission denied for %s", name)}
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				log.Printf("Unable to handle git receive-pack: %v", err)
+			}
+
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mdbot/wiki/config"
+)
+
+type testAuthenticator map[string]*config.User
+
+func (t testAuthenticator) Authenticate(username, password string) (*config.User, error) {
+	if u, ok := t[username]; ok && password == "password" {
+		return u, nil
+	}
+	return nil, errors.New("invalid username/password")
+}
+
+// AuthenticateToken accepts "wiki_" followed by a username as that user's token.
+func (t testAuthenticator) AuthenticateToken(token string) (*config.User, error) {
+	if u, ok := t[strings.TrimPrefix(token, "wiki_")]; ok && strings.HasPrefix(token, "wiki_") {
+		return u, nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+func runGit(t *testing.T, dir string, args ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// testTwoFactorPolicy requires the named users to set up two-factor authentication.
+type testTwoFactorPolicy map[string]bool
+
+func (p testTwoFactorPolicy) TwoFactorRequired(user *config.User) bool {
+	return p[user.Name]
+}
+
+func TestGitHandler(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git client not available")
+	}
+
+	backend := newTestBackend(t)
+	if err := backend.PutPage("page", "", []byte("one\n"), "user", "first"); err != nil {
+		t.Fatal(err)
+	}
+
+	var changed []string
+	backend.AddCommitHook(func(change *Change) {
+		changed = append(changed, change.Paths...)
+	})
+
+	auth := testAuthenticator{
+		"reader":  &config.User{Name: "reader", Permissions: config.PermissionRead},
+		"writer":  &config.User{Name: "writer", Permissions: config.PermissionWrite},
+		"secure":  &config.User{Name: "secure", Permissions: config.PermissionWrite, TotpSecret: []byte("secret")},
+		"pending": &config.User{Name: "pending", Permissions: config.PermissionWrite},
+	}
+	pm := &PermissionChecker{requireAuthForWrites: true}
+	server := httptest.NewServer(GitHandler(backend, auth, NewLoginLimiter(nil), pm, testTwoFactorPolicy{"pending": true}))
+	defer server.Close()
+
+	url := func(user string) string {
+		return strings.Replace(server.URL, "http://", "http://"+user+":password@", 1) + "/repo.git"
+	}
+
+	clone := filepath.Join(t.TempDir(), "clone")
+	if out, err := runGit(t, ".", "clone", url("reader"), clone); err != nil {
+		t.Fatalf("git clone failed: %v\n%s", err, out)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(clone, "page.md")); err != nil || string(b) != "one\n" {
+		t.Fatalf("cloned page.md = %q, %v; want \"one\\n\"", b, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(clone, "page.md"), []byte("two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit(t, clone, "commit", "-am", "edit"); err != nil {
+		t.Fatalf("git commit failed: %v\n%s", err, out)
+	}
+
+	if out, err := runGit(t, clone, "push", "origin", "HEAD"); err == nil {
+		t.Errorf("git push succeeded without write permission:\n%s", out)
+	}
+
+	// Users with two-factor authentication must use a token rather than their password
+	if out, err := runGit(t, clone, "push", "--dry-run", url("secure"), "HEAD"); err == nil {
+		t.Errorf("git push with password succeeded for two-factor user:\n%s", out)
+	}
+	if out, err := runGit(t, clone, "push", "--dry-run", url("pending"), "HEAD"); err == nil {
+		t.Errorf("git push with password succeeded for user required to set up two-factor:\n%s", out)
+	}
+	tokenUrl := strings.Replace(server.URL, "http://", "http://secure:wiki_secure@", 1) + "/repo.git"
+	if out, err := runGit(t, clone, "push", "--dry-run", tokenUrl, "HEAD"); err != nil {
+		t.Errorf("git push with token failed: %v\n%s", err, out)
+	}
+
+	if out, err := runGit(t, clone, "push", url("writer"), "HEAD"); err != nil {
+		t.Fatalf("git push failed: %v\n%s", err, out)
+	}
+	assertPageContent(t, backend, "page", "two\n")
+	if len(changed) != 1 || changed[0] != "page.md" {
+		t.Errorf("push notified hooks of %v, want [page.md]", changed)
+	}
+
+	// Make a change on the wiki side, and check it can be fetched into the existing clone
+	if err := backend.PutPage("page", "", []byte("three\n"), "user", "third"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit(t, clone, "pull", "--ff-only", "origin"); err != nil {
+		t.Fatalf("git pull failed: %v\n%s", err, out)
+	}
+	if b, err := os.ReadFile(filepath.Join(clone, "page.md")); err != nil || string(b) != "three\n" {
+		t.Fatalf("pulled page.md = %q, %v; want \"three\\n\"", b, err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(clone, ".wiki"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(clone, ".wiki", "users.json.enc"), []byte("nope"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runGit(t, clone, "add", "."); err != nil {
+		t.Fatalf("git add failed: %v\n%s", err, out)
+	}
+	if out, err := runGit(t, clone, "commit", "-m", "config"); err != nil {
+		t.Fatalf("git commit failed: %v\n%s", err, out)
+	}
+	if out, err := runGit(t, clone, "push", url("writer"), "HEAD"); err == nil || !strings.Contains(out, ".wiki") {
+		t.Errorf("git push of .wiki changes = %v, want rejection:\n%s", err, out)
+	}
+	assertPageContent(t, backend, "page", "three\n")
+}
@@ -69,7 +69,7 @@ type RecentChangesProvider interface {
 	RecentChanges(start string, count int) ([]*RecentChange, error)
 }
 
-func RecentChangesHandler(t *Templates, rp RecentChangesProvider) http.HandlerFunc {
+func RecentChangesHandler(t *Templates, rp RecentChangesProvider, pm *PermissionChecker) http.HandlerFunc {
 	const historySize = 50
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -98,11 +98,11 @@ func RecentChangesHandler(t *Templates, rp RecentChangesProvider) http.HandlerFu
 			number = len(history) + 1
 		}
 
-		t.RenderRecentChanges(w, r, history[:number-1], next)
+		t.RenderRecentChanges(w, r, filterChanges(r, pm, history[:number-1]), next)
 	}
 }
 
-func RecentChangesFeed(t *Templates, rp RecentChangesProvider) http.HandlerFunc {
+func RecentChangesFeed(t *Templates, rp RecentChangesProvider, pm *PermissionChecker) http.HandlerFunc {
 	const historySize = 50
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -112,10 +112,26 @@ func RecentChangesFeed(t *Templates, rp RecentChangesProvider) http.HandlerFunc
 			return
 		}
 
-		t.RenderRecentChangesFeed(w, r, history)
+		t.RenderRecentChangesFeed(w, r, filterChanges(r, pm, history))
 	}
 }
 
+// filterChanges removes any changes that touched a page or file the user making the request can't read.
+func filterChanges(r *http.Request, pm *PermissionChecker, changes []*RecentChange) []*RecentChange {
+	user := getUserForRequest(r)
+	var res []*RecentChange
+changes:
+	for i := range changes {
+		for _, p := range changes[i].Paths {
+			if !pm.CanReadPath(user, p) {
+				continue changes
+			}
+		}
+		res = append(res, changes[i])
+	}
+	return res
+}
+
 type DiffProvider interface {
 	PathDiff(path string, startRevision string, endRevision string) ([]diffmatchpatch.Diff, error)
 }
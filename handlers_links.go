@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type BacklinkProvider interface {
+	LinksTo(page string) []string
+}
+
+type EmbedProvider interface {
+	EmbedsOf(file string) []string
+}
+
+type LinkProvider interface {
+	Links(page string) *PageLinks
+}
+
+func LinksHandler(t *Templates, lp LinkProvider, pm *PermissionChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/links/")
+		links := lp.Links(name)
+		links.LinkedFrom = pm.FilterReadable(r, links.LinkedFrom)
+		t.RenderLinks(w, r, links)
+	}
+}
+
+func ApiLinksHandler(lp LinkProvider, pm *PermissionChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/api/links/")
+		links := lp.Links(name)
+		links.LinkedFrom = pm.FilterReadable(r, links.LinkedFrom)
+
+		b, err := json.Marshal(links)
+		if err != nil {
+			log.Printf("Failed to marshal links: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mdbot/wiki/config"
+)
+
+type MailSettingsProvider interface {
+	Server() config.MailServer
+}
+
+func MailSettingsHandler(t *Templates, mp MailSettingsProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t.RenderMailSettings(w, r, mp.Server())
+	}
+}
+
+type MailSettingsUpdater interface {
+	Update(server config.MailServer, responsible string) error
+}
+
+type TestMailSender interface {
+	SendTest(address string) error
+}
+
+func UpdateMailSettingsHandler(mu MailSettingsUpdater, sender TestMailSender) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseForm(); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		switch request.PostForm.Get("action") {
+		case "save":
+			port := 0
+			if p := strings.TrimSpace(request.PostForm.Get("port")); p != "" {
+				var err error
+				if port, err = strconv.Atoi(p); err != nil {
+					putSessionKey(writer, request, sessionErrorKey, "Unable to update email settings: invalid port")
+					break
+				}
+			}
+
+			err := mu.Update(config.MailServer{
+				Host:     request.PostForm.Get("host"),
+				Port:     port,
+				Username: request.PostForm.Get("username"),
+				Password: request.PostForm.Get("password"),
+				From:     request.PostForm.Get("from"),
+			}, username)
+			if err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to update email settings: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditConfigMail})
+				putSessionKey(writer, request, sessionNoticeKey, "Email settings updated")
+			}
+		case "test":
+			to := strings.TrimSpace(request.PostForm.Get("to"))
+			if err := sender.SendTest(to); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to send test email: %v", err))
+			} else {
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Test email sent to %s", to))
+			}
+		default:
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		writer.Header().Set("location", "/wiki/email")
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
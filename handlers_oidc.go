@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"github.com/mdbot/wiki/config"
+	"github.com/mdbot/wiki/oidc"
+)
+
+const (
+	oidcSessionName     = "wiki-sso"
+	oidcSessionState    = "state"
+	oidcSessionNonce    = "nonce"
+	oidcSessionVerifier = "verifier"
+	oidcSessionRedirect = "redirect"
+
+	oidcCallbackPath = "/wiki/sso/callback"
+)
+
+var oidcRedirectTemplate = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta http-equiv="refresh" content="0;url={{.}}">
+    <title>Logging in</title>
+</head>
+<body>
+<p><a href="{{.}}">Continue to the wiki</a></p>
+</body>
+</html>
+`))
+
+// OidcLogin lets users log in using an OpenID Connect identity provider.
+type OidcLogin struct {
+	provider *oidc.Provider
+	store    sessions.Store
+	baseUrl  string
+
+	usernameClaim string
+	groupsClaim   string
+	permissions   map[string]config.Permission
+}
+
+// NewOidcLogin configures single sign-on using the provider at the given issuer URL. The permissions mapping is a
+// comma-separated list of group=permission pairs, granting the permission to users with that group in their groups
+// claim.
+func NewOidcLogin(store sessions.Store, baseUrl, issuer, clientId, clientSecret, usernameClaim, groupsClaim, permissions string) (*OidcLogin, error) {
+	if baseUrl == "" {
+		return nil, fmt.Errorf("the wiki's URL must be configured to use single sign-on")
+	}
+
+	mapping, err := parsePermissionMapping(permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := oidc.Discover(context.Background(), issuer, clientId, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OidcLogin{
+		provider:      provider,
+		store:         store,
+		baseUrl:       strings.TrimSuffix(baseUrl, "/"),
+		usernameClaim: usernameClaim,
+		groupsClaim:   groupsClaim,
+		permissions:   mapping,
+	}, nil
+}
+
+// EndSessionURL returns the URL to log the user out of the identity provider, if it supports that. The ID token isn't
+// passed as a hint, as it's too large to keep in the session cookie; the client ID identifies the wiki instead.
+func (o *OidcLogin) EndSessionURL() string {
+	return o.provider.EndSessionURL("", o.baseUrl+"/")
+}
+
+// parsePermissionMapping parses a comma-separated list of group=permission pairs.
+func parsePermissionMapping(permissions string) (map[string]config.Permission, error) {
+	mapping := make(map[string]config.Permission)
+	for _, pair := range strings.Split(permissions, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		group, name, _ := strings.Cut(pair, "=")
+		permission, ok := permissionNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("invalid permission in mapping %q", pair)
+		}
+		mapping[strings.ToLower(strings.TrimSpace(group))] = permission
+	}
+	return mapping, nil
+}
+
+// permissionsFor returns the highest permission the mapping grants to any of the groups. If it doesn't cover any of
+// them, users only get config.PermissionAuth, so that taking someone out of a group takes away the permission it
+// gave them when they next log in. An empty mapping gives config.PermissionNone, leaving permissions to be managed
+// in the wiki.
+func permissionsFor(mapping map[string]config.Permission, groups []string) config.Permission {
+	if len(mapping) == 0 {
+		return config.PermissionNone
+	}
+
+	var res config.Permission = config.PermissionAuth
+	for i := range groups {
+		if p, ok := mapping[strings.ToLower(groups[i])]; ok && p > res {
+			res = p
+		}
+	}
+	return res
+}
+
+// OidcLoginHandler starts the login flow, sending the user to the identity provider.
+func OidcLoginHandler(o *OidcLogin) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		redirect := localRedirect(request.FormValue("redirect"), "/")
+
+		flow, err := oidc.NewFlow()
+		if err != nil {
+			log.Printf("Unable to start single sign-on: %v", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// The identity provider redirects back from another site, so this can't use the main session which is
+		// restricted to same-site requests.
+		s, _ := o.store.New(request, oidcSessionName)
+		s.Options.HttpOnly = true
+		s.Options.SameSite = http.SameSiteLaxMode
+		s.Options.MaxAge = 60 * 10
+		s.Values[oidcSessionState] = flow.State
+		s.Values[oidcSessionNonce] = flow.Nonce
+		s.Values[oidcSessionVerifier] = flow.Verifier
+		s.Values[oidcSessionRedirect] = redirect
+		if err := s.Save(request, writer); err != nil {
+			log.Printf("Unable to save single sign-on session: %v", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(writer, request, o.provider.AuthURL(flow, o.baseUrl+oidcCallbackPath), http.StatusFound)
+	}
+}
+
+type SingleSignOnProvisioner interface {
+	SingleSignOnUser(subject, username string, permissions config.Permission, groups []string) (*config.User, error)
+}
+
+// OidcCallbackHandler completes the login flow when the identity provider redirects back to the wiki.
+func OidcCallbackHandler(o *OidcLogin, provisioner SingleSignOnProvisioner) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		s, _ := o.store.Get(request, oidcSessionName)
+		flow := &oidc.Flow{}
+		flow.State, _ = s.Values[oidcSessionState].(string)
+		flow.Nonce, _ = s.Values[oidcSessionNonce].(string)
+		flow.Verifier, _ = s.Values[oidcSessionVerifier].(string)
+		redirect, _ := s.Values[oidcSessionRedirect].(string)
+		if redirect == "" {
+			redirect = "/"
+		}
+
+		// Each login attempt can only be completed once
+		s.Options.MaxAge = -1
+		_ = s.Save(request, writer)
+
+		user, err := o.complete(request, flow, provisioner)
+		if err != nil {
+			log.Printf("Single sign-on failed: %v", err)
+			audit(request, AuditEvent{Action: auditLoginFailed, Detail: "single sign-on: " + err.Error()})
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Failed to login: %v", err))
+		} else {
+			audit(request, AuditEvent{User: user.Name, Action: auditLogin, Detail: "single sign-on"})
+			startSession(writer, request, user)
+			putSessionKey(writer, request, sessionSingleSignOnKey, true)
+		}
+
+		// Browsers won't send the main session cookie if we redirect straight away, as the request chain started on
+		// the identity provider's site.
+		writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := oidcRedirectTemplate.Execute(writer, redirect); err != nil {
+			log.Printf("Unable to render single sign-on redirect: %v", err)
+		}
+	}
+}
+
+func (o *OidcLogin) complete(request *http.Request, flow *oidc.Flow, provisioner SingleSignOnProvisioner) (*config.User, error) {
+	query := request.URL.Query()
+	if e := query.Get("error"); e != "" {
+		return nil, fmt.Errorf("identity provider returned %s %s", e, query.Get("error_description"))
+	}
+
+	if flow.State == "" || query.Get("state") != flow.State {
+		return nil, fmt.Errorf("login request expired or invalid")
+	}
+
+	token, err := o.provider.Exchange(request.Context(), flow, query.Get("code"), o.baseUrl+oidcCallbackPath)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := token.Strings(o.groupsClaim)
+	return provisioner.SingleSignOnUser(token.Subject, token.String(o.usernameClaim), permissionsFor(o.permissions, groups), groups)
+}
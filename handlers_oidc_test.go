@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/mdbot/wiki/config"
+	"github.com/mdbot/wiki/oidc/oidctest"
+)
+
+// testProvisioner creates users for single sign-on logins, remembering the details it was given.
+type testProvisioner struct {
+	users       map[string]*config.User
+	permissions config.Permission
+	groups      []string
+}
+
+func (p *testProvisioner) SingleSignOnUser(subject, username string, permissions config.Permission, groups []string) (*config.User, error) {
+	p.permissions = permissions
+	p.groups = groups
+	user := &config.User{Name: username, Subject: subject, SessionKey: []byte(subject), Permissions: permissions}
+	p.users[username] = user
+	return user, nil
+}
+
+func (p *testProvisioner) User(name string) *config.User {
+	return p.users[name]
+}
+
+func (p *testProvisioner) AuthenticateToken(string) (*config.User, error) {
+	return nil, errors.New("invalid token")
+}
+
+func TestOidcLogin(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	idp.SetClaims(map[string]interface{}{"sub": "1234", "preferred_username": "alice", "groups": []string{"staff", "wiki-admins"}})
+
+	handler := http.NewServeMux()
+	wiki := httptest.NewServer(handler)
+	defer wiki.Close()
+
+	store := sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef"))
+	login, err := NewOidcLogin(store, wiki.URL, idp.URL, oidctest.ClientID, oidctest.ClientSecret, "preferred_username", "groups", "staff=write, wiki-admins=admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provisioner := &testProvisioner{users: make(map[string]*config.User)}
+	router := mux.NewRouter()
+	tracker, err := OpenSessionTracker(filepath.Join(t.TempDir(), "sessions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router.Use(SessionHandler(provisioner, store, tracker, nil))
+	router.Path("/wiki/sso/login").Handler(OidcLoginHandler(login))
+	router.Path(oidcCallbackPath).Handler(OidcCallbackHandler(login, provisioner))
+	router.Path("/wiki/logout").Handler(LogoutHandler(login))
+	router.Path("/whoami").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := getUserForRequest(r); user != nil {
+			_, _ = io.WriteString(w, user.Name)
+		}
+	})
+	handler.Handle("/", router)
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	res, err := client.Get(wiki.URL + "/wiki/sso/login?redirect=" + url.QueryEscape("/view/somepage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+
+	if res.Request.URL.Path != oidcCallbackPath || !strings.Contains(string(body), `url=/view/somepage`) {
+		t.Fatalf("Login ended at %s with body %s", res.Request.URL, body)
+	}
+
+	if provisioner.permissions != config.PermissionAdmin || len(provisioner.groups) != 2 {
+		t.Errorf("User provisioned with permissions %s and groups %v", provisioner.permissions, provisioner.groups)
+	}
+
+	res, err = client.Get(wiki.URL + "/whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if string(body) != "alice" {
+		t.Errorf("Logged in as %q after single sign-on, want alice", body)
+	}
+
+	// Logging out should also end the session at the identity provider
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	res, err = client.PostForm(wiki.URL+"/wiki/logout", url.Values{"redirect": {"/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if location := res.Header.Get("Location"); !strings.HasPrefix(location, idp.URL+"/logout?") {
+		t.Errorf("Logout redirected to %q, want the identity provider", location)
+	}
+
+	// A callback without a login in progress must be rejected
+	res, err = client.Get(wiki.URL + oidcCallbackPath + "?code=abc&state=def")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	res, err = client.Get(wiki.URL + "/whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if len(body) != 0 {
+		t.Errorf("Logged in as %q after logout and a forged callback", body)
+	}
+}
+
+func TestPermissionsFor(t *testing.T) {
+	mapping, err := parsePermissionMapping("staff=write, wiki-admins=admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		mapping map[string]config.Permission
+		groups  []string
+		want    config.Permission
+	}{
+		{"highest group", mapping, []string{"Staff", "wiki-admins"}, config.PermissionAdmin},
+		{"one group", mapping, []string{"staff", "other"}, config.PermissionWrite},
+		{"no mapped groups", mapping, []string{"other"}, config.PermissionAuth},
+		{"no groups", mapping, nil, config.PermissionAuth},
+		{"no mapping", map[string]config.Permission{}, []string{"staff"}, config.PermissionNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := permissionsFor(tt.mapping, tt.groups); got != tt.want {
+				t.Errorf("permissionsFor() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalRedirect(t *testing.T) {
+	tests := []struct {
+		redirect string
+		want     string
+	}{
+		{"/view/page", "/view/page"},
+		{"/view/page?x=1#top", "/view/page?x=1#top"},
+		{"", "/fallback"},
+		{"view/page", "/fallback"},
+		{"https://example.com/", "/fallback"},
+		{"//example.com", "/fallback"},
+		{"/\\example.com", "/fallback"},
+		{"/\t/example.com", "/fallback"},
+	}
+	for _, tt := range tests {
+		if got := localRedirect(tt.redirect, "/fallback"); got != tt.want {
+			t.Errorf("localRedirect(%q) = %q, want %q", tt.redirect, got, tt.want)
+		}
+	}
+}
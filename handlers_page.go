@@ -1,10 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
+
+	"github.com/mdbot/wiki/markdown"
 )
 
 type PageProvider interface {
@@ -39,6 +43,21 @@ func ViewPageHandler(t *Templates, renderer ContentRenderer, pp PageProvider) ht
 			return
 		}
 
+		// Follow redirects, unless we're looking at an old revision, the user has asked not to, or we've already
+		// been redirected (so that redirects can't loop).
+		redirectedFrom := r.FormValue("redirectedfrom")
+		if revision == "" && redirectedFrom == "" && r.FormValue("redirect") != "no" {
+			if target, ok := markdown.ParseRedirect(page.Content); ok {
+				target, anchor, _ := strings.Cut(target, "#")
+				location := fmt.Sprintf("/view/%s?redirectedfrom=%s", normaliseLinkTarget(target), url.QueryEscape(pageTitle))
+				if anchor != "" {
+					location += "#" + anchor
+				}
+				http.Redirect(w, r, location, http.StatusFound)
+				return
+			}
+		}
+
 		content, err := renderer.Render(page.Content)
 		if err != nil {
 			log.Printf("Failed to render markdown: %v\n", err)
@@ -49,7 +68,7 @@ func ViewPageHandler(t *Templates, renderer ContentRenderer, pp PageProvider) ht
 		t.RenderPage(w, r, pageTitle, content, &LastModifiedDetails{
 			User: page.LastModified.User,
 			Time: page.LastModified.Time,
-		})
+		}, redirectedFrom)
 	}
 }
 
@@ -57,33 +76,41 @@ func EditPageHandler(t *Templates, pp PageProvider) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		pageTitle := strings.TrimPrefix(r.URL.Path, "/edit/")
 
-		var content string
+		var content, base string
 		if page, err := pp.GetPage(pageTitle); err == nil {
 			content = string(page.Content)
+			base = page.LastModified.ChangeId
 		}
 
-		t.RenderEditPage(w, r, pageTitle, content)
+		t.RenderEditPage(w, r, pageTitle, content, base)
 	}
 }
 
 type PageEditor interface {
-	PutPage(title string, content []byte, user string, message string) error
+	PutPage(title string, base string, content []byte, user string, message string) error
 }
 
-func SubmitPageHandler(pe PageEditor) http.HandlerFunc {
+func SubmitPageHandler(t *Templates, pe PageEditor) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		pageTitle := strings.TrimPrefix(request.URL.Path, "/edit/")
 
 		content := request.FormValue("content")
 		message := request.FormValue("message")
+		base := request.FormValue("base")
 		username := "Anonymoose"
 		if user := getUserForRequest(request); user != nil {
 			username = user.Name
 		}
 
-		if err := pe.PutPage(pageTitle, []byte(content), username, message); err != nil {
-			// TODO: We should probably send an error to the client
+		if err := pe.PutPage(pageTitle, base, []byte(content), username, message); err != nil {
+			var conflict *EditConflictError
+			if errors.As(err, &conflict) {
+				t.RenderEditConflict(writer, request, pageTitle, conflict, message)
+				return
+			}
+
 			log.Printf("Error saving page: %v\n", err)
+			writer.WriteHeader(http.StatusInternalServerError)
 		} else {
 			writer.Header().Add("Location", fmt.Sprintf("/view/%s", pageTitle))
 			writer.WriteHeader(http.StatusSeeOther)
@@ -95,10 +122,10 @@ type DeletePageProvider interface {
 	DeletePage(name string, message string, user string) error
 }
 
-func DeletePageConfirmHandler(t *Templates) http.HandlerFunc {
+func DeletePageConfirmHandler(t *Templates, bp BacklinkProvider, pm *PermissionChecker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		name := strings.TrimPrefix(r.URL.Path, "/delete/")
-		t.RenderDeletePage(w, r, name)
+		t.RenderDeletePage(w, r, name, pm.FilterReadable(r, bp.LinksTo(name)))
 	}
 }
 
@@ -126,21 +153,21 @@ func DeletePageHandler(provider DeletePageProvider) http.HandlerFunc {
 }
 
 type RenamePageProvider interface {
-	RenamePage(name string, newName string, message string, user string) error
+	RenamePage(name string, newName string, rewrite []string, redirect bool, message string, user string) error
 }
 
-func RenamePageConfirmHandler(backend PageExists, t *Templates) http.HandlerFunc {
+func RenamePageConfirmHandler(backend PageExists, bp BacklinkProvider, pm *PermissionChecker, t *Templates) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		name := strings.TrimPrefix(r.URL.Path, "/rename/")
 		if !backend.PageExists(name) {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		t.RenderRenamePage(w, r, name)
+		t.RenderRenamePage(w, r, name, pm.FilterReadable(r, bp.LinksTo(name)))
 	}
 }
 
-func RenamePageHandler(provider RenamePageProvider) http.HandlerFunc {
+func RenamePageHandler(provider RenamePageProvider, bp BacklinkProvider, pm *PermissionChecker) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		name := strings.TrimPrefix(request.URL.Path, "/rename/")
 		newName := request.FormValue("newName")
@@ -148,13 +175,34 @@ func RenamePageHandler(provider RenamePageProvider) http.HandlerFunc {
 			http.Redirect(writer, request, "/rename/"+name, http.StatusSeeOther)
 			return
 		}
+		user := getUserForRequest(request)
+		if !pm.CanWritePath(user, newName) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("You don't have permission to create %s", newName))
+			http.Redirect(writer, request, "/rename/"+name, http.StatusSeeOther)
+			return
+		}
 		message := request.FormValue("message")
 		username := "Anonymoose"
-		if user := getUserForRequest(request); user != nil {
+		if user != nil {
 			username = user.Name
 		}
-		err := provider.RenamePage(name, newName, message, username)
-		if err != nil {
+		var rewrite []string
+		if request.FormValue("rewriteLinks") != "" {
+			// Only touch pages that the user could have edited themselves
+			for _, page := range bp.LinksTo(name) {
+				if pm.CanWritePath(user, page) {
+					rewrite = append(rewrite, page)
+				}
+			}
+		}
+		redirect := request.FormValue("leaveRedirect") != ""
+		err := provider.RenamePage(name, newName, rewrite, redirect, message, username)
+		if errors.Is(err, ErrPageExists) {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Page %s already exists", newName))
+			http.Redirect(writer, request, "/rename/"+name, http.StatusSeeOther)
+			return
+		} else if err != nil {
+			log.Printf("Unable to rename page %s to %s: %v", name, newName, err)
 			writer.WriteHeader(http.StatusInternalServerError)
 			return
 		}
@@ -213,7 +261,7 @@ type PageLister interface {
 	ListPages() ([]string, error)
 }
 
-func ListPagesHandler(t *Templates, pl PageLister) http.HandlerFunc {
+func ListPagesHandler(t *Templates, pl PageLister, pm *PermissionChecker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		pages, err := pl.ListPages()
 		if err != nil {
@@ -222,6 +270,6 @@ func ListPagesHandler(t *Templates, pl PageLister) http.HandlerFunc {
 			return
 		}
 
-		t.RenderPageList(w, r, pages)
+		t.RenderPageList(w, r, pm.FilterReadable(r, pages))
 	}
 }
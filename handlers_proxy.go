@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mdbot/wiki/config"
+)
+
+type ProxyProvisioner interface {
+	ProxyUser(username string, permissions config.Permission, groups []string) (*config.User, error)
+}
+
+// ProxyAuth identifies users from headers set by an authenticating reverse proxy. The headers are only trusted on
+// requests that come directly from one of the configured proxies, as anyone else could set them.
+type ProxyAuth struct {
+	provisioner  ProxyProvisioner
+	userHeader   string
+	groupsHeader string
+	trusted      []*net.IPNet
+	permissions  config.Permission
+}
+
+// NewProxyAuth creates a ProxyAuth that reads the username from userHeader and, if groupsHeader isn't empty, a comma
+// separated list of groups from groupsHeader. trustedProxies is a comma separated list of addresses or CIDR ranges,
+// and new users are given the named permissions.
+func NewProxyAuth(provisioner ProxyProvisioner, userHeader, groupsHeader, trustedProxies, permissions string) (*ProxyAuth, error) {
+	if userHeader == "" {
+		return nil, fmt.Errorf("no user header given")
+	}
+
+	permission, ok := permissionNames[strings.ToLower(strings.TrimSpace(permissions))]
+	if !ok {
+		return nil, fmt.Errorf("invalid permission %q", permissions)
+	}
+
+	trusted, err := parseCIDRs(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(trusted) == 0 {
+		return nil, fmt.Errorf("no trusted proxies given")
+	}
+
+	return &ProxyAuth{
+		provisioner:  provisioner,
+		userHeader:   userHeader,
+		groupsHeader: groupsHeader,
+		trusted:      trusted,
+		permissions:  permission,
+	}, nil
+}
+
+// Applies determines whether the request carries a username from a trusted proxy. Usernames from anywhere else are
+// ignored and logged, as they suggest someone is trying to bypass the proxy or it's been misconfigured.
+func (p *ProxyAuth) Applies(request *http.Request) bool {
+	if request.Header.Get(p.userHeader) == "" {
+		return false
+	}
+
+	if containsIP(p.trusted, peerIP(request)) {
+		return true
+	}
+
+	log.Printf("Ignoring %s header from untrusted address %s", p.userHeader, peerIP(request))
+	return false
+}
+
+// User returns the account for the user named by the proxy, creating it if necessary.
+func (p *ProxyAuth) User(request *http.Request) (*config.User, error) {
+	username := strings.TrimSpace(request.Header.Get(p.userHeader))
+
+	var groups []string
+	if p.groupsHeader != "" {
+		// An empty header means the user isn't in any groups, so groups must not be left nil
+		groups = []string{}
+		for _, value := range request.Header.Values(p.groupsHeader) {
+			for _, group := range strings.Split(value, ",") {
+				if group = strings.TrimSpace(group); group != "" {
+					groups = append(groups, group)
+				}
+			}
+		}
+	}
+
+	return p.provisioner.ProxyUser(username, p.permissions, groups)
+}
+
+// ForwardedFor finds the address of the client behind any trusted proxies, for requests made through them, from the
+// X-Forwarded-For header. Each proxy appends the address it received the request from, so the client is the last
+// untrusted address in the header. Other requests are left alone, as anyone could set the header.
+func ForwardedFor(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if ip := forwardedIP(request, trusted); ip != "" {
+				request = request.WithContext(context.WithValue(request.Context(), contextClientIPKey, ip))
+			}
+			next.ServeHTTP(writer, request)
+		})
+	}
+}
+
+// forwardedIP returns the client's address from the X-Forwarded-For header, or an empty string if the request didn't
+// come from a trusted proxy or the header is missing or invalid.
+func forwardedIP(request *http.Request, trusted []*net.IPNet) string {
+	if !containsIP(trusted, peerIP(request)) {
+		return ""
+	}
+
+	var addresses []string
+	for _, value := range request.Header.Values("X-Forwarded-For") {
+		addresses = append(addresses, strings.Split(value, ",")...)
+	}
+
+	var res string
+	for i := len(addresses) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(addresses[i]))
+		if ip == nil {
+			return ""
+		}
+
+		res = ip.String()
+		if !containsIP(trusted, res) {
+			break
+		}
+	}
+	return res
+}
+
+// containsIP determines whether the address is in any of the ranges.
+func containsIP(ranges []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	for i := range ranges {
+		if ip != nil && ranges[i].Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDRs parses a comma separated list of CIDR ranges. Plain addresses are treated as ranges containing only
+// that address.
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	var res []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q", entry)
+		}
+		res = append(res, network)
+	}
+	return res, nil
+}
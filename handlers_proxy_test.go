@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/mdbot/wiki/config"
+)
+
+// testProxyProvisioner creates users without storing them, and records the groups it was given.
+type testProxyProvisioner struct {
+	groups []string
+}
+
+func (p *testProxyProvisioner) ProxyUser(username string, permissions config.Permission, groups []string) (*config.User, error) {
+	if username == "admin" {
+		return nil, errors.New("an account named admin already exists")
+	}
+
+	p.groups = groups
+	return &config.User{Name: username, Permissions: permissions}, nil
+}
+
+func (p *testProxyProvisioner) User(string) *config.User {
+	return nil
+}
+
+func (p *testProxyProvisioner) AuthenticateToken(string) (*config.User, error) {
+	return nil, errors.New("invalid token")
+}
+
+func TestSessionHandler_Proxy(t *testing.T) {
+	provisioner := &testProxyProvisioner{}
+	proxy, err := NewProxyAuth(provisioner, "X-Forwarded-User", "X-Forwarded-Groups", "10.0.0.0/8, 192.0.2.1", "write")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker, err := OpenSessionTracker(filepath.Join(t.TempDir(), "sessions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.Use(SessionHandler(provisioner, sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef")), tracker, proxy))
+	router.Path("/whoami").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := getUserForRequest(r); user != nil {
+			_, _ = io.WriteString(w, user.Name+":"+user.Permissions.String())
+		}
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		user       string
+		groups     []string
+		wantStatus int
+		wantBody   string
+		wantGroups []string
+	}{
+		{"trusted range", "10.1.2.3:1234", "alice", nil, http.StatusOK, "alice:write", []string{}},
+		{"trusted address", "192.0.2.1:1234", "alice", nil, http.StatusOK, "alice:write", []string{}},
+		{"untrusted address", "192.0.2.2:1234", "alice", nil, http.StatusOK, "", nil},
+		{"no header", "10.1.2.3:1234", "", nil, http.StatusOK, "", nil},
+		{"groups", "10.1.2.3:1234", "bob", []string{"editors, staff", "admins"}, http.StatusOK, "bob:write", []string{"editors", "staff", "admins"}},
+		{"existing account", "10.1.2.3:1234", "admin", nil, http.StatusForbidden, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provisioner.groups = nil
+
+			req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.user != "" {
+				req.Header.Set("X-Forwarded-User", tt.user)
+			}
+			for i := range tt.groups {
+				req.Header.Add("X-Forwarded-Groups", tt.groups[i])
+			}
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && rec.Body.String() != tt.wantBody {
+				t.Errorf("Body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if strings.Join(provisioner.groups, ",") != strings.Join(tt.wantGroups, ",") || (provisioner.groups == nil) != (tt.wantGroups == nil) {
+				t.Errorf("Groups = %#v, want %#v", provisioner.groups, tt.wantGroups)
+			}
+		})
+	}
+}
+
+func TestNewProxyAuth(t *testing.T) {
+	tests := []struct {
+		name        string
+		userHeader  string
+		trusted     string
+		permissions string
+		wantErr     bool
+	}{
+		{"valid", "X-Forwarded-User", "10.0.0.0/8,::1", "read", false},
+		{"no header", "", "10.0.0.0/8", "read", true},
+		{"no proxies", "X-Forwarded-User", "", "read", true},
+		{"invalid range", "X-Forwarded-User", "10.0.0.0/33", "read", true},
+		{"invalid address", "X-Forwarded-User", "proxy.local", "read", true},
+		{"invalid permissions", "X-Forwarded-User", "10.0.0.0/8", "everything", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewProxyAuth(&testProxyProvisioner{}, tt.userHeader, "", tt.trusted, tt.permissions)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewProxyAuth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	trusted, err := parseCIDRs("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	handler := ForwardedFor(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = clientIP(r)
+	}))
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string
+		want      string
+	}{
+		{"no proxy", "198.51.100.1:1234", nil, "198.51.100.1"},
+		{"untrusted proxy", "198.51.100.1:1234", []string{"203.0.113.1"}, "198.51.100.1"},
+		{"trusted proxy", "192.0.2.1:1234", []string{"203.0.113.1"}, "203.0.113.1"},
+		{"trusted proxy without header", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"chain of proxies", "10.0.0.1:1234", []string{"203.0.113.9, 203.0.113.1, 10.0.0.2"}, "203.0.113.1"},
+		{"multiple headers", "10.0.0.1:1234", []string{"203.0.113.9", "203.0.113.1"}, "203.0.113.1"},
+		{"only proxies", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"invalid address", "10.0.0.1:1234", []string{"203.0.113.1, nonsense"}, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.peer
+			for i := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", tt.forwarded[i])
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
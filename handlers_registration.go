@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdbot/wiki/config"
+)
+
+type RegistrationProvider interface {
+	RegistrationPolicy() config.RegistrationPolicy
+	PendingUsers() []*config.PendingUser
+	Invites() []*config.Invite
+}
+
+func RegistrationHandler(t *Templates, rp RegistrationProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t.RenderRegistration(w, r, rp.RegistrationPolicy(), rp.PendingUsers(), rp.Invites(), "")
+	}
+}
+
+type RegistrationManager interface {
+	RegistrationProvider
+	SetRegistrationPolicy(policy config.RegistrationPolicy, responsible string) error
+	ApproveUser(username, responsible string) error
+	RejectUser(username, responsible string) error
+	CreateInvite(permissions config.Permission, validFor time.Duration, responsible string) (string, error)
+	RevokeInvite(id, responsible string) error
+}
+
+// ModifyRegistrationHandler changes the registration policy, approves or rejects pending users, and creates or revokes
+// invites. Links for new invites use baseUrl if it's set, or otherwise the host the request was made to.
+func ModifyRegistrationHandler(t *Templates, rm RegistrationManager, baseUrl string) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseForm(); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		switch request.PostForm.Get("action") {
+		case "policy":
+			perm, ok := permissionNames[request.PostForm.Get("permissions")]
+			if !ok {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			err := rm.SetRegistrationPolicy(config.RegistrationPolicy{
+				Open:        request.PostForm.Get("open") == "true",
+				Permissions: perm,
+				Approval:    request.PostForm.Get("approval") == "true",
+			}, username)
+			if err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to update registration settings: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditConfigRegistration, Detail: fmt.Sprintf("open: %t, permissions: %s, approval: %t", request.PostForm.Get("open") == "true", perm, request.PostForm.Get("approval") == "true")})
+				putSessionKey(writer, request, sessionNoticeKey, "Registration settings updated")
+			}
+		case "approve":
+			user := request.PostForm.Get("user")
+			if err := rm.ApproveUser(user, username); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to approve user: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditUserApprove, Target: user})
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("User %s has been approved", user))
+			}
+		case "reject":
+			user := request.PostForm.Get("user")
+			if err := rm.RejectUser(user, username); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to reject user: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditUserReject, Target: user})
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("User %s has been rejected", user))
+			}
+		case "invite":
+			perm, ok := permissionNames[request.PostForm.Get("permissions")]
+			days, err := strconv.Atoi(request.PostForm.Get("days"))
+			if !ok || err != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			token, err := rm.CreateInvite(perm, time.Duration(days)*24*time.Hour, username)
+			if err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to create invite: %v", err))
+				break
+			}
+
+			audit(request, AuditEvent{Action: auditInviteCreate, Detail: fmt.Sprintf("%s for %d days", perm, days)})
+
+			// Show the link straight away rather than redirecting, so it's never stored in the session
+			t.RenderRegistration(writer, request, rm.RegistrationPolicy(), rm.PendingUsers(), rm.Invites(), inviteUrl(request, baseUrl, token))
+			return
+		case "revokeinvite":
+			if err := rm.RevokeInvite(request.PostForm.Get("invite"), username); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to revoke invite: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditInviteRevoke, Target: request.PostForm.Get("invite")})
+				putSessionKey(writer, request, sessionNoticeKey, "Invite revoked")
+			}
+		default:
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		writer.Header().Set("location", "/wiki/registration")
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
+
+func inviteUrl(request *http.Request, baseUrl, token string) string {
+	if baseUrl == "" {
+		scheme := "http"
+		if request.TLS != nil {
+			scheme = "https"
+		}
+		baseUrl = scheme + "://" + request.Host
+	}
+	return strings.TrimSuffix(baseUrl, "/") + "/wiki/signup?invite=" + url.QueryEscape(token)
+}
+
+type Registrar interface {
+	RegistrationPolicy() config.RegistrationPolicy
+	Invite(token string) (*config.Invite, error)
+	RedeemInvite(token, username, password, email string) (*config.User, error)
+	Register(username, password, email string) (*config.User, error)
+}
+
+func SignupFormHandler(t *Templates, reg Registrar) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		// Don't leak invites to other sites
+		writer.Header().Set("Referrer-Policy", "no-referrer")
+
+		token := request.FormValue("invite")
+		if token == "" {
+			if !reg.RegistrationPolicy().Open {
+				writer.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			t.RenderSignup(writer, request, "", "")
+			return
+		}
+
+		invite, err := reg.Invite(token)
+		if err != nil {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to sign up: %v", err))
+			writer.Header().Set("location", "/")
+			writer.WriteHeader(http.StatusSeeOther)
+			return
+		}
+
+		t.RenderSignup(writer, request, token, invite.Permissions.String())
+	}
+}
+
+type SignupThrottle interface {
+	Attempt(ip string) error
+}
+
+// SignupHandler creates an account, either from an invite or through open registration, and logs the new user in
+// unless their account needs to be approved first. Open registrations from each address are limited by the throttle.
+func SignupHandler(reg Registrar, throttle SignupThrottle) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		token := request.FormValue("invite")
+		username := request.FormValue("username")
+		password1 := request.FormValue("password1")
+		password2 := request.FormValue("password2")
+		email := request.FormValue("email")
+
+		var user *config.User
+		var err error
+		if password1 != password2 {
+			err = errors.New("passwords didn't match")
+		} else if token != "" {
+			user, err = reg.RedeemInvite(token, username, password1, email)
+		} else if throttle.Attempt(clientIP(request)) != nil {
+			log.Printf("Refused registration for user %s from %s after too many attempts", username, clientIP(request))
+			err = errors.New("too many accounts have been registered from your address, please try again later")
+		} else {
+			user, err = reg.Register(username, password1, email)
+		}
+
+		if err != nil {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to sign up: %v", err))
+			location := "/wiki/signup"
+			if token != "" {
+				location += "?invite=" + url.QueryEscape(token)
+			}
+			writer.Header().Set("location", location)
+			writer.WriteHeader(http.StatusSeeOther)
+			return
+		}
+
+		if user == nil {
+			log.Printf("User %s registered from %s and is awaiting approval", username, clientIP(request))
+			audit(request, AuditEvent{User: username, Action: auditUserCreate, Target: username, Detail: "registered, awaiting approval"})
+			putSessionKey(writer, request, sessionNoticeKey, "Your account has been created, and can be used once an administrator has approved it")
+		} else {
+			log.Printf("User %s signed up from %s", user.Name, clientIP(request))
+			audit(request, AuditEvent{User: user.Name, Action: auditUserCreate, Target: user.Name, Detail: "signed up"})
+			startSession(writer, request, user)
+			putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Welcome, %s! Your account has been created", user.Name))
+		}
+
+		writer.Header().Set("location", "/")
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
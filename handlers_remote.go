@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type SyncStatusProvider interface {
+	Status() SyncStatus
+}
+
+func RemoteSyncStatusHandler(t *Templates, sp SyncStatusProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t.RenderRemoteSync(w, r, sp.Status())
+	}
+}
+
+type Syncer interface {
+	Sync() error
+}
+
+func RemoteSyncHandler(s Syncer) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := s.Sync(); err != nil {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to sync with remote: %v", err))
+		} else {
+			putSessionKey(writer, request, sessionNoticeKey, "Synchronised with remote")
+		}
+
+		writer.Header().Add("location", "/wiki/remote")
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
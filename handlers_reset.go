@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/mdbot/wiki/config"
+)
+
+type ResetMailer interface {
+	Available() bool
+	SendReset(user *config.User, token string)
+}
+
+func ForgotPasswordHandler(t *Templates, rm ResetMailer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t.RenderForgotPassword(w, r, rm.Available())
+	}
+}
+
+type PasswordResetter interface {
+	BeginPasswordReset(nameOrEmail string) (*config.User, string, error)
+	PasswordResetUser(token string) (*config.User, error)
+	ResetPassword(token, password string) (*config.User, error)
+}
+
+// RequestPasswordResetHandler emails a password reset link to the account with the given username or email address.
+// The response is the same whether or not a link was sent, so it can't be used to find out which accounts exist.
+func RequestPasswordResetHandler(pr PasswordResetter, rm ResetMailer) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !rm.Available() {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		account := request.FormValue("account")
+		if user, token, err := pr.BeginPasswordReset(account); err != nil {
+			log.Printf("Not sending password reset link for %q requested from %s: %v", account, clientIP(request), err)
+		} else {
+			rm.SendReset(user, token)
+		}
+
+		putSessionKey(writer, request, sessionNoticeKey, "If that account has an email address, a link to reset its password has been sent to it")
+		writer.Header().Set("location", "/")
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
+
+func ResetPasswordFormHandler(t *Templates, pr PasswordResetter) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		// Don't leak the token to other sites
+		writer.Header().Set("Referrer-Policy", "no-referrer")
+
+		token := request.FormValue("token")
+		user, err := pr.PasswordResetUser(token)
+		if err != nil {
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to reset password: %v", err))
+			writer.Header().Set("location", "/wiki/forgot")
+			writer.WriteHeader(http.StatusSeeOther)
+			return
+		}
+
+		t.RenderResetPassword(writer, request, user.Name, token)
+	}
+}
+
+// ResetPasswordHandler sets a new password using a password reset token, and logs the account out everywhere. Users
+// have to log in afterwards, so that two-factor authentication still applies.
+func ResetPasswordHandler(pr PasswordResetter, sm SessionManager) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		token := request.FormValue("token")
+		password1 := request.FormValue("password1")
+		password2 := request.FormValue("password2")
+
+		var user *config.User
+		var err error
+		if password1 != password2 {
+			err = errors.New("new passwords didn't match")
+		} else {
+			user, err = pr.ResetPassword(token, password1)
+		}
+
+		if err != nil {
+			// Let the user try again, unless the token is no longer valid in which case they'll be sent elsewhere
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to reset password: %v", err))
+			writer.Header().Set("location", "/wiki/reset?token="+url.QueryEscape(token))
+			writer.WriteHeader(http.StatusSeeOther)
+			return
+		}
+
+		log.Printf("Password reset for user %s from %s", user.Name, clientIP(request))
+		audit(request, AuditEvent{User: user.Name, Action: auditPasswordReset, Target: user.Name})
+		if _, err := sm.RevokeAll(user.Name, ""); err != nil {
+			log.Printf("Unable to revoke sessions for user %s: %v", user.Name, err)
+		}
+
+		putSessionKey(writer, request, sessionNoticeKey, "Your password has been reset, you can now log in with it")
+		writer.Header().Set("location", "/")
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
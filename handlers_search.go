@@ -2,19 +2,28 @@ package main
 
 import (
 	"net/http"
+
+	"github.com/mdbot/wiki/search"
 )
 
-type SearchRequest interface {
-	SearchWiki(pattern string) []SearchResult
+// maxSearchResults is the maximum number of results shown for a search.
+const maxSearchResults = 50
+
+type Searcher interface {
+	Search(query string, limit int, filter func(name string) bool) ([]search.Result, int)
 }
 
-func SearchHandler(templates *Templates, backend SearchRequest) http.HandlerFunc {
+func SearchHandler(templates *Templates, searcher Searcher, pm *PermissionChecker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		pattern := r.FormValue("pattern")
-		var results []SearchResult
+		var results []search.Result
+		var total int
 		if pattern != "" {
-			results = backend.SearchWiki(pattern)
+			user := getUserForRequest(r)
+			results, total = searcher.Search(pattern, maxSearchResults, func(name string) bool {
+				return pm.CanReadPath(user, name)
+			})
 		}
-		templates.RenderSearch(w, r, pattern, results)
+		templates.RenderSearch(w, r, pattern, results, total)
 	}
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/mdbot/wiki/config"
+)
+
+type TwoFactorPolicy interface {
+	TwoFactorRequired(user *config.User) bool
+}
+
+// RequireTwoFactorEnrolment stops users who are required to use two-factor authentication from doing anything other
+// than setting it up on their account page (or logging out). API tokens are unaffected, as they can only be created
+// by a user who has logged in.
+func RequireTwoFactorEnrolment(policy TwoFactorPolicy) func(http.Handler) http.Handler {
+	allowed := func(r *http.Request) bool {
+		return r.URL.Path == "/wiki/account" ||
+			r.URL.Path == "/wiki/logout" ||
+			strings.HasPrefix(r.URL.Path, "/static/") ||
+			strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := getUserForRequest(r)
+			if user == nil || allowed(r) || !policy.TwoFactorRequired(user) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			log.Printf("User %s must set up two-factor authentication before accessing %s", user.Name, r.URL)
+			if r.Method != http.MethodGet {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			putSessionKey(w, r, sessionErrorKey, "You must set up two-factor authentication before you can continue")
+			w.Header().Set("location", "/wiki/account")
+			w.WriteHeader(http.StatusSeeOther)
+		})
+	}
+}
+
+type SecurityProvider interface {
+	TwoFactorMandatory() bool
+}
+
+type LockedAccountProvider interface {
+	LockedAccounts() []LockedAccount
+}
+
+func SecurityHandler(t *Templates, sp SecurityProvider, lp LockedAccountProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t.RenderSecurity(w, r, sp.TwoFactorMandatory(), lp.LockedAccounts())
+	}
+}
+
+type SecurityUpdater interface {
+	SetRequireTwoFactor(required bool, responsible string) error
+}
+
+type AccountUnlocker interface {
+	Unlock(username string)
+}
+
+func UpdateSecurityHandler(su SecurityUpdater, au AccountUnlocker) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseForm(); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		switch request.PostForm.Get("action") {
+		case "twofactor":
+			required := request.PostForm.Get("required") == "true"
+			if err := su.SetRequireTwoFactor(required, username); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to update security settings: %v", err))
+			} else if required {
+				audit(request, AuditEvent{Action: auditConfigSecurity, Detail: "two-factor authentication required"})
+				putSessionKey(writer, request, sessionNoticeKey, "Users who can edit the wiki must now use two-factor authentication")
+			} else {
+				audit(request, AuditEvent{Action: auditConfigSecurity, Detail: "two-factor authentication optional"})
+				putSessionKey(writer, request, sessionNoticeKey, "Two-factor authentication is now optional")
+			}
+		case "unlock":
+			account := request.PostForm.Get("user")
+			au.Unlock(account)
+			log.Printf("User %s unlocked account %s", username, account)
+			audit(request, AuditEvent{Action: auditUserUnlock, Target: account})
+			putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Account %s has been unlocked", account))
+		default:
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		writer.Header().Set("location", "/wiki/security")
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
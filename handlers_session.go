@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/gorilla/csrf"
 	"github.com/gorilla/sessions"
 	"github.com/mdbot/wiki/config"
 )
@@ -16,28 +19,81 @@ const (
 	sessionSessionKey = "session"
 	sessionNoticeKey  = "notice"
 	sessionErrorKey   = "error"
-
-	contextUserKey    = "user"
-	contextErrorKey   = "error"
-	contextNoticeKey  = "notice"
-	contextSessionKey = "session"
+	// sessionSingleSignOnKey records that the user logged in through an identity provider, so they can be logged out
+	// of it too.
+	sessionSingleSignOnKey = "sso"
+	// sessionIdTokenKey held the user's whole ID token in older versions, and is only cleared now.
+	sessionIdTokenKey = "idtoken"
+	// sessionSecretKey identifies the session to the SessionRecorder, so that it can be revoked.
+	sessionSecretKey = "secret"
+	// sessionPendingUserKey and sessionPendingTimeKey record a login that is waiting for a one-time password.
+	sessionPendingUserKey = "pendinguser"
+	sessionPendingTimeKey = "pendingtime"
+
+	contextUserKey     = "user"
+	contextErrorKey    = "error"
+	contextNoticeKey   = "notice"
+	contextSessionKey  = "session"
+	contextRecorderKey = "recorder"
+	contextAuditKey    = "audit"
+	contextTokenKey    = "token"
+	contextClientIPKey = "clientip"
 
 	sessionKeyFormat = "wiki:%x"
+
+	// pendingLoginTimeout is how long users have to enter their one-time password after their password.
+	pendingLoginTimeout = 5 * time.Minute
 )
 
 type UserProvider interface {
 	User(string) *config.User
+	AuthenticateToken(token string) (*config.User, error)
+}
+
+// SessionRecorder keeps track of logged in sessions, so that they can be listed and revoked.
+type SessionRecorder interface {
+	Start(username, ip, userAgent string) (string, error)
+	Touch(secret, username, ip string) bool
+	End(secret string)
 }
 
-func SessionHandler(up UserProvider, store sessions.Store) func(http.Handler) http.Handler {
+// SessionHandler identifies the user making the request, either from their session or from an API token in the
+// Authorization header. It must run before CSRF protection, which is skipped for requests authenticated by a token
+// (as browsers never add the header automatically). Sessions are only accepted while sr still knows about them. If
+// proxy is non-nil, users named by a trusted reverse proxy take precedence over both.
+func SessionHandler(up UserProvider, store sessions.Store, sr SessionRecorder, proxy *ProxyAuth) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 			s, _ := store.Get(request, sessionName)
 
-			if username, ok := s.Values[sessionUserKey]; ok {
+			if proxy != nil && proxy.Applies(request) {
+				user, err := proxy.User(request)
+				if err != nil {
+					log.Printf("Failed reverse proxy authentication for %s: %v", request.URL, err)
+					http.Error(writer, "Unable to authenticate", http.StatusForbidden)
+					return
+				}
+
+				request = request.WithContext(context.WithValue(request.Context(), contextUserKey, user))
+			} else if token, ok := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer "); ok {
+				user, err := up.AuthenticateToken(strings.TrimSpace(token))
+				if err != nil {
+					log.Printf("Failed token authentication for %s: %v", request.URL, err)
+					audit(request, AuditEvent{Action: auditLoginFailed, Detail: "api token: " + err.Error()})
+					writer.Header().Set("WWW-Authenticate", `Bearer realm="wiki"`)
+					http.Error(writer, "Invalid token", http.StatusUnauthorized)
+					return
+				}
+
+				request = csrf.UnsafeSkipCheck(request)
+				request = request.WithContext(context.WithValue(request.Context(), contextUserKey, user))
+				request = request.WithContext(context.WithValue(request.Context(), contextTokenKey, true))
+			} else if username, ok := s.Values[sessionUserKey]; ok {
 				user := up.User(username.(string))
 				if user != nil {
-					if key := s.Values[sessionSessionKey]; fmt.Sprintf(sessionKeyFormat, user.SessionKey) == key {
+					key := s.Values[sessionSessionKey]
+					secret, _ := s.Values[sessionSecretKey].(string)
+					if fmt.Sprintf(sessionKeyFormat, user.SessionKey) == key && sr.Touch(secret, user.Name, clientIP(request)) {
 						request = request.WithContext(context.WithValue(request.Context(), contextUserKey, user))
 					}
 				}
@@ -52,12 +108,30 @@ func SessionHandler(up UserProvider, store sessions.Store) func(http.Handler) ht
 			}
 
 			request = request.WithContext(context.WithValue(request.Context(), contextSessionKey, s))
+			request = request.WithContext(context.WithValue(request.Context(), contextRecorderKey, sr))
 
 			next.ServeHTTP(writer, request)
 		})
 	}
 }
 
+// RequireSession only allows requests from users who have logged in, rather than those using an API token. Tokens
+// can't manage the account they belong to, so that a leaked or limited token can't be used to take it over.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := getUserForRequest(r)
+		if user == nil {
+			log.Printf("Anonymous user tried to access account-protected resource %s", r.URL)
+			w.WriteHeader(http.StatusUnauthorized)
+		} else if authenticatedByToken(r) {
+			log.Printf("User %s tried to access %s with an API token", user.Name, r.URL)
+			w.WriteHeader(http.StatusForbidden)
+		} else {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
 func putSessionKey(w http.ResponseWriter, r *http.Request, key string, value interface{}) {
 	if s := getSessionForRequest(r); s != nil {
 		s.Values[key] = value
@@ -74,6 +148,55 @@ func putSessionKey(w http.ResponseWriter, r *http.Request, key string, value int
 	}
 }
 
+// startSession logs the user in for the rest of the session.
+func startSession(w http.ResponseWriter, r *http.Request, user *config.User) {
+	sr, _ := r.Context().Value(contextRecorderKey).(SessionRecorder)
+	if sr == nil {
+		log.Printf("No session recorder for request, unable to log in user %s", user.Name)
+		return
+	}
+
+	secret, err := sr.Start(user.Name, clientIP(r), r.UserAgent())
+	if err != nil {
+		log.Printf("Unable to record session for user %s: %v", user.Name, err)
+		if secret == "" {
+			putSessionKey(w, r, sessionErrorKey, "Unable to start session, please try again")
+			return
+		}
+	}
+
+	putSessionKey(w, r, sessionUserKey, user.Name)
+	putSessionKey(w, r, sessionSessionKey, fmt.Sprintf(sessionKeyFormat, user.SessionKey))
+	putSessionKey(w, r, sessionSecretKey, secret)
+}
+
+// endSession forgets the session's record, so it can't be used again.
+func endSession(w http.ResponseWriter, r *http.Request) {
+	sr, _ := r.Context().Value(contextRecorderKey).(SessionRecorder)
+	if s := getSessionForRequest(r); s != nil && sr != nil {
+		if secret, ok := s.Values[sessionSecretKey].(string); ok {
+			sr.End(secret)
+		}
+	}
+	clearSessionKey(w, r, sessionSecretKey)
+}
+
+// currentSessionID returns the ID of the session making the request, or an empty string if it isn't logged in.
+func currentSessionID(r *http.Request) string {
+	if s := getSessionForRequest(r); s != nil {
+		if secret, ok := s.Values[sessionSecretKey].(string); ok && secret != "" {
+			return sessionID(secret)
+		}
+	}
+	return ""
+}
+
+// authenticatedByToken determines whether the user making the request was identified by an API token.
+func authenticatedByToken(r *http.Request) bool {
+	v, _ := r.Context().Value(contextTokenKey).(bool)
+	return v
+}
+
 func getUserForRequest(r *http.Request) *config.User {
 	v, _ := r.Context().Value(contextUserKey).(*config.User)
 	return v
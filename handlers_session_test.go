@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/mdbot/wiki/config"
+)
+
+// testTokenProvider authenticates a single API token.
+type testTokenProvider struct {
+	token string
+	user  *config.User
+}
+
+func (p *testTokenProvider) User(string) *config.User {
+	return nil
+}
+
+func (p *testTokenProvider) AuthenticateToken(token string) (*config.User, error) {
+	if token == p.token {
+		return p.user, nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+func TestSessionHandler_Tokens(t *testing.T) {
+	provider := &testTokenProvider{token: "wiki_secret", user: &config.User{Name: "bot"}}
+	store := sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef"))
+
+	tracker, err := OpenSessionTracker(filepath.Join(t.TempDir(), "sessions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &testAuditRecorder{}
+	router := mux.NewRouter()
+	router.Use(RecordAuditEvents(recorder))
+	router.Use(SessionHandler(provider, store, tracker, nil))
+	router.Use(csrf.Protect([]byte("0123456789abcdef0123456789abcdef")))
+	router.Path("/edit").Methods(http.MethodPost).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := getUserForRequest(r); user != nil {
+			_, _ = io.WriteString(w, user.Name)
+		}
+	})
+	router.Path("/account").Methods(http.MethodPost).Handler(RequireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "changed")
+	})))
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		wantStatus    int
+		wantBody      string
+	}{
+		{"no token is subject to CSRF checks", "/edit", "", http.StatusForbidden, ""},
+		{"valid token skips CSRF checks", "/edit", "Bearer wiki_secret", http.StatusOK, "bot"},
+		{"invalid token is rejected", "/edit", "Bearer wiki_wrong", http.StatusUnauthorized, ""},
+		{"token can't manage the account", "/account", "Bearer wiki_secret", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("Body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+
+	if len(recorder.events) != 1 || recorder.events[0].Action != auditLoginFailed {
+		t.Errorf("recorded %+v, want the invalid token", recorder.events)
+	}
+}
+
+// testSessionUsers looks up users by name, and doesn't accept tokens.
+type testSessionUsers map[string]*config.User
+
+func (p testSessionUsers) User(name string) *config.User {
+	return p[name]
+}
+
+func (p testSessionUsers) AuthenticateToken(string) (*config.User, error) {
+	return nil, errors.New("invalid token")
+}
+
+func TestSessionHandler_Revoked(t *testing.T) {
+	users := testSessionUsers{"alice": &config.User{Name: "alice", SessionKey: []byte("key")}}
+	store := sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef"))
+	tracker, err := OpenSessionTracker(filepath.Join(t.TempDir(), "sessions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.Use(SessionHandler(users, store, tracker, nil))
+	router.Path("/login").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startSession(w, r, users["alice"])
+	})
+	router.Path("/logout").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endSession(w, r)
+	})
+	router.Path("/whoami").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := getUserForRequest(r); user != nil {
+			_, _ = io.WriteString(w, user.Name)
+		}
+	})
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	newClient := func() *http.Client {
+		jar, _ := cookiejar.New(nil)
+		client := &http.Client{Jar: jar}
+		if _, err := client.Get(server.URL + "/login"); err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	whoami := func(client *http.Client) string {
+		res, err := client.Get(server.URL + "/whoami")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return string(b)
+	}
+
+	first := newClient()
+	second := newClient()
+	if got := whoami(first); got != "alice" {
+		t.Fatalf("whoami = %q after logging in, want alice", got)
+	}
+	if got := len(tracker.Sessions("alice")); got != 2 {
+		t.Fatalf("Sessions() returned %d sessions, want 2", got)
+	}
+
+	if _, err := first.Get(server.URL + "/logout"); err != nil {
+		t.Fatal(err)
+	}
+	if got := whoami(first); got != "" {
+		t.Errorf("whoami = %q after logging out, want nobody", got)
+	}
+	if got := whoami(second); got != "alice" {
+		t.Errorf("whoami = %q in another session after logging out, want alice", got)
+	}
+
+	if _, err := tracker.RevokeAll("alice", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := whoami(second); got != "" {
+		t.Errorf("whoami = %q after revoking all sessions, want nobody", got)
+	}
+}
@@ -74,6 +74,7 @@ func UpdateSiteConfigHandler(updater SiteUpdater) http.HandlerFunc {
 			return
 		}
 
+		audit(request, AuditEvent{Action: auditConfigSite, Detail: siteName})
 		writer.Header().Add("location", "/wiki/site")
 		writer.WriteHeader(http.StatusSeeOther)
 	}
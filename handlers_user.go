@@ -2,9 +2,12 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/mdbot/wiki/config"
 )
@@ -13,83 +16,226 @@ type Authenticator interface {
 	Authenticate(username, password string) (*config.User, error)
 }
 
-func LoginHandler(auth Authenticator) http.HandlerFunc {
+// LoginThrottle limits how often logins can be attempted after failures.
+type LoginThrottle interface {
+	Check(ip, username string) error
+	Failed(ip, username string)
+	Succeeded(ip, username string)
+}
+
+func LoginHandler(auth Authenticator, throttle LoginThrottle) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		username := request.FormValue("username")
 		password := request.FormValue("password")
-		redirect := request.FormValue("redirect")
+		redirect := localRedirect(request.FormValue("redirect"), "/")
 
-		// Only allow relative redirects
-		if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
-			redirect = "/"
+		ip := clientIP(request)
+		if err := throttle.Check(ip, username); err != nil {
+			log.Printf("Refused login for user %s from %s: %v", username, ip, err)
+			audit(request, AuditEvent{User: username, Action: auditLoginFailed, Detail: err.Error()})
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Failed to login: %v", err))
+			writer.Header().Set("location", redirect)
+			writer.WriteHeader(http.StatusSeeOther)
+			return
 		}
 
 		user, err := auth.Authenticate(username, password)
 		if err != nil {
+			throttle.Failed(ip, username)
+			audit(request, AuditEvent{User: username, Action: auditLoginFailed, Detail: err.Error()})
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Failed to login: %v", err))
+		} else if user.TwoFactorEnabled() {
+			// Remember who has provided their password, and ask for their one-time password
+			putSessionKey(writer, request, sessionPendingUserKey, user.Name)
+			putSessionKey(writer, request, sessionPendingTimeKey, time.Now().Unix())
+			redirect = "/wiki/login/totp?redirect=" + url.QueryEscape(redirect)
+		} else {
+			throttle.Succeeded(ip, username)
+			audit(request, AuditEvent{User: user.Name, Action: auditLogin, Detail: "password"})
+			startSession(writer, request, user)
+		}
+		writer.Header().Set("location", redirect)
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
+
+// pendingLogin returns the name of the user who has provided their password but not yet their one-time password,
+// if they did so recently enough.
+func pendingLogin(request *http.Request) (string, bool) {
+	s := getSessionForRequest(request)
+	if s == nil {
+		return "", false
+	}
+
+	username, ok := s.Values[sessionPendingUserKey].(string)
+	started, _ := s.Values[sessionPendingTimeKey].(int64)
+	if !ok || time.Since(time.Unix(started, 0)) > pendingLoginTimeout {
+		return "", false
+	}
+	return username, true
+}
+
+func TotpLoginFormHandler(t *Templates) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if _, ok := pendingLogin(request); !ok {
+			putSessionKey(writer, request, sessionErrorKey, "Your login has expired, please try again")
+			writer.Header().Set("location", "/")
+			writer.WriteHeader(http.StatusSeeOther)
+			return
+		}
+
+		t.RenderTotpLogin(writer, request, request.FormValue("redirect"))
+	}
+}
+
+type SecondFactorAuthenticator interface {
+	AuthenticateSecondFactor(username, code string) (*config.User, error)
+}
+
+// TotpLoginHandler completes the login of a user with two-factor authentication enabled, once they've provided a
+// valid one-time password or recovery code.
+func TotpLoginHandler(auth SecondFactorAuthenticator, throttle LoginThrottle) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		redirect := localRedirect(request.FormValue("redirect"), "/")
+
+		ip := clientIP(request)
+		username, ok := pendingLogin(request)
+		if !ok {
+			putSessionKey(writer, request, sessionErrorKey, "Your login has expired, please try again")
+		} else if err := throttle.Check(ip, username); err != nil {
+			log.Printf("Refused two-factor authentication for user %s from %s: %v", username, ip, err)
+			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Failed to login: %v", err))
+			redirect = "/wiki/login/totp?redirect=" + url.QueryEscape(redirect)
+		} else if user, err := auth.AuthenticateSecondFactor(username, request.FormValue("code")); err != nil {
+			log.Printf("Failed two-factor authentication for user %s: %v", username, err)
+			throttle.Failed(ip, username)
+			audit(request, AuditEvent{User: username, Action: auditLoginFailed, Detail: "two-factor authentication: " + err.Error()})
 			putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Failed to login: %v", err))
+			redirect = "/wiki/login/totp?redirect=" + url.QueryEscape(redirect)
 		} else {
-			putSessionKey(writer, request, sessionUserKey, user.Name)
-			putSessionKey(writer, request, sessionSessionKey, fmt.Sprintf(sessionKeyFormat, user.SessionKey))
+			throttle.Succeeded(ip, username)
+			audit(request, AuditEvent{User: user.Name, Action: auditLogin, Detail: "password and two-factor authentication"})
+			clearSessionKey(writer, request, sessionPendingUserKey)
+			clearSessionKey(writer, request, sessionPendingTimeKey)
+			startSession(writer, request, user)
+			if user.RecoveryCodesLeft() < 3 {
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("You have %d recovery codes left. You can generate more on your account page.", user.RecoveryCodesLeft()))
+			}
 		}
+
 		writer.Header().Set("location", redirect)
 		writer.WriteHeader(http.StatusSeeOther)
 	}
 }
 
-func LogoutHandler() http.HandlerFunc {
+type SessionEnder interface {
+	EndSessionURL() string
+}
+
+// LogoutHandler ends the user's session. If the user logged in through single sign-on and se is non-nil, they are
+// sent to the identity provider to log out there too.
+func LogoutHandler(se SessionEnder) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
-		redirect := request.FormValue("redirect")
+		redirect := localRedirect(request.FormValue("redirect"), "/")
+
+		if s := getSessionForRequest(request); s != nil && se != nil {
+			if sso, _ := s.Values[sessionSingleSignOnKey].(bool); sso {
+				if endSession := se.EndSessionURL(); endSession != "" {
+					redirect = endSession
+				}
+			}
+		}
 
-		// Only allow relative redirects
-		if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
-			redirect = "/"
+		if getUserForRequest(request) != nil {
+			audit(request, AuditEvent{Action: auditLogout})
 		}
 
+		endSession(writer, request)
+		clearSessionKey(writer, request, sessionSingleSignOnKey)
+		clearSessionKey(writer, request, sessionIdTokenKey)
 		clearSessionKey(writer, request, sessionUserKey)
 		writer.Header().Set("location", redirect)
 		writer.WriteHeader(http.StatusSeeOther)
 	}
 }
 
+var permissionNames = map[string]config.Permission{
+	"none":  config.PermissionNone,
+	"auth":  config.PermissionAuth,
+	"read":  config.PermissionRead,
+	"write": config.PermissionWrite,
+	"admin": config.PermissionAdmin,
+}
+
 type UserLister interface {
 	Users() []*config.User
+	Groups() []*config.Group
 }
 
-func ManageUsersHandler(t *Templates, ul UserLister) http.HandlerFunc {
+// SessionManager lists and revokes the sessions users are logged in with.
+type SessionManager interface {
+	Sessions(username string) []ActiveSession
+	Revoke(username, id string) error
+	RevokeAll(username, except string) (int, error)
+}
+
+func ManageUsersHandler(t *Templates, ul UserLister, sm SessionManager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		users := ul.Users()
 		var infos []UserInfo
 
 		for i := range users {
 			infos = append(infos, UserInfo{
-				Name:        users[i].Name,
-				Permissions: users[i].Permissions.String(),
+				Name:         users[i].Name,
+				Permissions:  users[i].Permissions.String(),
+				Email:        users[i].Email,
+				Groups:       users[i].Groups(),
+				SingleSignOn: users[i].Subject != "",
+				TwoFactor:    users[i].TwoFactorEnabled(),
+				Sessions:     sessionInfos(sm.Sessions(users[i].Name), ""),
 			})
 		}
 
 		sort.Slice(infos, func(i, j int) bool {
 			return infos[i].Name < infos[j].Name
 		})
-		t.RenderManageUsers(w, r, infos)
+
+		var groups []GroupInfo
+		for _, group := range ul.Groups() {
+			groups = append(groups, GroupInfo{
+				Name:        group.Name,
+				Permissions: group.Permissions.String(),
+				Members:     group.Members,
+			})
+		}
+
+		t.RenderManageUsers(w, r, infos, groups)
 	}
 }
 
 type UserModifier interface {
 	AddUser(username, password, responsible string) error
 	SetPassword(username, password, responsible string) error
+	SetEmail(username, email, responsible string) error
 	SetPermission(username string, permissions config.Permission, responsible string) error
 	Delete(username, responsible string) error
+	DisableTotp(username, responsible string) error
 }
 
-func ModifyUserHandler(um UserModifier) http.HandlerFunc {
-	permissionNames := map[string]config.Permission{
-		"none":  config.PermissionNone,
-		"auth":  config.PermissionAuth,
-		"read":  config.PermissionRead,
-		"write": config.PermissionWrite,
-		"admin": config.PermissionAdmin,
-	}
+type GroupModifier interface {
+	AddGroup(name, responsible string) error
+	DeleteGroup(name, responsible string) error
+	SetGroupPermission(name string, permissions config.Permission, responsible string) error
+	AddGroupMember(name, username, responsible string) error
+	RemoveGroupMember(name, username, responsible string) error
+}
+
+type UserGroupModifier interface {
+	UserModifier
+	GroupModifier
+}
 
+func ModifyUserHandler(um UserGroupModifier, sm SessionManager) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		responsible := "Anonymoose"
 		if user := getUserForRequest(request); user != nil {
@@ -102,18 +248,28 @@ func ModifyUserHandler(um UserModifier) http.HandlerFunc {
 			if err := um.SetPassword(user, request.FormValue("password"), responsible); err != nil {
 				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to set password: %v", err))
 			} else {
+				audit(request, AuditEvent{Action: auditUserPassword, Target: user})
 				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Password updated for user %s", user))
 			}
+		} else if action == "email" {
+			if err := um.SetEmail(user, request.FormValue("email"), responsible); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to set email address: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditUserEmail, Target: user})
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Email address updated for user %s", user))
+			}
 		} else if action == "delete" {
 			if err := um.Delete(user, responsible); err != nil {
 				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to delete user: %v", err))
 			} else {
+				audit(request, AuditEvent{Action: auditUserDelete, Target: user})
 				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("User %s has been terminated", user))
 			}
 		} else if action == "new" {
 			if err := um.AddUser(user, request.FormValue("password"), responsible); err != nil {
 				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to create new user: %v", err))
 			} else {
+				audit(request, AuditEvent{Action: auditUserCreate, Target: user})
 				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("User %s has been created", user))
 			}
 		} else if action == "permissions" {
@@ -126,8 +282,76 @@ func ModifyUserHandler(um UserModifier) http.HandlerFunc {
 			if err := um.SetPermission(user, perm, responsible); err != nil {
 				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to set permissions: %v", err))
 			} else {
+				audit(request, AuditEvent{Action: auditUserPermissions, Target: user, Detail: perm.String()})
 				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("User %s has been modified", user))
 			}
+		} else if action == "totpdisable" {
+			if err := um.DisableTotp(user, responsible); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to turn off two-factor authentication: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditUserTwoFactor, Target: user, Detail: "turned off"})
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Two-factor authentication has been turned off for user %s", user))
+			}
+		} else if action == "revokesession" {
+			if err := sm.Revoke(user, request.FormValue("session")); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to revoke session: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditUserSessions, Target: user, Detail: "revoked 1 session"})
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Session for user %s has been revoked", user))
+			}
+		} else if action == "revokesessions" {
+			if count, err := sm.RevokeAll(user, ""); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to revoke sessions: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditUserSessions, Target: user, Detail: fmt.Sprintf("revoked %d sessions", count)})
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("User %s has been logged out of %d sessions", user, count))
+			}
+		} else if action == "newgroup" {
+			group := request.FormValue("group")
+			if err := um.AddGroup(group, responsible); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to create new group: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditGroupCreate, Target: group})
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Group %s has been created", group))
+			}
+		} else if action == "deletegroup" {
+			group := request.FormValue("group")
+			if err := um.DeleteGroup(group, responsible); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to delete group: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditGroupDelete, Target: group})
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Group %s has been deleted", group))
+			}
+		} else if action == "grouppermissions" {
+			perm, ok := permissionNames[request.FormValue("permissions")]
+			if !ok {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			group := request.FormValue("group")
+			if err := um.SetGroupPermission(group, perm, responsible); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to set permissions: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditGroupPermissions, Target: group, Detail: perm.String()})
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Group %s has been modified", group))
+			}
+		} else if action == "addmember" {
+			group := request.FormValue("group")
+			if err := um.AddGroupMember(group, user, responsible); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to add user to group: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditGroupMembers, Target: group, Detail: "added " + user})
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("User %s has been added to group %s", user, group))
+			}
+		} else if action == "removemember" {
+			group := request.FormValue("group")
+			if err := um.RemoveGroupMember(group, user, responsible); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to remove user from group: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditGroupMembers, Target: group, Detail: "removed " + user})
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("User %s has been removed from group %s", user, group))
+			}
 		} else {
 			writer.WriteHeader(http.StatusBadRequest)
 			return
@@ -138,16 +362,63 @@ func ModifyUserHandler(um UserModifier) http.HandlerFunc {
 	}
 }
 
-func AccountHandler(t *Templates) http.HandlerFunc {
-	return t.RenderAccount
+func AccountHandler(t *Templates, sm SessionManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := getUserForRequest(r)
+		if user == nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		t.RenderAccount(w, r, tokenInfos(user), sessionInfos(sm.Sessions(user.Name), currentSessionID(r)), "", nil)
+	}
+}
+
+func tokenInfos(user *config.User) []TokenInfo {
+	var res []TokenInfo
+	if user != nil {
+		for i := range user.Tokens {
+			res = append(res, TokenInfo{
+				Name:        user.Tokens[i].Name,
+				Permissions: user.Tokens[i].Permissions.String(),
+				Created:     user.Tokens[i].Created,
+			})
+		}
+	}
+	return res
 }
 
-type PasswordUpdater interface {
+func sessionInfos(sessions []ActiveSession, current string) []SessionInfo {
+	var res []SessionInfo
+	for i := range sessions {
+		res = append(res, SessionInfo{
+			ID:       sessions[i].ID,
+			Device:   sessions[i].Device(),
+			IP:       sessions[i].IP,
+			Created:  sessions[i].Created,
+			LastSeen: sessions[i].LastSeen,
+			Current:  sessions[i].ID == current,
+		})
+	}
+	return res
+}
+
+type AccountModifier interface {
 	SetPassword(username, password, responsible string) error
 	Authenticate(username, password string) (*config.User, error)
+	User(username string) *config.User
+	AddToken(username, name string, permissions config.Permission) (string, error)
+	RevokeToken(username, name, responsible string) error
+	SetNotifications(username, email string, digest bool) error
+	Watch(username, path string) error
+	Unwatch(username, path string) error
+	BeginTotpEnrolment(username string) ([]byte, error)
+	ConfirmTotpEnrolment(username, code string) ([]string, error)
+	DisableTotp(username, responsible string) error
+	RegenerateRecoveryCodes(username string) ([]string, error)
 }
 
-func ModifyAccountHandler(pu PasswordUpdater) http.HandlerFunc {
+func ModifyAccountHandler(t *Templates, pu AccountModifier, sm SessionManager, policy TwoFactorPolicy) http.HandlerFunc {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		user := getUserForRequest(request)
 		if user == nil {
@@ -171,9 +442,128 @@ func ModifyAccountHandler(pu PasswordUpdater) http.HandlerFunc {
 			} else if err := pu.SetPassword(user.Name, password1, user.Name); err != nil {
 				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to set password: %v", err))
 			} else {
+				// Changing the password logs out every other session, in case one was started with the old password
+				if _, err := sm.RevokeAll(user.Name, currentSessionID(request)); err != nil {
+					log.Printf("Unable to revoke sessions for user %s: %v", user.Name, err)
+				}
+				audit(request, AuditEvent{Action: auditUserPassword, Target: user.Name})
 				putSessionKey(writer, request, sessionNoticeKey, "Your password has been updated")
 				putSessionKey(writer, request, sessionSessionKey, fmt.Sprintf(sessionKeyFormat, user.SessionKey))
 			}
+		} else if action == "newtoken" {
+			perm, ok := permissionNames[request.FormValue("permissions")]
+			if !ok {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			// Don't let a token be used to create another with greater permissions, or to get around setting up
+			// two-factor authentication
+			if policy.TwoFactorRequired(user) {
+				putSessionKey(writer, request, sessionErrorKey, "You must set up two-factor authentication before you can create a token")
+			} else if !user.Has(perm) {
+				putSessionKey(writer, request, sessionErrorKey, "You can't create a token with more permissions than you have")
+			} else if token, err := pu.AddToken(user.Name, request.FormValue("name"), perm); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to create token: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditTokenCreate, Target: user.Name, Detail: fmt.Sprintf("%s (%s)", request.FormValue("name"), perm)})
+				// Show the token straight away rather than redirecting, so it's never stored in the session
+				t.RenderAccount(writer, request, tokenInfos(pu.User(user.Name)), sessionInfos(sm.Sessions(user.Name), currentSessionID(request)), token, nil)
+				return
+			}
+		} else if action == "revoketoken" {
+			name := request.FormValue("name")
+			if err := pu.RevokeToken(user.Name, name, user.Name); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to revoke token: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditTokenRevoke, Target: user.Name, Detail: name})
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("Token %s has been revoked", name))
+			}
+		} else if action == "revokesession" {
+			if err := sm.Revoke(user.Name, request.FormValue("session")); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to revoke session: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditUserSessions, Target: user.Name, Detail: "revoked 1 session"})
+				putSessionKey(writer, request, sessionNoticeKey, "The session has been logged out")
+			}
+		} else if action == "revokeothers" {
+			if count, err := sm.RevokeAll(user.Name, currentSessionID(request)); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to revoke sessions: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditUserSessions, Target: user.Name, Detail: fmt.Sprintf("revoked %d sessions", count)})
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("%d other sessions have been logged out", count))
+			}
+		} else if action == "totpbegin" {
+			if _, err := pu.BeginTotpEnrolment(user.Name); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to set up two-factor authentication: %v", err))
+			}
+		} else if action == "totpconfirm" {
+			if codes, err := pu.ConfirmTotpEnrolment(user.Name, request.FormValue("code")); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to set up two-factor authentication: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditUserTwoFactor, Target: user.Name, Detail: "turned on"})
+				// Show the recovery codes straight away rather than redirecting, so they're never stored in the session
+				t.RenderAccount(writer, request, tokenInfos(user), sessionInfos(sm.Sessions(user.Name), currentSessionID(request)), "", codes)
+				return
+			}
+		} else if action == "totpdisable" || action == "recoverycodes" {
+			if _, err := pu.Authenticate(user.Name, request.FormValue("password")); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, "Your password was incorrect")
+			} else if action == "totpdisable" {
+				if err := pu.DisableTotp(user.Name, user.Name); err != nil {
+					putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to turn off two-factor authentication: %v", err))
+				} else {
+					audit(request, AuditEvent{Action: auditUserTwoFactor, Target: user.Name, Detail: "turned off"})
+					putSessionKey(writer, request, sessionNoticeKey, "Two-factor authentication has been turned off")
+				}
+			} else if codes, err := pu.RegenerateRecoveryCodes(user.Name); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to generate recovery codes: %v", err))
+			} else {
+				t.RenderAccount(writer, request, tokenInfos(user), sessionInfos(sm.Sessions(user.Name), currentSessionID(request)), "", codes)
+				return
+			}
+		} else if action == "notifications" {
+			email := strings.TrimSpace(request.FormValue("email"))
+			// The email address can be used to reset the password, so changing it needs the password too
+			changed := email != "" && !strings.EqualFold(email, user.Email)
+			if changed && user.Subject == "" {
+				if _, err := pu.Authenticate(user.Name, request.FormValue("password")); err != nil {
+					putSessionKey(writer, request, sessionErrorKey, "Your password was incorrect")
+					writer.Header().Add("location", "/wiki/account")
+					writer.WriteHeader(http.StatusSeeOther)
+					return
+				}
+			}
+
+			if err := pu.SetNotifications(user.Name, email, request.FormValue("digest") == "true"); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to update notifications: %v", err))
+			} else {
+				if changed {
+					audit(request, AuditEvent{Action: auditUserEmail, Target: user.Name})
+				}
+				putSessionKey(writer, request, sessionNoticeKey, "Your notification settings have been updated")
+			}
+		} else if action == "watch" || action == "unwatch" {
+			path := request.FormValue("path")
+			redirect := localRedirect(request.FormValue("redirect"), "/wiki/account")
+
+			if action == "watch" {
+				if err := pu.Watch(user.Name, path); err != nil {
+					putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to watch %s: %v", path, err))
+				} else if user.Email == "" {
+					putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("You are now watching %s. Set an email address on your account to receive notifications.", path))
+				} else {
+					putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("You are now watching %s", path))
+				}
+			} else if err := pu.Unwatch(user.Name, path); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to stop watching %s: %v", path, err))
+			} else {
+				putSessionKey(writer, request, sessionNoticeKey, fmt.Sprintf("You are no longer watching %s", path))
+			}
+
+			writer.Header().Add("location", redirect)
+			writer.WriteHeader(http.StatusSeeOther)
+			return
 		} else {
 			writer.WriteHeader(http.StatusBadRequest)
 			return
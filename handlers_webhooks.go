@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mdbot/wiki/config"
+	"github.com/mdbot/wiki/webhook"
+)
+
+// webhookEvents are the types of change that webhooks can be triggered by.
+var webhookEvents = []ChangeType{
+	ChangeCreate,
+	ChangeEdit,
+	ChangeDelete,
+	ChangeRename,
+	ChangeRevert,
+	ChangeUpload,
+	ChangeConfig,
+	ChangePush,
+	ChangePull,
+}
+
+type WebhookProvider interface {
+	AllWebhooks() []config.Webhook
+	Webhook(id string) *config.Webhook
+}
+
+type WebhookSender interface {
+	Send(target webhook.Target, event *webhook.Event)
+}
+
+// NotifyWebhooks returns a CommitHook that sends each change to the webhooks that want it.
+func NotifyWebhooks(wp WebhookProvider, sender WebhookSender) CommitHook {
+	return func(change *Change) {
+		// Changes to the webhook settings are committed while they're locked, so look them up in the background.
+		go func() {
+			hooks := wp.AllWebhooks()
+			for i := range hooks {
+				if !hooks[i].Wants(string(change.Type)) {
+					continue
+				}
+
+				sender.Send(webhookTarget(&hooks[i]), &webhook.Event{
+					Event:   string(change.Type),
+					Commit:  change.Commit.String(),
+					User:    change.User,
+					Message: change.Message,
+					Time:    change.Time,
+					Paths:   change.Paths,
+				})
+			}
+		}()
+	}
+}
+
+func webhookTarget(hook *config.Webhook) webhook.Target {
+	return webhook.Target{ID: hook.ID, URL: hook.URL, Secret: hook.Secret}
+}
+
+type DeliveryLog interface {
+	Deliveries() []webhook.Delivery
+}
+
+func WebhooksHandler(t *Templates, wp WebhookProvider, dl DeliveryLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t.RenderWebhooks(w, r, wp.AllWebhooks(), dl.Deliveries())
+	}
+}
+
+type WebhookModifier interface {
+	WebhookProvider
+	AddWebhook(target string, secret string, events []string, responsible string) error
+	DeleteWebhook(id string, responsible string) error
+}
+
+func ModifyWebhooksHandler(wm WebhookModifier, sender WebhookSender) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if err := request.ParseForm(); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		username := "Anonymoose"
+		if user := getUserForRequest(request); user != nil {
+			username = user.Name
+		}
+
+		switch request.PostForm.Get("action") {
+		case "add":
+			var events []string
+			for _, event := range request.PostForm["events"] {
+				for i := range webhookEvents {
+					if event == string(webhookEvents[i]) {
+						events = append(events, event)
+					}
+				}
+			}
+
+			if err := wm.AddWebhook(request.PostForm.Get("url"), request.PostForm.Get("secret"), events, username); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to add webhook: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditConfigWebhooks, Detail: "added " + request.PostForm.Get("url")})
+				putSessionKey(writer, request, sessionNoticeKey, "Webhook added")
+			}
+		case "delete":
+			if err := wm.DeleteWebhook(request.PostForm.Get("id"), username); err != nil {
+				putSessionKey(writer, request, sessionErrorKey, fmt.Sprintf("Unable to delete webhook: %v", err))
+			} else {
+				audit(request, AuditEvent{Action: auditConfigWebhooks, Detail: "deleted " + request.PostForm.Get("id")})
+				putSessionKey(writer, request, sessionNoticeKey, "Webhook deleted")
+			}
+		case "test":
+			hook := wm.Webhook(request.PostForm.Get("id"))
+			if hook == nil {
+				putSessionKey(writer, request, sessionErrorKey, "Unable to test webhook: webhook not found")
+			} else {
+				sender.Send(webhookTarget(hook), &webhook.Event{
+					Event: "ping",
+					User:  username,
+					Time:  time.Now(),
+					Paths: []string{},
+				})
+				putSessionKey(writer, request, sessionNoticeKey, "Test event sent")
+			}
+		default:
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		writer.Header().Set("location", "/wiki/webhooks")
+		writer.WriteHeader(http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/mdbot/wiki/config"
+	"github.com/mdbot/wiki/ldap"
+)
+
+// LdapAuth checks passwords against an LDAP directory, for users who don't have a local account.
+type LdapAuth struct {
+	directory   *ldap.Directory
+	permissions map[string]config.Permission
+}
+
+// NewLdapAuth configures password checks against the directory. The permissions mapping is a comma-separated list of
+// group=permission pairs, granting the permission to users who are members of that group. As with single sign-on,
+// users who aren't in any of the groups are reduced to PermissionAuth when they log in.
+func NewLdapAuth(directory ldap.Config, permissions string) (*LdapAuth, error) {
+	mapping, err := parsePermissionMapping(permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := ldap.New(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(strings.ToLower(directory.URL), "ldap://") && !directory.StartTLS {
+		log.Print("WARNING: The LDAP directory is not using TLS or StartTLS. Users' passwords will be sent to it unencrypted!")
+	}
+
+	return &LdapAuth{
+		directory:   d,
+		permissions: mapping,
+	}, nil
+}
+
+// AuthenticatePassword checks the user's password by binding to the directory as them. Problems talking to the
+// directory are logged, rather than being shown to the user.
+func (l *LdapAuth) AuthenticatePassword(username, password string) (*config.ExternalUser, error) {
+	entry, err := l.directory.Authenticate(username, password)
+	if errors.Is(err, ldap.ErrInvalidCredentials) {
+		return nil, fmt.Errorf("invalid username/password")
+	} else if err != nil {
+		log.Printf("Unable to check password for user %s with LDAP directory: %v", username, err)
+		return nil, fmt.Errorf("unable to check password, please try again later")
+	}
+
+	return &config.ExternalUser{
+		Subject:     config.LdapSubjectPrefix + strings.ToLower(entry.DN),
+		Username:    entry.Username,
+		Permissions: permissionsFor(l.permissions, entry.Groups),
+		Groups:      entry.Groups,
+	}, nil
+}
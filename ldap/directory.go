@@ -0,0 +1,194 @@
+// Package ldap checks passwords against an LDAP directory, by searching for the user's entry and binding as them.
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// ErrInvalidCredentials is returned when the directory doesn't have a matching user, or the password is wrong.
+var ErrInvalidCredentials = errors.New("invalid username/password")
+
+// Config describes how to find users in a directory.
+type Config struct {
+	// URL is the address of the directory, using either the ldap or ldaps scheme.
+	URL string
+	// BindDN and BindPassword are the credentials used to search for users. If BindDN is empty, searches are made
+	// anonymously.
+	BindDN       string
+	BindPassword string
+	// BaseDN is the entry to search for users under.
+	BaseDN string
+	// UserFilter is the filter used to find a user's entry, with %s in place of the (escaped) username.
+	UserFilter string
+	// UsernameAttribute is the attribute holding the username that should be used for the account. If the entry
+	// doesn't have it, the name the user logged in with is used instead.
+	UsernameAttribute string
+	// GroupsAttribute is the attribute listing the groups the user is a member of, such as memberOf.
+	GroupsAttribute string
+	// StartTLS upgrades plain ldap connections to TLS before sending any credentials.
+	StartTLS bool
+	// TLSConfig is used for ldaps and StartTLS connections. If nil, the system's root certificates are used.
+	TLSConfig *tls.Config
+}
+
+// Entry is a user found in the directory.
+type Entry struct {
+	DN       string
+	Username string
+	// Groups are the names of the groups the user is a member of. Groups identified by a DN are named after the value
+	// of its first component, e.g. "editors" for cn=editors,ou=groups,dc=example,dc=com.
+	Groups []string
+}
+
+// Directory authenticates users against an LDAP directory. A new connection is made for each attempt.
+type Directory struct {
+	config  Config
+	timeout time.Duration
+}
+
+// New checks the configuration is complete and returns a Directory using it. It doesn't connect to the directory.
+func New(config Config) (*Directory, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, fmt.Errorf("invalid directory URL %q", config.URL)
+	}
+
+	if config.StartTLS && u.Scheme == "ldaps" {
+		return nil, fmt.Errorf("StartTLS can't be used with an ldaps URL")
+	}
+
+	if config.BaseDN == "" {
+		return nil, fmt.Errorf("no base DN given")
+	}
+
+	if strings.Count(config.UserFilter, "%s") != 1 {
+		return nil, fmt.Errorf("user filter %q must contain %%s exactly once", config.UserFilter)
+	}
+
+	if config.TLSConfig == nil {
+		config.TLSConfig = &tls.Config{}
+	}
+	if config.TLSConfig.ServerName == "" {
+		config.TLSConfig = config.TLSConfig.Clone()
+		config.TLSConfig.ServerName = u.Hostname()
+	}
+
+	return &Directory{
+		config:  config,
+		timeout: 10 * time.Second,
+	}, nil
+}
+
+// Authenticate finds the user's entry in the directory and checks their password by binding as them. It returns
+// ErrInvalidCredentials if there's no such user or the password is wrong.
+func (d *Directory) Authenticate(username, password string) (*Entry, error) {
+	// Most directories treat a bind with an empty password as an anonymous bind, which would always succeed
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if d.config.BindDN != "" {
+		if err := conn.Bind(d.config.BindDN, d.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("unable to bind as %s: %w", d.config.BindDN, err)
+		}
+	}
+
+	var attributes []string
+	for _, attribute := range []string{d.config.UsernameAttribute, d.config.GroupsAttribute} {
+		if attribute != "" {
+			attributes = append(attributes, attribute)
+		}
+	}
+
+	result, err := conn.Search(goldap.NewSearchRequest(
+		d.config.BaseDN,
+		goldap.ScopeWholeSubtree,
+		goldap.NeverDerefAliases,
+		2,
+		int(d.timeout.Seconds()),
+		false,
+		fmt.Sprintf(d.config.UserFilter, goldap.EscapeFilter(username)),
+		attributes,
+		nil,
+	))
+	if goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("more than one entry matched user %s", username)
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to search for user %s: %w", username, err)
+	}
+
+	if len(result.Entries) == 0 {
+		return nil, ErrInvalidCredentials
+	} else if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("more than one entry matched user %s", username)
+	}
+
+	entry := result.Entries[0]
+	if err := conn.Bind(entry.DN, password); goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to bind as %s: %w", entry.DN, err)
+	}
+
+	res := &Entry{
+		DN:       entry.DN,
+		Username: username,
+		Groups:   []string{},
+	}
+	if d.config.UsernameAttribute != "" {
+		if name := entry.GetEqualFoldAttributeValue(d.config.UsernameAttribute); name != "" {
+			res.Username = name
+		}
+	}
+	if d.config.GroupsAttribute != "" {
+		for _, group := range entry.GetEqualFoldAttributeValues(d.config.GroupsAttribute) {
+			res.Groups = append(res.Groups, groupName(group))
+		}
+	}
+	return res, nil
+}
+
+// connect opens a connection to the directory, upgrading it to TLS if configured.
+func (d *Directory) connect() (*goldap.Conn, error) {
+	conn, err := goldap.DialURL(
+		d.config.URL,
+		goldap.DialWithDialer(&net.Dialer{Timeout: d.timeout}),
+		goldap.DialWithTLSConfig(d.config.TLSConfig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to directory: %w", err)
+	}
+	conn.SetTimeout(d.timeout)
+
+	if d.config.StartTLS {
+		if err := conn.StartTLS(d.config.TLSConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to start TLS: %w", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// groupName returns the value of the first component of a group's DN, or the group unchanged if it isn't a DN.
+func groupName(group string) string {
+	dn, err := goldap.ParseDN(group)
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return group
+	}
+	return dn.RDNs[0].Attributes[0].Value
+}
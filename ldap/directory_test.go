@@ -0,0 +1,172 @@
+package ldap
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/mdbot/wiki/ldap/ldaptest"
+)
+
+func testDirectory() *ldaptest.Server {
+	return ldaptest.NewServer(
+		ldaptest.Entry{
+			DN:       "cn=wiki,ou=services,dc=example,dc=com",
+			Password: "service",
+		},
+		ldaptest.Entry{
+			DN:       "uid=alice,ou=people,dc=example,dc=com",
+			Password: "alicepass",
+			Attributes: map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"Alice"},
+				"memberOf":    {"cn=editors,ou=groups,dc=example,dc=com", "staff"},
+			},
+		},
+		ldaptest.Entry{
+			DN:       "uid=bob,ou=people,dc=example,dc=com",
+			Password: "bobpass",
+			Attributes: map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"bob"},
+			},
+		},
+	)
+}
+
+func TestDirectory_Authenticate(t *testing.T) {
+	server := testDirectory()
+	defer server.Close()
+
+	d, err := New(Config{
+		URL:               server.URL(),
+		BindDN:            "cn=wiki,ou=services,dc=example,dc=com",
+		BindPassword:      "service",
+		BaseDN:            "ou=people,dc=example,dc=com",
+		UserFilter:        "(&(objectClass=person)(uid=%s))",
+		UsernameAttribute: "uid",
+		GroupsAttribute:   "memberOf",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := d.Authenticate("alice", "alicepass")
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	want := &Entry{DN: "uid=alice,ou=people,dc=example,dc=com", Username: "Alice", Groups: []string{"editors", "staff"}}
+	if !reflect.DeepEqual(entry, want) {
+		t.Errorf("Authenticate() = %+v, want %+v", entry, want)
+	}
+
+	entry, err = d.Authenticate("bob", "bobpass")
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if entry.Username != "bob" || len(entry.Groups) != 0 {
+		t.Errorf("Authenticate() = %+v, want bob with no groups", entry)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"wrong password", "alice", "bobpass"},
+		{"unknown user", "carol", "alicepass"},
+		{"empty password", "alice", ""},
+		{"wildcard username", "*", "alicepass"},
+		{"filter injection", "alice)(uid=*", "alicepass"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := d.Authenticate(tt.username, tt.password); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Authenticate() error = %v, want %v", err, ErrInvalidCredentials)
+			}
+		})
+	}
+}
+
+func TestDirectory_ServiceAccount(t *testing.T) {
+	server := testDirectory()
+	defer server.Close()
+
+	d, err := New(Config{
+		URL:          server.URL(),
+		BindDN:       "cn=wiki,ou=services,dc=example,dc=com",
+		BindPassword: "wrong",
+		BaseDN:       "ou=people,dc=example,dc=com",
+		UserFilter:   "(uid=%s)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Authenticate("alice", "alicepass"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() error = %v, want a bind failure", err)
+	}
+}
+
+func TestDirectory_StartTLS(t *testing.T) {
+	server := testDirectory()
+	defer server.Close()
+	server.RequireTLS()
+
+	config := Config{
+		URL:        server.URL(),
+		BaseDN:     "ou=people,dc=example,dc=com",
+		UserFilter: "(uid=%s)",
+	}
+
+	d, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Authenticate("alice", "alicepass"); err == nil {
+		t.Errorf("Authenticate() succeeded without TLS")
+	}
+
+	config.StartTLS = true
+	config.TLSConfig = server.ClientTLSConfig()
+	d, err = New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Authenticate("alice", "alicepass"); err != nil {
+		t.Errorf("Authenticate() with StartTLS error = %v", err)
+	}
+
+	// The server's certificate must be trusted
+	config.TLSConfig = nil
+	d, err = New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Authenticate("alice", "alicepass"); err == nil {
+		t.Errorf("Authenticate() succeeded with an untrusted certificate")
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"valid", Config{URL: "ldap://ldap.example.com", BaseDN: "dc=example,dc=com", UserFilter: "(uid=%s)"}, false},
+		{"ldaps", Config{URL: "ldaps://ldap.example.com:636", BaseDN: "dc=example,dc=com", UserFilter: "(uid=%s)"}, false},
+		{"invalid scheme", Config{URL: "https://ldap.example.com", BaseDN: "dc=example,dc=com", UserFilter: "(uid=%s)"}, true},
+		{"no host", Config{URL: "ldap://", BaseDN: "dc=example,dc=com", UserFilter: "(uid=%s)"}, true},
+		{"StartTLS with ldaps", Config{URL: "ldaps://ldap.example.com", BaseDN: "dc=example,dc=com", UserFilter: "(uid=%s)", StartTLS: true}, true},
+		{"no base DN", Config{URL: "ldap://ldap.example.com", UserFilter: "(uid=%s)"}, true},
+		{"no placeholder", Config{URL: "ldap://ldap.example.com", BaseDN: "dc=example,dc=com", UserFilter: "(uid=alice)"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
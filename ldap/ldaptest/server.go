@@ -0,0 +1,356 @@
+// Package ldaptest provides a minimal LDAP directory for use in tests.
+package ldaptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+)
+
+const (
+	startTLSName = "1.3.6.1.4.1.1466.20037"
+
+	resultSuccess            = 0
+	resultProtocolError      = 2
+	resultConfidentiality    = 13
+	resultNoSuchObject       = 32
+	resultInvalidCredentials = 49
+	resultUnwillingToPerform = 53
+)
+
+// Entry is an object in the directory. Users can bind as any entry with a password.
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Server is a directory that supports simple binds, searches using equality, presence, and/or/not filters, and
+// StartTLS. Search scopes and size limits are ignored.
+type Server struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	pool      *x509.CertPool
+
+	mutex      sync.Mutex
+	entries    []Entry
+	requireTLS bool
+	wg         sync.WaitGroup
+}
+
+// NewServer starts a new directory on a random local port, containing the given entries. Callers should call Close
+// when finished.
+func NewServer(entries ...Entry) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("ldaptest: failed to listen: %v", err))
+	}
+
+	cert, pool := certificate()
+	s := &Server{
+		listener:  listener,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		pool:      pool,
+		entries:   entries,
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// URL returns the ldap URL of the server.
+func (s *Server) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// ClientTLSConfig returns a TLS configuration that trusts the server's certificate.
+func (s *Server) ClientTLSConfig() *tls.Config {
+	return &tls.Config{RootCAs: s.pool, ServerName: "127.0.0.1"}
+}
+
+// RequireTLS makes the server refuse binds and searches until StartTLS has been used.
+func (s *Server) RequireTLS() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.requireTLS = true
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	secure := false
+	for {
+		_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		if op.ClassType != ber.ClassApplication {
+			return
+		}
+
+		s.mutex.Lock()
+		refuse := s.requireTLS && !secure
+		s.mutex.Unlock()
+
+		switch op.Tag {
+		case 0: // Bind
+			if refuse {
+				s.reply(conn, id, 1, resultConfidentiality, "TLS required")
+			} else {
+				s.reply(conn, id, 1, s.bind(op), "")
+			}
+		case 2: // Unbind
+			return
+		case 3: // Search
+			code := resultConfidentiality
+			if !refuse {
+				code = s.search(conn, id, op)
+			}
+			s.reply(conn, id, 5, code, "")
+		case 23: // Extended
+			if len(op.Children) == 0 || op.Children[0].Data.String() != startTLSName {
+				s.reply(conn, id, 24, resultProtocolError, "unsupported extended operation")
+				continue
+			}
+			if secure {
+				s.reply(conn, id, 24, resultProtocolError, "TLS already started")
+				continue
+			}
+
+			s.reply(conn, id, 24, resultSuccess, "")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			secure = true
+		default:
+			s.reply(conn, id, op.Tag+1, resultUnwillingToPerform, "unsupported operation")
+		}
+	}
+}
+
+// bind checks the credentials in a simple bind request, and returns the result code.
+func (s *Server) bind(op *ber.Packet) int {
+	if len(op.Children) < 3 || op.Children[2].Tag != 0 {
+		return resultProtocolError
+	}
+
+	dn := op.Children[1].Data.String()
+	password := op.Children[2].Data.String()
+	if dn == "" && password == "" {
+		return resultSuccess
+	}
+
+	for _, entry := range s.snapshot() {
+		if strings.EqualFold(entry.DN, dn) && entry.Password != "" && entry.Password == password {
+			return resultSuccess
+		}
+	}
+	return resultInvalidCredentials
+}
+
+// search sends every entry under the base DN that matches the filter, and returns the result code. The base DN is
+// treated as existing if any entry is under it.
+func (s *Server) search(conn net.Conn, id int64, op *ber.Packet) int {
+	if len(op.Children) < 8 {
+		return resultProtocolError
+	}
+
+	base := strings.ToLower(op.Children[0].Data.String())
+	filter := op.Children[6]
+	var wanted []string
+	for _, attribute := range op.Children[7].Children {
+		wanted = append(wanted, attribute.Data.String())
+	}
+
+	found := false
+	for _, entry := range s.snapshot() {
+		dn := strings.ToLower(entry.DN)
+		if dn != base && !strings.HasSuffix(dn, ","+base) {
+			continue
+		}
+		found = true
+
+		if !matches(entry, filter) {
+			continue
+		}
+
+		result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, 4, nil, "Search Result Entry")
+		result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "DN"))
+		attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+		for name, values := range entry.Attributes {
+			if !included(name, wanted) {
+				continue
+			}
+
+			attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+			attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+			for _, value := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+			}
+			attribute.AppendChild(set)
+			attributes.AppendChild(attribute)
+		}
+		result.AppendChild(attributes)
+		send(conn, id, result)
+	}
+
+	if !found {
+		return resultNoSuchObject
+	}
+	return resultSuccess
+}
+
+func (s *Server) snapshot() []Entry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]Entry(nil), s.entries...)
+}
+
+func (s *Server) reply(conn net.Conn, id int64, tag ber.Tag, code int, message string) {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "Diagnostic Message"))
+	send(conn, id, result)
+}
+
+func send(conn net.Conn, id int64, op *ber.Packet) {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	packet.AppendChild(op)
+	_, _ = conn.Write(packet.Bytes())
+}
+
+// matches evaluates a search filter against an entry. Unsupported filters never match.
+func matches(entry Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case 0: // And
+		for _, child := range filter.Children {
+			if !matches(entry, child) {
+				return false
+			}
+		}
+		return true
+	case 1: // Or
+		for _, child := range filter.Children {
+			if matches(entry, child) {
+				return true
+			}
+		}
+		return false
+	case 2: // Not
+		return len(filter.Children) == 1 && !matches(entry, filter.Children[0])
+	case 3: // Equality
+		if len(filter.Children) != 2 {
+			return false
+		}
+		for _, value := range attributeValues(entry, filter.Children[0].Data.String()) {
+			if strings.EqualFold(value, filter.Children[1].Data.String()) {
+				return true
+			}
+		}
+		return false
+	case 7: // Present
+		return len(attributeValues(entry, filter.Data.String())) > 0
+	default:
+		return false
+	}
+}
+
+func attributeValues(entry Entry, name string) []string {
+	for key, values := range entry.Attributes {
+		if strings.EqualFold(key, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+func included(name string, wanted []string) bool {
+	if len(wanted) == 0 {
+		return true
+	}
+	for i := range wanted {
+		if wanted[i] == "*" || strings.EqualFold(wanted[i], name) {
+			return true
+		}
+	}
+	return false
+}
+
+// certificate generates a self-signed certificate for 127.0.0.1, and a pool containing it.
+func certificate() (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ldaptest"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: parsed}, pool
+}
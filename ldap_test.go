@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mdbot/wiki/config"
+	"github.com/mdbot/wiki/ldap"
+	"github.com/mdbot/wiki/ldap/ldaptest"
+)
+
+func TestLdapAuth_AuthenticatePassword(t *testing.T) {
+	server := ldaptest.NewServer(
+		ldaptest.Entry{
+			DN:       "uid=alice,ou=people,dc=example,dc=com",
+			Password: "alicepass",
+			Attributes: map[string][]string{
+				"uid":      {"alice"},
+				"memberOf": {"cn=Editors,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+			},
+		},
+	)
+	defer server.Close()
+
+	auth, err := NewLdapAuth(ldap.Config{
+		URL:               server.URL(),
+		BaseDN:            "dc=example,dc=com",
+		UserFilter:        "(uid=%s)",
+		UsernameAttribute: "uid",
+		GroupsAttribute:   "memberOf",
+	}, "editors=write,staff=read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := auth.AuthenticatePassword("alice", "alicepass")
+	if err != nil {
+		t.Fatalf("AuthenticatePassword() error = %v", err)
+	}
+	if user.Subject != "ldap:uid=alice,ou=people,dc=example,dc=com" || user.Username != "alice" || user.Permissions != config.PermissionWrite {
+		t.Errorf("AuthenticatePassword() = %+v, want alice with write permission", user)
+	}
+
+	// Users who aren't in any of the mapped groups lose any permissions a group gave them
+	auth.permissions = map[string]config.Permission{"admins": config.PermissionAdmin}
+	if user, err := auth.AuthenticatePassword("alice", "alicepass"); err != nil || user.Permissions != config.PermissionAuth {
+		t.Errorf("AuthenticatePassword() for unmapped groups = %+v, %v, want auth permission", user, err)
+	}
+
+	if _, err := auth.AuthenticatePassword("alice", "wrong"); err == nil || err.Error() != "invalid username/password" {
+		t.Errorf("AuthenticatePassword() with wrong password error = %v", err)
+	}
+
+	server.Close()
+	if _, err := auth.AuthenticatePassword("alice", "alicepass"); err == nil || err.Error() == "invalid username/password" {
+		t.Errorf("AuthenticatePassword() with directory unavailable error = %v", err)
+	}
+}
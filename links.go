@@ -0,0 +1,155 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mdbot/wiki/markdown"
+)
+
+// LinkGraph tracks the wikilinks and embeds between pages and files.
+type LinkGraph struct {
+	mutex sync.RWMutex
+	// outgoing maps each page to the pages and files it links to.
+	outgoing map[string][]markdown.Link
+	// incoming maps each page to the set of pages that link to it.
+	incoming map[string]map[string]bool
+	// embeds maps each file to the set of pages that embed it.
+	embeds map[string]map[string]bool
+}
+
+// PageLinks describes the links to and from a single page.
+type PageLinks struct {
+	Page       string   `json:"page"`
+	LinkedFrom []string `json:"linkedFrom"`
+	LinksTo    []string `json:"linksTo"`
+	Embeds     []string `json:"embeds"`
+}
+
+func NewLinkGraph() *LinkGraph {
+	return &LinkGraph{
+		outgoing: make(map[string][]markdown.Link),
+		incoming: make(map[string]map[string]bool),
+		embeds:   make(map[string]map[string]bool),
+	}
+}
+
+// Put records the links in the given page, replacing any previously recorded for it.
+func (l *LinkGraph) Put(name string, content []byte) {
+	var links []markdown.Link
+	seen := make(map[markdown.Link]bool)
+	for _, link := range markdown.ExtractLinks(content) {
+		link.Target = normaliseLinkTarget(link.Target)
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.remove(name)
+	l.outgoing[name] = links
+	for i := range links {
+		if links[i].Embed {
+			addLink(l.embeds, links[i].Target, name)
+		} else {
+			addLink(l.incoming, links[i].Target, name)
+		}
+	}
+}
+
+// Remove forgets all links from the given page.
+func (l *LinkGraph) Remove(name string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.remove(name)
+}
+
+func (l *LinkGraph) remove(name string) {
+	links := l.outgoing[name]
+	for i := range links {
+		if links[i].Embed {
+			removeLink(l.embeds, links[i].Target, name)
+		} else {
+			removeLink(l.incoming, links[i].Target, name)
+		}
+	}
+	delete(l.outgoing, name)
+}
+
+// LinksTo returns the pages that link to the given page, in alphabetical order.
+func (l *LinkGraph) LinksTo(page string) []string {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return sortedKeys(l.incoming[normaliseLinkTarget(page)])
+}
+
+// EmbedsOf returns the pages that embed the given file, in alphabetical order.
+func (l *LinkGraph) EmbedsOf(file string) []string {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	return sortedKeys(l.embeds[normaliseLinkTarget(file)])
+}
+
+// Links returns the links to and from the given page.
+func (l *LinkGraph) Links(page string) *PageLinks {
+	page = normaliseLinkTarget(page)
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	res := &PageLinks{
+		Page:       page,
+		LinkedFrom: sortedKeys(l.incoming[page]),
+		LinksTo:    []string{},
+		Embeds:     []string{},
+	}
+
+	links := l.outgoing[page]
+	for i := range links {
+		if links[i].Embed {
+			res.Embeds = append(res.Embeds, links[i].Target)
+		} else {
+			res.LinksTo = append(res.LinksTo, links[i].Target)
+		}
+	}
+	return res
+}
+
+// normaliseLinkTarget converts the target of a link into the name of the page or file it refers to.
+func normaliseLinkTarget(target string) string {
+	target, _, _ = strings.Cut(target, "#")
+	return strings.ToLower(strings.TrimPrefix(path.Clean("/"+strings.TrimSpace(target)), "/"))
+}
+
+func addLink(links map[string]map[string]bool, target, source string) {
+	sources, ok := links[target]
+	if !ok {
+		sources = make(map[string]bool)
+		links[target] = sources
+	}
+	sources[source] = true
+}
+
+func removeLink(links map[string]map[string]bool, target, source string) {
+	delete(links[target], source)
+	if len(links[target]) == 0 {
+		delete(links, target)
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	res := make([]string, 0, len(set))
+	for k := range set {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
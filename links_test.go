@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLinkGraph(t *testing.T) {
+	graph := NewLinkGraph()
+	graph.Put("a", []byte("[[B]] and [[c#section]] and [[b]]"))
+	graph.Put("b", []byte("[[c]] ![[cat.png]]"))
+	graph.Put("c", []byte("Nothing here"))
+
+	if got, want := graph.LinksTo("c"), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LinksTo(c) = %v, want %v", got, want)
+	}
+
+	want := &PageLinks{Page: "a", LinkedFrom: []string{}, LinksTo: []string{"b", "c"}, Embeds: []string{}}
+	if got := graph.Links("A"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Links(A) = %v, want %v", got, want)
+	}
+
+	if got, want := graph.EmbedsOf("cat.png"), []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("EmbedsOf(cat.png) = %v, want %v", got, want)
+	}
+
+	graph.Put("a", []byte("Only [[d]] now"))
+	graph.Remove("b")
+
+	if got := graph.LinksTo("c"); len(got) != 0 {
+		t.Errorf("LinksTo(c) after updates = %v, want none", got)
+	}
+	if got := graph.EmbedsOf("cat.png"); len(got) != 0 {
+		t.Errorf("EmbedsOf(cat.png) after updates = %v, want none", got)
+	}
+	if got, want := graph.LinksTo("d"), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LinksTo(d) = %v, want %v", got, want)
+	}
+}
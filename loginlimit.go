@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ipFreeAttempts and accountFreeAttempts are the number of consecutive failures allowed before logins from the
+	// address or for the account are slowed down.
+	ipFreeAttempts      = 5
+	accountFreeAttempts = 3
+	// loginBaseDelay is the delay after the first failure beyond the free attempts, which doubles with each failure
+	// after that up to loginMaxDelay.
+	loginBaseDelay = time.Second
+	loginMaxDelay  = 5 * time.Minute
+	// lockoutThreshold is the number of consecutive failures after which an account is locked for lockoutDuration.
+	lockoutThreshold = 10
+	lockoutDuration  = 30 * time.Minute
+	// failureExpiry is how long failures are remembered for if there are no more.
+	failureExpiry = time.Hour
+)
+
+// LoginThrottledError is returned when a login attempt is refused without checking the credentials.
+type LoginThrottledError struct {
+	Until  time.Time
+	Locked bool
+}
+
+func (e *LoginThrottledError) Error() string {
+	wait := time.Until(e.Until).Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+
+	if e.Locked {
+		return fmt.Sprintf("account temporarily locked after too many failed logins, try again in %s", wait)
+	}
+	return fmt.Sprintf("too many failed logins, try again in %s", wait)
+}
+
+type loginFailures struct {
+	count  int
+	last   time.Time
+	until  time.Time
+	locked bool
+}
+
+// LockedAccount describes an account that can't currently be logged in to because of failed logins.
+type LockedAccount struct {
+	Username string
+	Failures int
+	Until    time.Time
+	// Locked is true if the account reached the lockout threshold, rather than just being slowed down.
+	Locked bool
+}
+
+// LoginLimiter slows down repeated failed logins from the same address or for the same account, with exponentially
+// increasing delays, and temporarily locks accounts after too many. State is only kept in memory.
+type LoginLimiter struct {
+	mutex    sync.Mutex
+	ips      map[string]*loginFailures
+	accounts map[string]*loginFailures
+	now      func() time.Time
+	recorder AuditRecorder
+}
+
+// NewLoginLimiter creates a LoginLimiter that records accounts being locked with the recorder, if it isn't nil.
+func NewLoginLimiter(recorder AuditRecorder) *LoginLimiter {
+	return &LoginLimiter{
+		ips:      make(map[string]*loginFailures),
+		accounts: make(map[string]*loginFailures),
+		now:      time.Now,
+		recorder: recorder,
+	}
+}
+
+// Check returns a LoginThrottledError if a login attempt for the account from the given address shouldn't be
+// attempted yet.
+func (l *LoginLimiter) Check(ip, username string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	if f := l.accounts[strings.ToLower(username)]; f != nil && now.Before(f.until) {
+		return &LoginThrottledError{Until: f.until, Locked: f.locked}
+	}
+	if f := l.ips[ip]; f != nil && now.Before(f.until) {
+		return &LoginThrottledError{Until: f.until}
+	}
+	return nil
+}
+
+// Failed records a failed login attempt.
+func (l *LoginLimiter) Failed(ip, username string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.prune(now)
+
+	ipFailures := l.record(l.ips, ip, now)
+	ipFailures.until = now.Add(backoff(ipFailures.count, ipFreeAttempts))
+
+	account := l.record(l.accounts, strings.ToLower(username), now)
+	if account.count >= lockoutThreshold {
+		account.until = now.Add(lockoutDuration)
+		account.locked = true
+		log.Printf("Failed login for user %s from %s; locking account until %s after %d consecutive failures", username, ip, account.until.Format(time.RFC3339), account.count)
+		if l.recorder != nil {
+			l.recorder.Record(AuditEvent{
+				Time:   now,
+				IP:     ip,
+				Action: auditUserLock,
+				Target: username,
+				Detail: fmt.Sprintf("locked until %s after %d failed logins", account.until.Format(time.RFC3339), account.count),
+			})
+		}
+	} else {
+		account.until = now.Add(backoff(account.count, accountFreeAttempts))
+		log.Printf("Failed login for user %s from %s (%d consecutive failures for the account, %d from the address)", username, ip, account.count, ipFailures.count)
+	}
+}
+
+// Succeeded clears the failures recorded for the account. Failures from the address are kept, so that a valid
+// account can't be used to reset the limit while guessing the passwords of others.
+func (l *LoginLimiter) Succeeded(_, username string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.accounts, strings.ToLower(username))
+}
+
+// Attempt limits actions from the address that should be rare whether or not they succeed, such as registering new
+// accounts. Each attempt is recorded as if it were a failure, and a LoginThrottledError is returned instead if the
+// address has made too many recently. Accounts aren't affected.
+func (l *LoginLimiter) Attempt(ip string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	if f := l.ips[ip]; f != nil && now.Before(f.until) {
+		return &LoginThrottledError{Until: f.until}
+	}
+
+	l.prune(now)
+	f := l.record(l.ips, ip, now)
+	f.until = now.Add(backoff(f.count, ipFreeAttempts))
+	return nil
+}
+
+// LockedAccounts returns the accounts that can't currently be logged in to, ordered by name.
+func (l *LoginLimiter) LockedAccounts() []LockedAccount {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	var res []LockedAccount
+	for name, f := range l.accounts {
+		if now.Before(f.until) {
+			res = append(res, LockedAccount{Username: name, Failures: f.count, Until: f.until, Locked: f.locked})
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Username < res[j].Username
+	})
+	return res
+}
+
+// Unlock clears the failures recorded for the account, so it can be logged in to straight away.
+func (l *LoginLimiter) Unlock(username string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.accounts, strings.ToLower(username))
+}
+
+func (l *LoginLimiter) record(failures map[string]*loginFailures, key string, now time.Time) *loginFailures {
+	f := failures[key]
+	if f == nil {
+		f = &loginFailures{}
+		failures[key] = f
+	} else if f.locked && !now.Before(f.until) {
+		// The lockout has been served, so start counting again
+		*f = loginFailures{}
+	}
+
+	f.count++
+	f.last = now
+	return f
+}
+
+// prune forgets failures that are no longer relevant.
+func (l *LoginLimiter) prune(now time.Time) {
+	for _, failures := range []map[string]*loginFailures{l.ips, l.accounts} {
+		for key, f := range failures {
+			if now.Sub(f.last) > failureExpiry && !now.Before(f.until) {
+				delete(failures, key)
+			}
+		}
+	}
+}
+
+// backoff returns how long to wait after the given number of consecutive failures.
+func backoff(failures, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+
+	delay := loginBaseDelay
+	for i := free + 1; i < failures && delay < loginMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, loginMaxDelay)
+}
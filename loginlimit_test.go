@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoginLimiter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	recorder := &testAuditRecorder{}
+	limiter := NewLoginLimiter(recorder)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < accountFreeAttempts; i++ {
+		if err := limiter.Check("10.0.0.1", "alice"); err != nil {
+			t.Fatalf("attempt %d refused: %v", i+1, err)
+		}
+		limiter.Failed("10.0.0.1", "Alice")
+	}
+	if err := limiter.Check("10.0.0.1", "alice"); err != nil {
+		t.Fatalf("attempt after free failures refused: %v", err)
+	}
+
+	limiter.Failed("10.0.0.1", "alice")
+	var throttled *LoginThrottledError
+	if err := limiter.Check("10.0.0.2", "alice"); !errors.As(err, &throttled) || throttled.Locked || throttled.Until != now.Add(loginBaseDelay) {
+		t.Fatalf("Check() after backoff started = %v", err)
+	}
+	if err := limiter.Check("10.0.0.1", "bob"); err != nil {
+		t.Errorf("other account refused before the address limit: %v", err)
+	}
+
+	// The delay doubles with each failure
+	now = now.Add(time.Minute)
+	limiter.Failed("10.0.0.2", "alice")
+	if err := limiter.Check("10.0.0.3", "alice"); !errors.As(err, &throttled) || throttled.Until != now.Add(2*loginBaseDelay) {
+		t.Fatalf("Check() after second delayed failure = %v", err)
+	}
+
+	for i := accountFreeAttempts + 2; i < lockoutThreshold; i++ {
+		now = now.Add(time.Minute)
+		limiter.Failed("10.0.0.3", "alice")
+	}
+	if err := limiter.Check("10.0.0.4", "alice"); !errors.As(err, &throttled) || !throttled.Locked {
+		t.Fatalf("Check() after lockout threshold = %v", err)
+	}
+	if len(recorder.events) != 1 || recorder.events[0].Action != auditUserLock || recorder.events[0].Target != "alice" {
+		t.Errorf("recorded %+v, want the account being locked", recorder.events)
+	}
+
+	locked := limiter.LockedAccounts()
+	if len(locked) != 1 || locked[0].Username != "alice" || !locked[0].Locked || locked[0].Failures != lockoutThreshold {
+		t.Fatalf("LockedAccounts() = %+v", locked)
+	}
+
+	limiter.Unlock("ALICE")
+	if err := limiter.Check("10.0.0.4", "alice"); err != nil {
+		t.Errorf("Check() after unlock = %v", err)
+	}
+
+	// Addresses are limited across accounts, and aren't reset by a successful login
+	for i := 0; i <= ipFreeAttempts; i++ {
+		limiter.Failed("10.0.0.9", "user"+string(rune('a'+i)))
+	}
+	limiter.Succeeded("10.0.0.9", "mallory")
+	if err := limiter.Check("10.0.0.9", "carol"); !errors.As(err, &throttled) || throttled.Locked {
+		t.Errorf("Check() from address over its limit = %v", err)
+	}
+
+	// Failures are eventually forgotten
+	now = now.Add(2 * failureExpiry)
+	limiter.Failed("10.0.0.10", "dave")
+	if len(limiter.ips) != 1 || len(limiter.accounts) != 1 {
+		t.Errorf("old failures were not pruned: %d addresses, %d accounts", len(limiter.ips), len(limiter.accounts))
+	}
+}
+
+func TestLoginLimiter_Attempt(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	limiter := NewLoginLimiter(nil)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i <= ipFreeAttempts; i++ {
+		if err := limiter.Attempt("10.0.0.1"); err != nil {
+			t.Fatalf("attempt %d refused: %v", i+1, err)
+		}
+	}
+
+	var throttled *LoginThrottledError
+	if err := limiter.Attempt("10.0.0.1"); !errors.As(err, &throttled) || throttled.Until != now.Add(loginBaseDelay) {
+		t.Fatalf("Attempt() after free attempts = %v", err)
+	}
+	if err := limiter.Attempt("10.0.0.2"); err != nil {
+		t.Errorf("other address refused: %v", err)
+	}
+	if err := limiter.Check("10.0.0.3", "alice"); err != nil {
+		t.Errorf("Check() for an account refused after attempts: %v", err)
+	}
+
+	now = now.Add(loginBaseDelay)
+	if err := limiter.Attempt("10.0.0.1"); err != nil {
+		t.Errorf("attempt after waiting refused: %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{100, loginMaxDelay},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.failures, 3); got != tt.want {
+			t.Errorf("backoff(%d, 3) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
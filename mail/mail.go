@@ -0,0 +1,100 @@
+// Package mail sends plain text email over SMTP.
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server is an SMTP server that email can be sent through.
+type Server struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Message is a plain text email.
+type Message struct {
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Send delivers the message using the given server. Authentication is only attempted if the server has a username,
+// and STARTTLS is used whenever the server offers it.
+func Send(server Server, msg *Message) error {
+	if server.Host == "" || server.From == "" {
+		return fmt.Errorf("mail server is not configured")
+	}
+
+	if len(msg.To) == 0 {
+		return fmt.Errorf("message has no recipients")
+	}
+
+	from, err := mail.ParseAddress(server.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+
+	var auth smtp.Auth
+	if server.Username != "" {
+		auth = smtp.PlainAuth("", server.Username, server.Password, server.Host)
+	}
+
+	body, err := format(from, msg)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(server.Host, strconv.Itoa(server.Port))
+	return smtp.SendMail(addr, auth, from.Address, msg.To, body)
+}
+
+func format(from *mail.Address, msg *Message) ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	domain := "localhost"
+	if i := strings.LastIndex(from.Address, "@"); i != -1 {
+		domain = from.Address[i+1:]
+	}
+
+	buf := &bytes.Buffer{}
+	writeHeader := func(name, value string) {
+		buf.WriteString(name)
+		buf.WriteString(": ")
+		buf.WriteString(value)
+		buf.WriteString("\r\n")
+	}
+
+	writeHeader("From", from.String())
+	writeHeader("To", strings.Join(msg.To, ", "))
+	writeHeader("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	writeHeader("Date", time.Now().Format(time.RFC1123Z))
+	writeHeader("Message-ID", fmt.Sprintf("<%s@%s>", hex.EncodeToString(id), domain))
+	writeHeader("MIME-Version", "1.0")
+	writeHeader("Content-Type", "text/plain; charset=utf-8")
+	writeHeader("Content-Transfer-Encoding", "8bit")
+	buf.WriteString("\r\n")
+
+	// SMTP requires CRLF line endings, and lines starting with a dot are escaped by the smtp package
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if !strings.HasSuffix(body, "\n") {
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes(), nil
+}
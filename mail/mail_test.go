@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mdbot/wiki/mail/mailtest"
+)
+
+func TestSend(t *testing.T) {
+	server := mailtest.NewServer()
+	defer server.Close()
+
+	err := Send(Server{Host: server.Host(), Port: server.Port(), From: "Wiki <wiki@example.com>"}, &Message{
+		To:      []string{"alice@example.com"},
+		Subject: "Page changed",
+		Body:    "Line one\n.Line two\n",
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("Server received %d messages, want 1", len(messages))
+	}
+
+	msg := messages[0]
+	if msg.From != "wiki@example.com" || len(msg.To) != 1 || msg.To[0] != "alice@example.com" {
+		t.Errorf("Envelope = %s -> %v", msg.From, msg.To)
+	}
+	for _, want := range []string{"From: \"Wiki\" <wiki@example.com>\n", "Subject: Page changed\n", "\n\nLine one\n.Line two\n"} {
+		if !strings.Contains(msg.Data, want) {
+			t.Errorf("Message data does not contain %q:\n%s", want, msg.Data)
+		}
+	}
+}
+
+func TestSend_NotConfigured(t *testing.T) {
+	if err := Send(Server{}, &Message{To: []string{"alice@example.com"}}); err == nil {
+		t.Error("Send() with no server should fail")
+	}
+}
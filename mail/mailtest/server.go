@@ -0,0 +1,180 @@
+// Package mailtest provides a minimal SMTP server for use in tests.
+package mailtest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Message is an email received by the server.
+type Message struct {
+	From string
+	To   []string
+	// Data is the raw message, including headers, with line endings normalised to "\n".
+	Data string
+}
+
+// Server accepts every message sent to it without authentication, and records it. It doesn't support STARTTLS.
+type Server struct {
+	listener net.Listener
+
+	mutex    sync.Mutex
+	messages []Message
+	received chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a new SMTP server on a random local port. Callers should call Close when finished.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("mailtest: failed to listen: %v", err))
+	}
+
+	s := &Server{
+		listener: listener,
+		received: make(chan struct{}, 100),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Host returns the host the server is listening on.
+func (s *Server) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port returns the port the server is listening on.
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Messages returns the messages received so far.
+func (s *Server) Messages() []Message {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]Message(nil), s.messages...)
+}
+
+// Received returns a channel that receives a value each time a message is accepted.
+func (s *Server) Received() <-chan struct{} {
+	return s.received
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		_, _ = fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+
+	reply("220 mailtest ESMTP")
+
+	var current Message
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			reply("250-mailtest")
+			reply("250 8BITMIME")
+		case "HELO":
+			reply("250 mailtest")
+		case "MAIL":
+			current = Message{From: address(arg)}
+			reply("250 OK")
+		case "RCPT":
+			current.To = append(current.To, address(arg))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data, err := readData(reader)
+			if err != nil {
+				return
+			}
+			current.Data = data
+
+			s.mutex.Lock()
+			s.messages = append(s.messages, current)
+			s.mutex.Unlock()
+
+			select {
+			case s.received <- struct{}{}:
+			default:
+			}
+
+			current = Message{}
+			reply("250 OK")
+		case "RSET":
+			current = Message{}
+			reply("250 OK")
+		case "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// address extracts the address from a MAIL FROM or RCPT TO argument.
+func address(arg string) string {
+	start := strings.Index(arg, "<")
+	end := strings.LastIndex(arg, ">")
+	if start == -1 || end < start {
+		return arg
+	}
+	return arg[start+1 : end]
+}
+
+func readData(reader *bufio.Reader) (string, error) {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "." {
+			return strings.Join(lines, "\n") + "\n", nil
+		}
+		lines = append(lines, strings.TrimPrefix(line, "."))
+	}
+}
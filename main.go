@@ -11,6 +11,7 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -21,7 +22,11 @@ import (
 	"github.com/gorilla/sessions"
 	"github.com/kouhin/envflag"
 	"github.com/mdbot/wiki/config"
+	"github.com/mdbot/wiki/ldap"
+	"github.com/mdbot/wiki/mail"
 	"github.com/mdbot/wiki/markdown"
+	"github.com/mdbot/wiki/search"
+	"github.com/mdbot/wiki/webhook"
 )
 
 //go:embed resources/static resources/templates resources/content/*
@@ -34,15 +39,42 @@ var templateFiles fs.FS
 var version string
 
 var workDir = flag.String("workdir", "./data", "Working directory")
+var stateDir = flag.String("statedir", "./state", "Directory to store state such as the search index, outside of the wiki's repository")
 var username = flag.String("username", "", "username for initial account")
 var password = flag.String("password", "", "password for initial account")
 var mainPage = flag.String("mainpage", "MainPage", "Title of the main page for the wiki")
 var codeStyle = flag.String("codestyle", "monokai", "Style to use for code highlighting. See https://github.com/alecthomas/chroma/tree/master/styles")
 var httpPort = flag.Int("httpport", 8080, "HTTP server port")
 var configKey = flag.String("key", "", "Key to use to encrypt config data (32 byes, hex encoded, e.g. from `openssl rand -hex 32`)")
+var configExtraKeys = flag.String("extra-keys", "", "Comma separated keys that config data may also be encrypted with, such as the previous key when changing it")
+var rotateKey = flag.Bool("rotate-key", false, "Whether to re-encrypt all config data with -key at startup, so the extra keys are no longer needed")
 var requireAuthForWrites = flag.Bool("authenticated-writes", true, "Whether to require authentication to make changes to pages/files")
 var requireAuthForReads = flag.Bool("authenticated-reads", false, "Whether to require authentication to read pages/files")
 var dangerousHtml = flag.Bool("allow-dangerous-html", false, "Whether to allow dangerous HTML such as script tags")
+var gitHttp = flag.Bool("git-http", false, "Whether to allow cloning and pushing to the wiki's repository over HTTP at /repo.git")
+var remoteUrl = flag.String("remote", "", "URL of a git repository to push changes to and pull changes from")
+var remoteInterval = flag.Duration("remote-interval", 5*time.Minute, "How often to pull changes from the remote repository, or 0 to only push after each change")
+var remotePushOnCommit = flag.Bool("remote-push-on-commit", true, "Whether to push to the remote repository after every change, rather than on the pull interval")
+var baseUrl = flag.String("url", "", "Public URL of the wiki, e.g. https://wiki.example.com (required for single sign-on)")
+var oidcIssuer = flag.String("oidc-issuer", "", "Issuer URL of an OpenID Connect provider to allow users to log in with")
+var oidcClientId = flag.String("oidc-client-id", "", "Client ID registered with the OpenID Connect provider")
+var oidcClientSecret = flag.String("oidc-client-secret", "", "Client secret registered with the OpenID Connect provider")
+var oidcUsernameClaim = flag.String("oidc-username-claim", "preferred_username", "ID token claim to use as the username for new single sign-on users")
+var oidcGroupsClaim = flag.String("oidc-groups-claim", "groups", "ID token claim listing the groups of single sign-on users")
+var oidcPermissions = flag.String("oidc-permissions", "", "Permissions granted to single sign-on users in each group, e.g. wiki-admins=admin,staff=write")
+var proxyUserHeader = flag.String("proxy-user-header", "", "Header set by an authenticating reverse proxy to the username, e.g. X-Forwarded-User")
+var proxyGroupsHeader = flag.String("proxy-groups-header", "", "Header set by an authenticating reverse proxy to a comma separated list of the user's groups, e.g. X-Forwarded-Groups")
+var trustedProxies = flag.String("trusted-proxies", "", "Comma separated addresses or CIDR ranges of reverse proxies whose user and X-Forwarded-For headers are trusted")
+var proxyPermissions = flag.String("proxy-permissions", "read", "Permissions granted to new users authenticated by a reverse proxy")
+var ldapUrl = flag.String("ldap-url", "", "URL of an LDAP directory to check the passwords of users without a local account against, e.g. ldaps://ldap.example.com")
+var ldapBindDn = flag.String("ldap-bind-dn", "", "DN to bind as when searching the LDAP directory for users (searches anonymously if not set)")
+var ldapBindPassword = flag.String("ldap-bind-password", "", "Password for the LDAP bind DN")
+var ldapBaseDn = flag.String("ldap-base-dn", "", "DN to search for users under in the LDAP directory, e.g. ou=people,dc=example,dc=com")
+var ldapUserFilter = flag.String("ldap-user-filter", "(uid=%s)", "LDAP filter to find a user's entry, with %s in place of their username")
+var ldapUsernameAttribute = flag.String("ldap-username-attribute", "uid", "LDAP attribute to use as the username for new users")
+var ldapGroupsAttribute = flag.String("ldap-groups-attribute", "memberOf", "LDAP attribute listing the groups of users")
+var ldapPermissions = flag.String("ldap-permissions", "", "Permissions granted to LDAP users in each group, e.g. wiki-admins=admin,staff=write")
+var ldapStartTls = flag.Bool("ldap-starttls", false, "Whether to use StartTLS when connecting to an ldap:// URL")
 
 func main() {
 	err := envflag.Parse()
@@ -61,7 +93,88 @@ func main() {
 		log.Fatalf("Unable to open working directory: %s", err.Error())
 	}
 
-	configStore := config.NewStore(gitBackend, *configKey)
+	if flag.NArg() > 0 {
+		configStore := openConfigStore(gitBackend)
+		if _, ok := configStore.(*config.MemoryStore); ok {
+			log.Fatal("An encryption key is required to change settings from the command line")
+		}
+
+		auditLog, err := OpenAuditLog(filepath.Join(*stateDir, "audit.jsonl"))
+		if err != nil {
+			log.Fatalf("Unable to open audit log: %v", err)
+		}
+
+		err = runCommand(flag.Args(), configStore, gitBackend, auditLog, os.Stdin, os.Stdout)
+		_ = auditLog.Close()
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	searchIndex, err := search.Open(filepath.Join(*stateDir, "search.idx"))
+	if err != nil {
+		log.Fatalf("Unable to open search index: %v", err)
+	}
+
+	if err := gitBackend.IndexPages(searchIndex); err != nil {
+		log.Fatalf("Unable to update search index: %v", err)
+	}
+
+	go searchIndex.SaveEvery(time.Minute)
+
+	linkGraph := NewLinkGraph()
+	if err := gitBackend.AddPageIndex(linkGraph); err != nil {
+		log.Fatalf("Unable to build link graph: %v", err)
+	}
+
+	var remoteSync *RemoteSync
+	if *remoteUrl != "" {
+		if *remoteInterval < 0 {
+			log.Fatalf("Invalid remote interval: %s", *remoteInterval)
+		}
+
+		remoteSync, err = NewRemoteSync(gitBackend, *remoteUrl, *remoteInterval, *remotePushOnCommit)
+		if err != nil {
+			log.Fatalf("Unable to configure remote repository: %v", err)
+		}
+
+		// Pull before anything else, so that we don't diverge by creating default config or pages.
+		if err := remoteSync.Sync(); err != nil {
+			log.Printf("Unable to sync with remote repository: %v", err)
+		}
+
+		go remoteSync.Run()
+	}
+
+	configStore := openConfigStore(gitBackend)
+	_, settingsInMemory := configStore.(*config.MemoryStore)
+	if settingsInMemory {
+		// Starting with empty settings would ignore the existing users, access control rules and so on
+		if names, err := gitBackend.ConfigNames(); err != nil {
+			log.Fatalf("Unable to list saved settings: %v", err)
+		} else if len(names) > 0 {
+			log.Fatalf("Refusing to start without an encryption key, as the wiki has saved settings: %s", strings.Join(names, ", "))
+		}
+
+		log.Print("WARNING: No encryption key specified. Settings, including user accounts, will only be kept in memory and will be lost when the wiki stops!")
+	}
+
+	if *rotateKey {
+		encryptedStore, ok := configStore.(*config.EncryptedStore)
+		if !ok {
+			log.Fatal("Refusing to re-encrypt config without an encryption key")
+		}
+
+		count, err := encryptedStore.Rotate("System")
+		if err != nil {
+			log.Fatalf("Unable to re-encrypt config: %v", err)
+		} else if count > 0 {
+			log.Printf("Re-encrypted %d config files with the new key", count)
+		}
+	}
+
 	userManager, err := config.NewUserManager(configStore)
 	if err != nil {
 		log.Fatalf("Unable to create user manager: %v", err.Error())
@@ -85,18 +198,104 @@ func main() {
 		log.Fatalf("Unable to load site config: %v", err)
 	}
 
+	acl, err := config.LoadAcl(configStore)
+	if err != nil {
+		log.Fatalf("Unable to load access control rules: %v", err)
+	}
+
+	security, err := config.LoadSecurity(configStore)
+	if err != nil {
+		log.Fatalf("Unable to load security settings: %v", err)
+	}
+
+	webhooks, err := config.LoadWebhooks(configStore)
+	if err != nil {
+		log.Fatalf("Unable to load webhooks: %v", err)
+	}
+
+	webhookDispatcher := webhook.NewDispatcher()
+	gitBackend.AddCommitHook(NotifyWebhooks(webhooks, webhookDispatcher))
+
 	pm := &PermissionChecker{
 		requireAuthForWrites: *requireAuthForWrites,
 		requireAuthForReads:  *requireAuthForReads,
+		acl:                  acl,
 	}
 
+	mailSettings, err := config.LoadMail(configStore)
+	if err != nil {
+		log.Fatalf("Unable to load mail settings: %v", err)
+	}
+
+	watchNotifier := NewWatchNotifier(userManager, mailSettings, gitBackend, pm, siteConfig, *baseUrl, mail.Send)
+	gitBackend.AddCommitHook(watchNotifier.Notify)
+	go watchNotifier.SendDigestsEvery(24 * time.Hour)
+
+	passwordResetMailer := NewPasswordResetMailer(mailSettings, siteConfig, *baseUrl, mail.Send)
+
 	sessionStore := sessions.NewCookieStore(secrets.SessionKey)
+	sessionTracker, err := OpenSessionTracker(filepath.Join(*stateDir, "sessions.json"))
+	if err != nil {
+		log.Fatalf("Unable to load sessions: %v", err)
+	}
+
+	go sessionTracker.SaveEvery(time.Minute)
+
+	auditLog, err := OpenAuditLog(filepath.Join(*stateDir, "audit.jsonl"))
+	if err != nil {
+		log.Fatalf("Unable to open audit log: %v", err)
+	}
+
+	loginLimiter := NewLoginLimiter(auditLog)
+	signupLimiter := NewLoginLimiter(nil)
+
+	var oidcLogin *OidcLogin
+	var sessionEnder SessionEnder
+	if *oidcIssuer != "" {
+		oidcLogin, err = NewOidcLogin(sessionStore, *baseUrl, *oidcIssuer, *oidcClientId, *oidcClientSecret, *oidcUsernameClaim, *oidcGroupsClaim, *oidcPermissions)
+		if err != nil {
+			log.Fatalf("Unable to configure single sign-on: %v", err)
+		}
+		sessionEnder = oidcLogin
+	}
+
+	var proxyAuth *ProxyAuth
+	if *proxyUserHeader != "" {
+		proxyAuth, err = NewProxyAuth(userManager, *proxyUserHeader, *proxyGroupsHeader, *trustedProxies, *proxyPermissions)
+		if err != nil {
+			log.Fatalf("Unable to configure reverse proxy authentication: %v", err)
+		}
+	}
+
+	if *ldapUrl != "" {
+		ldapAuth, err := NewLdapAuth(ldap.Config{
+			URL:               *ldapUrl,
+			BindDN:            *ldapBindDn,
+			BindPassword:      *ldapBindPassword,
+			BaseDN:            *ldapBaseDn,
+			UserFilter:        *ldapUserFilter,
+			UsernameAttribute: *ldapUsernameAttribute,
+			GroupsAttribute:   *ldapGroupsAttribute,
+			StartTLS:          *ldapStartTls,
+		}, *ldapPermissions)
+		if err != nil {
+			log.Fatalf("Unable to configure LDAP authentication: %v", err)
+		}
+		userManager.SetPasswordAuthenticator(ldapAuth)
+	}
+
 	renderer := markdown.NewRenderer(gitBackend, *dangerousHtml, *codeStyle)
 	templates := &Templates{
-		fs:         templateFiles,
-		siteConfig: siteConfig,
-		checker:    pm,
-		version:    version,
+		fs:               templateFiles,
+		siteConfig:       siteConfig,
+		checker:          pm,
+		singleSignOn:     oidcLogin != nil,
+		settingsInMemory: settingsInMemory,
+		passwordReset:    passwordResetMailer.Available,
+		registrationOpen: func() bool {
+			return userManager.RegistrationPolicy().Open
+		},
+		version: version,
 		sidebarProvider: func() string {
 			p, err := gitBackend.GetPage("_sidebar")
 			if err != nil {
@@ -117,47 +316,107 @@ func main() {
 	wikiRouter := mux.NewRouter()
 	wikiRouter.Use(LowerCaseCanonical)
 
-	wikiRouter.PathPrefix("/edit/").Handler(pm.RequireWrite(EditPageHandler(templates, gitBackend))).Methods(http.MethodGet)
-	wikiRouter.PathPrefix("/edit/").Handler(pm.RequireWrite(SubmitPageHandler(gitBackend))).Methods(http.MethodPost)
-	wikiRouter.PathPrefix("/view/").Handler(pm.RequireRead(ViewPageHandler(templates, renderer, gitBackend))).Methods(http.MethodGet)
-	wikiRouter.PathPrefix("/history/").Handler(pm.RequireRead(PageHistoryHandler(templates, gitBackend))).Methods(http.MethodGet)
-	wikiRouter.PathPrefix("/files/view/").Handler(pm.RequireRead(FileHandler(gitBackend))).Methods(http.MethodGet)
-	wikiRouter.PathPrefix("/files/delete/").Handler(pm.RequireWrite(DeleteFileConfirmHandler(templates))).Methods(http.MethodGet)
-	wikiRouter.PathPrefix("/files/delete/").Handler(pm.RequireWrite(DeleteFileHandler(gitBackend))).Methods(http.MethodPost)
-	wikiRouter.PathPrefix("/delete/").Handler(pm.RequireWrite(DeletePageConfirmHandler(templates))).Methods(http.MethodGet)
-	wikiRouter.PathPrefix("/delete/").Handler(pm.RequireWrite(DeletePageHandler(gitBackend))).Methods(http.MethodPost)
-	wikiRouter.PathPrefix("/rename/").Handler(pm.RequireWrite(RenamePageConfirmHandler(gitBackend, templates))).Methods(http.MethodGet)
-	wikiRouter.PathPrefix("/rename/").Handler(pm.RequireWrite(RenamePageHandler(gitBackend))).Methods(http.MethodPost)
-	wikiRouter.PathPrefix("/revert/").Handler(pm.RequireWrite(RevertPageConfirmHandler(templates))).Methods(http.MethodGet)
-	wikiRouter.PathPrefix("/revert/").Handler(pm.RequireWrite(RevertPageHandler(gitBackend))).Methods(http.MethodPost)
-	wikiRouter.PathPrefix("/diff/").Handler(pm.RequireRead(DiffPageHandler(templates, gitBackend))).Methods(http.MethodGet)
-	wikiRouter.Path("/api/list").Handler(pm.RequireRead(ApiListHandler(gitBackend))).Methods(http.MethodGet)
-	wikiRouter.Path("/wiki/account").Handler(pm.RequireAccount(AccountHandler(templates))).Methods(http.MethodGet)
-	wikiRouter.Path("/wiki/account").Handler(pm.RequireAccount(ModifyAccountHandler(userManager))).Methods(http.MethodPost)
-	wikiRouter.Path("/wiki/index").Handler(pm.RequireRead(ListPagesHandler(templates, gitBackend))).Methods(http.MethodGet)
-	wikiRouter.Path("/wiki/files").Handler(pm.RequireRead(ListFilesHandler(templates, gitBackend))).Methods(http.MethodGet)
-	wikiRouter.Path("/wiki/changes").Handler(pm.RequireRead(RecentChangesHandler(templates, gitBackend))).Methods(http.MethodGet)
-	wikiRouter.Path("/wiki/changes.xml").Handler(pm.RequireRead(RecentChangesFeed(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/edit/").Handler(pm.RequireWritePath("/edit/", EditPageHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/edit/").Handler(pm.RequireWritePath("/edit/", SubmitPageHandler(templates, gitBackend))).Methods(http.MethodPost)
+	wikiRouter.PathPrefix("/view/").Handler(pm.RequireReadPath("/view/", ViewPageHandler(templates, renderer, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/history/").Handler(pm.RequireReadPath("/history/", PageHistoryHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/files/view/").Handler(pm.RequireReadPath("/files/view/", FileHandler(gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/files/delete/").Handler(pm.RequireWritePath("/files/delete/", DeleteFileConfirmHandler(templates, linkGraph, pm))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/files/delete/").Handler(pm.RequireWritePath("/files/delete/", DeleteFileHandler(gitBackend))).Methods(http.MethodPost)
+	wikiRouter.PathPrefix("/delete/").Handler(pm.RequireWritePath("/delete/", DeletePageConfirmHandler(templates, linkGraph, pm))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/delete/").Handler(pm.RequireWritePath("/delete/", DeletePageHandler(gitBackend))).Methods(http.MethodPost)
+	wikiRouter.PathPrefix("/rename/").Handler(pm.RequireWritePath("/rename/", RenamePageConfirmHandler(gitBackend, linkGraph, pm, templates))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/rename/").Handler(pm.RequireWritePath("/rename/", RenamePageHandler(gitBackend, linkGraph, pm))).Methods(http.MethodPost)
+	wikiRouter.PathPrefix("/revert/").Handler(pm.RequireWritePath("/revert/", RevertPageConfirmHandler(templates))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/revert/").Handler(pm.RequireWritePath("/revert/", RevertPageHandler(gitBackend))).Methods(http.MethodPost)
+	wikiRouter.PathPrefix("/links/").Handler(pm.RequireReadPath("/links/", LinksHandler(templates, linkGraph, pm))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/diff/").Handler(pm.RequireReadPath("/diff/", DiffPageHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix("/api/links/").Handler(pm.RequireReadPath("/api/links/", ApiLinksHandler(linkGraph, pm))).Methods(http.MethodGet)
+	wikiRouter.Path("/api/list").Handler(pm.RequireRead(ApiListHandler(gitBackend, pm))).Methods(http.MethodGet)
+	wikiRouter.Path("/api/v1/pages").Handler(pm.RequireRead(ApiListPagesHandler(gitBackend, pm))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix(apiPagesPrefix).Handler(pm.RequireReadPath(apiPagesPrefix, ApiGetPageHandler(gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix(apiPagesPrefix).Handler(pm.RequireWritePath(apiPagesPrefix, ApiPutPageHandler(gitBackend))).Methods(http.MethodPut)
+	wikiRouter.PathPrefix(apiPagesPrefix).Handler(pm.RequireWritePath(apiPagesPrefix, ApiDeletePageHandler(gitBackend))).Methods(http.MethodDelete)
+	wikiRouter.PathPrefix(apiRenamePrefix).Handler(pm.RequireWritePath(apiRenamePrefix, ApiRenamePageHandler(gitBackend, linkGraph, pm))).Methods(http.MethodPost)
+	wikiRouter.PathPrefix(apiRevertPrefix).Handler(pm.RequireWritePath(apiRevertPrefix, ApiRevertPageHandler(gitBackend))).Methods(http.MethodPost)
+	wikiRouter.PathPrefix(apiHistoryPrefix).Handler(pm.RequireReadPath(apiHistoryPrefix, ApiPageHistoryHandler(gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix(apiDiffPrefix).Handler(pm.RequireReadPath(apiDiffPrefix, ApiDiffHandler(gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/api/v1/changes").Handler(pm.RequireRead(ApiRecentChangesHandler(gitBackend, pm))).Methods(http.MethodGet)
+	wikiRouter.Path("/api/v1/files").Handler(pm.RequireRead(ApiListFilesHandler(gitBackend, pm))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix(apiFilesPrefix).Handler(pm.RequireReadPath(apiFilesPrefix, ApiGetFileHandler(gitBackend))).Methods(http.MethodGet)
+	wikiRouter.PathPrefix(apiFilesPrefix).Handler(pm.RequireWritePath(apiFilesPrefix, ApiPutFileHandler(gitBackend))).Methods(http.MethodPut)
+	wikiRouter.PathPrefix(apiFilesPrefix).Handler(pm.RequireWritePath(apiFilesPrefix, ApiDeleteFileHandler(gitBackend))).Methods(http.MethodDelete)
+	wikiRouter.Path("/api/v1/search").Handler(pm.RequireRead(ApiSearchHandler(searchIndex, pm))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/account").Handler(RequireSession(AccountHandler(templates, sessionTracker))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/account").Handler(RequireSession(ModifyAccountHandler(templates, userManager, sessionTracker, security))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/index").Handler(pm.RequireRead(ListPagesHandler(templates, gitBackend, pm))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/files").Handler(pm.RequireRead(ListFilesHandler(templates, gitBackend, pm))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/changes").Handler(pm.RequireRead(RecentChangesHandler(templates, gitBackend, pm))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/changes.xml").Handler(pm.RequireRead(RecentChangesFeed(templates, gitBackend, pm))).Methods(http.MethodGet)
 	wikiRouter.Path("/wiki/logo/favicon").Handler(ServeFavicon(siteConfig)).Methods(http.MethodGet)
 	wikiRouter.Path("/wiki/logo/main").Handler(ServeMainLogo(siteConfig)).Methods(http.MethodGet)
 	wikiRouter.Path("/wiki/logo/dark").Handler(ServeDarkLogo(siteConfig)).Methods(http.MethodGet)
-	wikiRouter.Path("/wiki/login").Handler(LoginHandler(userManager)).Methods(http.MethodPost)
-	wikiRouter.Path("/wiki/logout").Handler(LogoutHandler()).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/login").Handler(LoginHandler(userManager, loginLimiter)).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/forgot").Handler(ForgotPasswordHandler(templates, passwordResetMailer)).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/forgot").Handler(RequestPasswordResetHandler(userManager, passwordResetMailer)).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/reset").Handler(ResetPasswordFormHandler(templates, userManager)).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/reset").Handler(ResetPasswordHandler(userManager, sessionTracker)).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/signup").Handler(SignupFormHandler(templates, userManager)).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/signup").Handler(SignupHandler(userManager, signupLimiter)).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/login/totp").Handler(TotpLoginFormHandler(templates)).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/login/totp").Handler(TotpLoginHandler(userManager, loginLimiter)).Methods(http.MethodPost)
+	if oidcLogin != nil {
+		wikiRouter.Path("/wiki/sso/login").Handler(OidcLoginHandler(oidcLogin)).Methods(http.MethodGet)
+		wikiRouter.Path(oidcCallbackPath).Handler(OidcCallbackHandler(oidcLogin, userManager)).Methods(http.MethodGet)
+	}
+	wikiRouter.Path("/wiki/logout").Handler(LogoutHandler(sessionEnder)).Methods(http.MethodPost)
 	wikiRouter.Path("/wiki/upload").Handler(pm.RequireWrite(UploadFormHandler(templates))).Methods(http.MethodGet)
-	wikiRouter.Path("/wiki/upload").Handler(pm.RequireWrite(UploadHandler(gitBackend))).Methods(http.MethodPost)
-	wikiRouter.Path("/wiki/search").Handler(pm.RequireRead(SearchHandler(templates, gitBackend))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/upload").Handler(pm.RequireWrite(UploadHandler(gitBackend, pm))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/search").Handler(pm.RequireRead(SearchHandler(templates, searchIndex, pm))).Methods(http.MethodGet)
 	wikiRouter.Path("/wiki/site").Handler(pm.RequireAdmin(ViewSiteConfigHandler(templates))).Methods(http.MethodGet)
 	wikiRouter.Path("/wiki/site").Handler(pm.RequireAdmin(UpdateSiteConfigHandler(siteConfig))).Methods(http.MethodPost)
-	wikiRouter.Path("/wiki/users").Handler(pm.RequireAdmin(ManageUsersHandler(templates, userManager))).Methods(http.MethodGet)
-	wikiRouter.Path("/wiki/users").Handler(pm.RequireAdmin(ModifyUserHandler(userManager))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/users").Handler(pm.RequireAdmin(ManageUsersHandler(templates, userManager, sessionTracker))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/users").Handler(pm.RequireAdmin(ModifyUserHandler(userManager, sessionTracker))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/registration").Handler(pm.RequireAdmin(RegistrationHandler(templates, userManager))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/registration").Handler(pm.RequireAdmin(ModifyRegistrationHandler(templates, userManager, *baseUrl))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/acl").Handler(pm.RequireAdmin(AclHandler(templates, acl))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/acl").Handler(pm.RequireAdmin(UpdateAclHandler(acl))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/security").Handler(pm.RequireAdmin(SecurityHandler(templates, security, loginLimiter))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/security").Handler(pm.RequireAdmin(UpdateSecurityHandler(security, loginLimiter))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/audit").Handler(pm.RequireAdmin(AuditHandler(templates, auditLog))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/audit.json").Handler(pm.RequireAdmin(AuditExportHandler(auditLog))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/webhooks").Handler(pm.RequireAdmin(WebhooksHandler(templates, webhooks, webhookDispatcher))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/webhooks").Handler(pm.RequireAdmin(ModifyWebhooksHandler(webhooks, webhookDispatcher))).Methods(http.MethodPost)
+	wikiRouter.Path("/wiki/email").Handler(pm.RequireAdmin(MailSettingsHandler(templates, mailSettings))).Methods(http.MethodGet)
+	wikiRouter.Path("/wiki/email").Handler(pm.RequireAdmin(UpdateMailSettingsHandler(mailSettings, watchNotifier))).Methods(http.MethodPost)
+
+	if remoteSync != nil {
+		wikiRouter.Path("/wiki/remote").Handler(pm.RequireAdmin(RemoteSyncStatusHandler(templates, remoteSync))).Methods(http.MethodGet)
+		wikiRouter.Path("/wiki/remote").Handler(pm.RequireAdmin(RemoteSyncHandler(remoteSync))).Methods(http.MethodPost)
+	}
+
+	proxies, err := parseCIDRs(*trustedProxies)
+	if err != nil {
+		log.Fatalf("Unable to parse trusted proxies: %v", err)
+	}
+
+	root := mux.NewRouter()
+	root.Use(ForwardedFor(proxies))
+	if *gitHttp {
+		// Git clients can't deal with sessions or CSRF tokens, so serve them outside the main router
+		root.PathPrefix(gitPathPrefix).Handler(LoggingHandler(os.Stdout)(RecordAuditEvents(auditLog)(GitHandler(gitBackend, userManager, loginLimiter, pm, security))))
+	}
 
-	router := mux.NewRouter()
+	router := root.NewRoute().Subrouter()
 
-	router.Use(csrf.Protect(secrets.CsrfKey, csrf.SameSite(csrf.SameSiteStrictMode), csrf.Path("/")))
-	router.Use(SessionHandler(userManager, sessionStore))
+	router.Use(RecordAuditEvents(auditLog))
+	router.Use(SessionHandler(userManager, sessionStore, sessionTracker, proxyAuth))
+	router.Use(csrf.Protect(secrets.CsrfKey, csrf.SameSite(csrf.SameSiteStrictMode), csrf.Path("/"), csrf.ErrorHandler(http.HandlerFunc(CsrfErrorHandler))))
 	router.Use(LoggingHandler(os.Stdout))
 	router.Use(PageErrorHandler(templates))
+	router.Use(ApiErrorHandler)
 	router.Use(StripSlashes)
+	router.Use(RequireTwoFactorEnrolment(security))
 
 	router.Path("/").Handler(RedirectMainPageHandler())
 	router.Path("/view/").Handler(RedirectMainPageHandler())
@@ -167,7 +426,7 @@ func main() {
 	log.Print("Starting server.")
 	server := http.Server{
 		Addr:    fmt.Sprintf(":%d", *httpPort),
-		Handler: router,
+		Handler: root,
 	}
 	go func() {
 		_ = server.ListenAndServe()
@@ -180,6 +439,18 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Unable to shutdown: %s", err.Error())
 	}
+	if err := searchIndex.Save(); err != nil {
+		log.Printf("Unable to save search index: %v", err)
+	}
+	if err := sessionTracker.Save(); err != nil {
+		log.Printf("Unable to save sessions: %v", err)
+	}
+	watchNotifier.Wait()
+	passwordResetMailer.Wait()
+	if err := auditLog.Close(); err != nil {
+		log.Printf("Unable to close audit log: %v", err)
+	}
+	watchNotifier.SendDigests()
 	log.Print("Finishing server.")
 }
 
@@ -213,7 +484,7 @@ func createDefaultPages(b *GitBackend) error {
 					return err
 				}
 
-				if err := b.PutPage(name, bs, "system", "Creating default page"); err != nil {
+				if err := b.PutPage(name, "", bs, "system", "Creating default page"); err != nil {
 					return err
 				}
 			}
@@ -222,3 +493,17 @@ func createDefaultPages(b *GitBackend) error {
 
 	return nil
 }
+
+// openConfigStore creates the store for the wiki's settings, using the encryption keys given in the flags.
+func openConfigStore(backend config.Backend) config.Store {
+	var extraKeys []string
+	if *configExtraKeys != "" {
+		extraKeys = strings.Split(*configExtraKeys, ",")
+	}
+
+	store, err := config.NewStore(backend, *configKey, extraKeys...)
+	if err != nil {
+		log.Fatalf("Unable to use encryption keys: %v", err)
+	}
+	return store
+}
@@ -45,7 +45,7 @@ func (w *embedParser) Parse(_ ast.Node, block text.Reader, _ parser.Context) ast
 	for m, v := range mimePrefixes {
 		if strings.HasPrefix(mimeType, m) {
 			block.Advance(endIndex + 2)
-			element := newMediaEmbed(v, fmt.Sprintf("/files/view/%s", target))
+			element := newMediaEmbed(v, string(target), fmt.Sprintf("/files/view/%s", target))
 			return element
 		}
 	}
@@ -80,15 +80,17 @@ func CanEmbed(mimeType string) bool {
 
 type mediaEmbed struct {
 	mediaType mediaType
+	target    string
 	file      string
 	ast.BaseBlock
 }
 
 var kindMediaEmbed = ast.NewNodeKind("MediaEmbed")
 
-func newMediaEmbed(m mediaType, file string) *mediaEmbed {
+func newMediaEmbed(m mediaType, target, file string) *mediaEmbed {
 	return &mediaEmbed{
 		mediaType: m,
+		target:    target,
 		file:      file,
 	}
 }
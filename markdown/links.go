@@ -0,0 +1,140 @@
+package markdown
+
+import (
+	"bytes"
+
+	mathjax "github.com/litao91/goldmark-mathjax"
+	attributes "github.com/mdigger/goldmark-attributes"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/text"
+)
+
+// Link is a wikilink or embed found in a page.
+type Link struct {
+	// Target is the page or file name as written in the link.
+	Target string
+	// Embed indicates the link is a media embed of a file, rather than a link to a page.
+	Embed bool
+}
+
+// alwaysExists is a PageChecker for when we only care about the structure of the document, not how it's rendered.
+type alwaysExists struct{}
+
+func (alwaysExists) PageExists(string) bool {
+	return true
+}
+
+// linkParser uses the same syntax extensions as the renderer, so that links are only found where they would be
+// rendered (and not, for example, inside code blocks).
+var linkParser = goldmark.New(
+	goldmark.WithExtensions(
+		mathjax.MathJax,
+		extension.GFM,
+		newWikiLinks(alwaysExists{}),
+		newEmbedExtension(),
+		attributes.Extension,
+	),
+)
+
+// ExtractLinks returns all the distinct wikilinks and embeds in the markdown, in the order they first appear.
+func ExtractLinks(markdown []byte) []Link {
+	doc := linkParser.Parser().Parse(text.NewReader(markdown))
+
+	var links []Link
+	seen := make(map[Link]bool)
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		var link Link
+		switch node := n.(type) {
+		case *ast.Link:
+			if !isWikiLink(node) {
+				return ast.WalkContinue, nil
+			}
+			link = Link{Target: string(node.Title)}
+		case *mediaEmbed:
+			link = Link{Target: node.target, Embed: true}
+		default:
+			return ast.WalkContinue, nil
+		}
+
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+		return ast.WalkContinue, nil
+	})
+	return links
+}
+
+func isWikiLink(link *ast.Link) bool {
+	class, ok := link.AttributeString("class")
+	if !ok {
+		return false
+	}
+	b, ok := class.([]byte)
+	return ok && bytes.HasPrefix(b, []byte("wikilink"))
+}
+
+// RewriteWikiLinks calls rewrite with the target of each wikilink in the markdown, and replaces the target with the
+// returned value if ok is true. Any label given to the link is left unchanged.
+func RewriteWikiLinks(markdown []byte, rewrite func(target string) (newTarget string, ok bool)) []byte {
+	doc := linkParser.Parser().Parse(text.NewReader(markdown))
+
+	type replacement struct {
+		start, end int
+		target     string
+	}
+	var replacements []replacement
+
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		link, ok := n.(*ast.Link)
+		if !entering || !ok || !isWikiLink(link) {
+			return ast.WalkContinue, nil
+		}
+
+		newTarget, ok := rewrite(string(link.Title))
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+
+		// The link's only child is the text shown for it. This is either the target itself, or for piped links
+		// the label which immediately follows the target and pipe.
+		label, ok := link.FirstChild().(*ast.Text)
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+
+		end := label.Segment.Start
+		if end > 0 && markdown[end-1] == '|' {
+			end--
+		} else {
+			end = label.Segment.Stop
+		}
+		start := end - len(link.Title)
+		if start < 0 || !bytes.Equal(markdown[start:end], link.Title) {
+			return ast.WalkContinue, nil
+		}
+
+		replacements = append(replacements, replacement{start: start, end: end, target: newTarget})
+		return ast.WalkContinue, nil
+	})
+
+	if len(replacements) == 0 {
+		return markdown
+	}
+
+	var res bytes.Buffer
+	pos := 0
+	for i := range replacements {
+		res.Write(markdown[pos:replacements[i].start])
+		res.WriteString(replacements[i].target)
+		pos = replacements[i].end
+	}
+	res.Write(markdown[pos:])
+	return res.Bytes()
+}
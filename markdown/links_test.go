@@ -0,0 +1,61 @@
+package markdown
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     []Link
+	}{
+		{"no links", "Just some *text*", nil},
+		{"wikilink", "See [[Other Page]].", []Link{{Target: "Other Page"}}},
+		{"piped wikilink", "See [[other|the other page]].", []Link{{Target: "other"}}},
+		{"duplicates", "[[a]] [[b]] [[a]]", []Link{{Target: "a"}, {Target: "b"}}},
+		{"embed", "![[images/cat.png]]", []Link{{Target: "images/cat.png", Embed: true}}},
+		{"regular links are ignored", "[text](/view/page) ![alt](/files/view/img.png)", nil},
+		{"code is ignored", "`[[a]]`\n\n```\n[[b]]\n```\n\n    [[c]]\n", nil},
+		{"nested in other elements", "* [[a]]\n\n> **[[b]]**\n\n| x |\n|---|\n| [[c]] |\n", []Link{{Target: "a"}, {Target: "b"}, {Target: "c"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractLinks([]byte(tt.markdown)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractLinks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteWikiLinks(t *testing.T) {
+	rewrite := func(target string) (string, bool) {
+		if target == "Old" || target == "old" {
+			return "New", true
+		}
+		return "", false
+	}
+
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{"no links", "Just some text about Old", "Just some text about Old"},
+		{"plain", "See [[Old]] and [[old]].", "See [[New]] and [[New]]."},
+		{"piped", "See [[Old|the old page]].", "See [[New|the old page]]."},
+		{"piped with target as label", "[[Old|Old]]", "[[New|Old]]"},
+		{"other links", "[[Older]] [[other|Old]] [[Old]]", "[[Older]] [[other|Old]] [[New]]"},
+		{"nested", "> * **[[Old]]**\n\n| [[Old]] |\n|---|\n", "> * **[[New]]**\n\n| [[New]] |\n|---|\n"},
+		{"code", "`[[Old]]`\n\n```\n[[Old]]\n```\n", "`[[Old]]`\n\n```\n[[Old]]\n```\n"},
+		{"embeds", "![[Old]]", "![[Old]]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RewriteWikiLinks([]byte(tt.markdown), rewrite); string(got) != tt.want {
+				t.Errorf("RewriteWikiLinks() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package markdown
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// redirectPattern matches a "#REDIRECT [[Target]]" directive at the start of a page. A label is allowed (and
+// ignored) for consistency with other wikilinks.
+var redirectPattern = regexp.MustCompile(`(?i)^\s*#redirect\s*\[\[([^\]|]+)(?:\|[^\]]*)?]]`)
+
+// ParseRedirect returns the target of the page if it is a redirect.
+func ParseRedirect(markdown []byte) (string, bool) {
+	match := redirectPattern.FindSubmatch(markdown)
+	if match == nil {
+		return "", false
+	}
+
+	target := strings.TrimSpace(string(match[1]))
+	return target, target != ""
+}
+
+// Redirect returns the content of a page that redirects to the given target.
+func Redirect(target string) []byte {
+	return []byte(fmt.Sprintf("#REDIRECT [[%s]]\n", target))
+}
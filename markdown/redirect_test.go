@@ -0,0 +1,29 @@
+package markdown
+
+import "testing"
+
+func TestParseRedirect(t *testing.T) {
+	tests := []struct {
+		name       string
+		markdown   string
+		wantTarget string
+		wantOk     bool
+	}{
+		{"redirect", "#REDIRECT [[Other Page]]", "Other Page", true},
+		{"generated", string(Redirect("new/name")), "new/name", true},
+		{"case and spacing", "\n  #redirect[[ other ]]\n\nOld content", "other", true},
+		{"label", "#REDIRECT [[other|label]]", "other", true},
+		{"anchor", "#REDIRECT [[other#section]]", "other#section", true},
+		{"not at start", "Text\n#REDIRECT [[other]]", "", false},
+		{"empty target", "#REDIRECT [[ ]]", "", false},
+		{"normal page", "# Redirects\n\nSee [[other]]", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, ok := ParseRedirect([]byte(tt.markdown))
+			if target != tt.wantTarget || ok != tt.wantOk {
+				t.Errorf("ParseRedirect() = %q, %v, want %q, %v", target, ok, tt.wantTarget, tt.wantOk)
+			}
+		})
+	}
+}
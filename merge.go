@@ -0,0 +1,195 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	conflictStartMarker  = "<<<<<<< your changes\n"
+	conflictMiddleMarker = "=======\n"
+	conflictEndMarker    = ">>>>>>> current version\n"
+)
+
+// hunk describes a change to a range of lines in a base document: the lines [start, end) are replaced by lines.
+type hunk struct {
+	start int
+	end   int
+	lines []string
+}
+
+// mergeLines performs a line-based three-way merge of two documents that were both derived from base. If the two
+// sets of changes overlap, the conflicting regions are surrounded by conflict markers and clean will be false.
+func mergeLines(base, ours, theirs string) (merged string, clean bool) {
+	baseLines := splitLines(base)
+	ourHunks := diffHunks(base, ours)
+	theirHunks := diffHunks(base, theirs)
+
+	var res strings.Builder
+	clean = true
+	pos := 0
+
+	for len(ourHunks) > 0 || len(theirHunks) > 0 {
+		// Start a new region with whichever hunk comes first, then keep absorbing hunks from either side for as
+		// long as they overlap (or touch) the region.
+		start, end := nextRegion(ourHunks, theirHunks)
+		var ourRegion, theirRegion []hunk
+		for {
+			var n int
+			n, end = overlapping(ourHunks, start, end)
+			ourRegion, ourHunks = append(ourRegion, ourHunks[:n]...), ourHunks[n:]
+
+			var m int
+			m, end = overlapping(theirHunks, start, end)
+			theirRegion, theirHunks = append(theirRegion, theirHunks[:m]...), theirHunks[m:]
+
+			if n == 0 && m == 0 {
+				break
+			}
+		}
+
+		res.WriteString(strings.Join(baseLines[pos:start], ""))
+		pos = end
+
+		original := strings.Join(baseLines[start:end], "")
+		ourVersion := applyHunks(baseLines, start, end, ourRegion)
+		theirVersion := applyHunks(baseLines, start, end, theirRegion)
+
+		if ourVersion == theirVersion || theirVersion == original {
+			res.WriteString(ourVersion)
+		} else if ourVersion == original {
+			res.WriteString(theirVersion)
+		} else {
+			clean = false
+			res.WriteString(conflictStartMarker)
+			res.WriteString(terminateLine(ourVersion))
+			res.WriteString(conflictMiddleMarker)
+			res.WriteString(terminateLine(theirVersion))
+			res.WriteString(conflictEndMarker)
+		}
+	}
+
+	res.WriteString(strings.Join(baseLines[pos:], ""))
+	return res.String(), clean
+}
+
+// nextRegion returns the initial bounds of the region started by the earliest of the two next hunks.
+func nextRegion(a, b []hunk) (int, int) {
+	if len(b) == 0 || (len(a) > 0 && a[0].start <= b[0].start) {
+		return a[0].start, a[0].end
+	}
+	return b[0].start, b[0].end
+}
+
+// overlapping returns the number of leading hunks that overlap or touch the region [start, end), and the new end of
+// the region once those hunks are included.
+func overlapping(hunks []hunk, start, end int) (int, int) {
+	n := 0
+	for n < len(hunks) && hunks[n].start <= end && hunks[n].end >= start {
+		if hunks[n].end > end {
+			end = hunks[n].end
+		}
+		n++
+	}
+	return n, end
+}
+
+// applyHunks returns the content of base[start:end] with the given hunks applied.
+func applyHunks(base []string, start, end int, hunks []hunk) string {
+	var res strings.Builder
+	pos := start
+	for i := range hunks {
+		res.WriteString(strings.Join(base[pos:hunks[i].start], ""))
+		res.WriteString(strings.Join(hunks[i].lines, ""))
+		pos = hunks[i].end
+	}
+	res.WriteString(strings.Join(base[pos:end], ""))
+	return res.String()
+}
+
+// diffHunks calculates the line-based changes required to turn base into other.
+func diffHunks(base, other string) []hunk {
+	a, b, lines := linesToRunes(splitLines(base), splitLines(other))
+	diffs := diffmatchpatch.New().DiffMainRunes(a, b, false)
+
+	var hunks []hunk
+	var current *hunk
+	pos := 0
+
+	for i := range diffs {
+		diffLines := []rune(diffs[i].Text)
+		if diffs[i].Type == diffmatchpatch.DiffEqual {
+			if current != nil {
+				hunks = append(hunks, *current)
+				current = nil
+			}
+			pos += len(diffLines)
+			continue
+		}
+
+		if current == nil {
+			current = &hunk{start: pos, end: pos}
+		}
+
+		if diffs[i].Type == diffmatchpatch.DiffDelete {
+			pos += len(diffLines)
+			current.end = pos
+		} else {
+			for j := range diffLines {
+				current.lines = append(current.lines, lines[diffLines[j]])
+			}
+		}
+	}
+
+	if current != nil {
+		hunks = append(hunks, *current)
+	}
+	return hunks
+}
+
+// linesToRunes encodes each distinct line as a single rune, so that a character diff of the results is a line diff of
+// the inputs. The returned map converts runes back to lines.
+func linesToRunes(a, b []string) ([]rune, []rune, map[rune]string) {
+	lines := make(map[rune]string)
+	seen := make(map[string]rune)
+
+	encode := func(in []string) []rune {
+		var res []rune
+		for i := range in {
+			r, ok := seen[in[i]]
+			if !ok {
+				r = rune(len(seen) + 1)
+				if r >= 0xD800 {
+					// Skip over the surrogate range, which can't survive a round trip through a string
+					r += 0x800
+				}
+				seen[in[i]] = r
+				lines[r] = in[i]
+			}
+			res = append(res, r)
+		}
+		return res
+	}
+
+	return encode(a), encode(b), lines
+}
+
+// splitLines splits the text into lines, retaining the line terminators.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func terminateLine(text string) string {
+	if text == "" || strings.HasSuffix(text, "\n") {
+		return text
+	}
+	return text + "\n"
+}
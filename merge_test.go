@@ -0,0 +1,122 @@
+package main
+
+import "testing"
+
+func Test_mergeLines(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		want      string
+		wantClean bool
+	}{
+		{
+			"no changes",
+			"a\nb\nc\n",
+			"a\nb\nc\n",
+			"a\nb\nc\n",
+			"a\nb\nc\n",
+			true,
+		},
+		{
+			"only our changes",
+			"a\nb\nc\n",
+			"a\nB\nc\n",
+			"a\nb\nc\n",
+			"a\nB\nc\n",
+			true,
+		},
+		{
+			"only their changes",
+			"a\nb\nc\n",
+			"a\nb\nc\n",
+			"a\nb\nC\n",
+			"a\nb\nC\n",
+			true,
+		},
+		{
+			"separate changes",
+			"a\nb\nc\nd\ne\n",
+			"A\nb\nc\nd\ne\n",
+			"a\nb\nc\nd\nE\n",
+			"A\nb\nc\nd\nE\n",
+			true,
+		},
+		{
+			"identical changes",
+			"a\nb\nc\n",
+			"a\nB\nc\n",
+			"a\nB\nc\n",
+			"a\nB\nc\n",
+			true,
+		},
+		{
+			"additions at either end",
+			"a\nb\nc\n",
+			"start\na\nb\nc\n",
+			"a\nb\nc\nend\n",
+			"start\na\nb\nc\nend\n",
+			true,
+		},
+		{
+			"deletion and separate edit",
+			"a\nb\nc\nd\ne\n",
+			"a\nc\nd\ne\n",
+			"a\nb\nc\nd\nE\n",
+			"a\nc\nd\nE\n",
+			true,
+		},
+		{
+			"conflicting edits",
+			"a\nb\nc\n",
+			"a\nours\nc\n",
+			"a\ntheirs\nc\n",
+			"a\n" + conflictStartMarker + "ours\n" + conflictMiddleMarker + "theirs\n" + conflictEndMarker + "c\n",
+			false,
+		},
+		{
+			"conflicting insertions at the same point",
+			"a\nb\n",
+			"a\nours\nb\n",
+			"a\ntheirs\nb\n",
+			"a\n" + conflictStartMarker + "ours\n" + conflictMiddleMarker + "theirs\n" + conflictEndMarker + "b\n",
+			false,
+		},
+		{
+			"edit conflicting with deletion",
+			"a\nb\nc\n",
+			"a\nours\nc\n",
+			"a\nc\n",
+			"a\n" + conflictStartMarker + "ours\n" + conflictMiddleMarker + conflictEndMarker + "c\n",
+			false,
+		},
+		{
+			"concurrent creation",
+			"",
+			"ours\n",
+			"theirs\n",
+			conflictStartMarker + "ours\n" + conflictMiddleMarker + "theirs\n" + conflictEndMarker,
+			false,
+		},
+		{
+			"missing trailing newline",
+			"a\nb",
+			"a\nours",
+			"a\ntheirs",
+			"a\n" + conflictStartMarker + "ours\n" + conflictMiddleMarker + "theirs\n" + conflictEndMarker,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, clean := mergeLines(tt.base, tt.ours, tt.theirs)
+			if clean != tt.wantClean {
+				t.Errorf("mergeLines() clean = %v, want %v", clean, tt.wantClean)
+			}
+			if got != tt.want {
+				t.Errorf("mergeLines() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
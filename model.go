@@ -6,6 +6,8 @@ type RecentChange struct {
 	Page   string
 	File   string
 	Config string
+	// Paths lists every page and file the change touched, as a change such as a rename may affect several.
+	Paths []string
 	LogEntry
 }
 
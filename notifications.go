@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdbot/wiki/config"
+	"github.com/mdbot/wiki/mail"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// maxExcerptLines is the number of changed lines included in a notification for each page.
+const maxExcerptLines = 20
+
+var changeDescriptions = map[ChangeType]string{
+	ChangeCreate: "created",
+	ChangeEdit:   "edited",
+	ChangeDelete: "deleted",
+	ChangeRename: "renamed",
+	ChangeRevert: "reverted",
+	ChangeUpload: "uploaded",
+	ChangePush:   "pushed",
+	ChangePull:   "pulled from the remote repository",
+}
+
+type WatcherProvider interface {
+	Users() []*config.User
+}
+
+type MailSettings interface {
+	Server() config.MailServer
+	Configured() bool
+}
+
+type ReadChecker interface {
+	CanReadPath(user *config.User, path string) bool
+}
+
+// MailSender sends a message using the given server. It is mail.Send outside of tests.
+type MailSender func(server mail.Server, msg *mail.Message) error
+
+// WatchNotifier emails users when pages or files they're watching change, either immediately or as a daily digest.
+type WatchNotifier struct {
+	users   WatcherProvider
+	mail    MailSettings
+	differ  DiffProvider
+	checker ReadChecker
+	site    *config.Site
+	baseUrl string
+	send    MailSender
+
+	mutex   sync.Mutex
+	digests map[string][]string
+	wg      sync.WaitGroup
+}
+
+func NewWatchNotifier(users WatcherProvider, settings MailSettings, differ DiffProvider, checker ReadChecker, site *config.Site, baseUrl string, send MailSender) *WatchNotifier {
+	return &WatchNotifier{
+		users:   users,
+		mail:    settings,
+		differ:  differ,
+		checker: checker,
+		site:    site,
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		send:    send,
+		digests: make(map[string][]string),
+	}
+}
+
+// Notify is a CommitHook that sends notifications for the change to everyone watching the affected paths.
+func (n *WatchNotifier) Notify(change *Change) {
+	// Building the diff needs the backend, which is locked while hooks run, so do the work in the background.
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.notify(change)
+	}()
+}
+
+func (n *WatchNotifier) notify(change *Change) {
+	if change.Type == ChangeConfig || !n.mail.Configured() {
+		return
+	}
+
+	var names []string
+	for i := range change.Paths {
+		if !strings.HasPrefix(change.Paths[i], ".wiki/") {
+			names = append(names, change.Paths[i])
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	type section struct {
+		gitPath string
+		message bool
+	}
+	sections := make(map[section]string)
+	for _, user := range n.users.Users() {
+		if user.Email == "" || strings.EqualFold(user.Name, change.User) {
+			continue
+		}
+
+		var readable []string
+		for _, gitPath := range names {
+			if n.checker.CanReadPath(user, strings.TrimSuffix(gitPath, ".md")) {
+				readable = append(readable, gitPath)
+			}
+		}
+
+		// The commit message may mention paths that the user can't read
+		key := section{message: len(readable) == len(names)}
+		var body []string
+		for _, gitPath := range readable {
+			if !user.Watching(strings.TrimSuffix(gitPath, ".md")) {
+				continue
+			}
+
+			key.gitPath = gitPath
+			if _, ok := sections[key]; !ok {
+				sections[key] = n.describe(change, gitPath, key.message)
+			}
+			body = append(body, sections[key])
+		}
+
+		if len(body) == 0 {
+			continue
+		}
+
+		if user.Digest {
+			n.mutex.Lock()
+			n.digests[user.Email] = append(n.digests[user.Email], body...)
+			n.mutex.Unlock()
+		} else {
+			subject := fmt.Sprintf("%s changed by %s", strings.TrimSuffix(readable[0], ".md"), change.User)
+			if len(readable) > 1 {
+				subject = fmt.Sprintf("%d pages and files changed by %s", len(readable), change.User)
+			}
+			n.deliver(user.Email, subject, body)
+		}
+	}
+}
+
+// describe summarises the change to a single page or file, including the commit message if message is true.
+func (n *WatchNotifier) describe(change *Change, gitPath string, message bool) string {
+	name := strings.TrimSuffix(gitPath, ".md")
+	isPage := name != gitPath
+
+	link := n.baseUrl + "/files/view/" + name
+	if isPage {
+		link = n.baseUrl + "/view/" + name
+	}
+
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, "%s (%s by %s at %s)\n", name, changeDescriptions[change.Type], change.User, change.Time.UTC().Format("Jan 02, 2006 15:04:05 UTC"))
+	_, _ = fmt.Fprintf(&sb, "%s\n", link)
+	if text := strings.TrimSpace(change.Message); message && text != "" {
+		_, _ = fmt.Fprintf(&sb, "\n    %s\n", strings.ReplaceAll(text, "\n", "\n    "))
+	}
+
+	// Pushes and pulls can span several commits, and created pages have nothing to compare against
+	if isPage && (change.Type == ChangeEdit || change.Type == ChangeRevert) {
+		diff, err := n.differ.PathDiff(name, change.Commit.String()+"~1", change.Commit.String())
+		if err != nil {
+			log.Printf("Unable to diff %s for notification: %v", name, err)
+		} else if excerpt := diffExcerpt(diff, maxExcerptLines); excerpt != "" {
+			_, _ = fmt.Fprintf(&sb, "\n%s", excerpt)
+		}
+	}
+	return sb.String()
+}
+
+// diffExcerpt formats the lines inserted and deleted by a diff, up to the given number of lines.
+func diffExcerpt(diff []diffmatchpatch.Diff, limit int) string {
+	// PathDiff works a character at a time, which is hard to follow in plain text, so compare whole lines instead
+	dmp := diffmatchpatch.New()
+	before, after, lineArray := dmp.DiffLinesToChars(dmp.DiffText1(diff), dmp.DiffText2(diff))
+	diff = dmp.DiffCharsToLines(dmp.DiffMain(before, after, false), lineArray)
+
+	var lines []string
+	for i := range diff {
+		prefix := "+ "
+		switch diff[i].Type {
+		case diffmatchpatch.DiffEqual:
+			continue
+		case diffmatchpatch.DiffDelete:
+			prefix = "- "
+		}
+
+		for _, line := range strings.Split(diff[i].Text, "\n") {
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, prefix+line)
+			}
+		}
+	}
+
+	if len(lines) > limit {
+		lines = append(lines[:limit], fmt.Sprintf("... and %d more changed lines", len(lines)-limit))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// SendDigests sends everyone who receives digests the notifications collected since the last call.
+func (n *WatchNotifier) SendDigests() {
+	n.mutex.Lock()
+	digests := n.digests
+	n.digests = make(map[string][]string)
+	n.mutex.Unlock()
+
+	addresses := make([]string, 0, len(digests))
+	for address := range digests {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	for _, address := range addresses {
+		n.deliver(address, fmt.Sprintf("%d changes to pages you're watching", len(digests[address])), digests[address])
+	}
+}
+
+// SendDigestsEvery sends digests at the given interval. It never returns.
+func (n *WatchNotifier) SendDigestsEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		n.SendDigests()
+	}
+}
+
+// Wait blocks until all changes passed to Notify have been processed.
+func (n *WatchNotifier) Wait() {
+	n.wg.Wait()
+}
+
+// SendTest sends a test message to the given address.
+func (n *WatchNotifier) SendTest(address string) error {
+	if address == "" {
+		return fmt.Errorf("no address given")
+	}
+
+	return n.send(mailServer(n.mail.Server()), &mail.Message{
+		To:      []string{address},
+		Subject: fmt.Sprintf("[%s] Test email", n.site.Name),
+		Body:    "This is a test email from the wiki. If you're reading it, email notifications are working.\n",
+	})
+}
+
+func (n *WatchNotifier) deliver(address, subject string, sections []string) {
+	body := "Pages and files you're watching have changed:\n\n" + strings.Join(sections, "\n----\n\n")
+	if n.baseUrl != "" {
+		body += fmt.Sprintf("\n----\n\nYou can change which pages you're watching at %s/wiki/account\n", n.baseUrl)
+	}
+
+	err := n.send(mailServer(n.mail.Server()), &mail.Message{
+		To:      []string{address},
+		Subject: fmt.Sprintf("[%s] %s", n.site.Name, subject),
+		Body:    body,
+	})
+	if err != nil {
+		log.Printf("Unable to send notification to %s: %v", address, err)
+	}
+}
+
+func mailServer(server config.MailServer) mail.Server {
+	return mail.Server{
+		Host:     server.Host,
+		Port:     server.Port,
+		Username: server.Username,
+		Password: server.Password,
+		From:     server.From,
+	}
+}
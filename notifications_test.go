@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdbot/wiki/config"
+	"github.com/mdbot/wiki/mail"
+	"github.com/mdbot/wiki/mail/mailtest"
+)
+
+type testUsers []*config.User
+
+func (t testUsers) Users() []*config.User {
+	return t
+}
+
+type testMailSettings struct {
+	server config.MailServer
+}
+
+func (t testMailSettings) Server() config.MailServer {
+	return t.server
+}
+
+func (t testMailSettings) Configured() bool {
+	return t.server.Host != ""
+}
+
+func TestWatchNotifier(t *testing.T) {
+	smtp := mailtest.NewServer()
+	defer smtp.Close()
+
+	alice := &config.User{Name: "alice", Email: "alice@example.com", Watches: []string{"projects"}}
+	bob := &config.User{Name: "bob", Email: "bob@example.com", Watches: []string{"projects/wiki"}, Digest: true}
+	carol := &config.User{Name: "carol", Email: "carol@example.com", Watches: []string{"other"}}
+
+	backend := newTestBackend(t)
+	settings := testMailSettings{config.MailServer{Host: smtp.Host(), Port: smtp.Port(), From: "wiki@example.com"}}
+	notifier := NewWatchNotifier(testUsers{alice, bob, carol}, settings, backend, &PermissionChecker{}, &config.Site{Name: "Test Wiki"}, "https://wiki.example.com/", mail.Send)
+	backend.AddCommitHook(notifier.Notify)
+
+	if err := backend.PutPage("projects/wiki", "", []byte("one\ntwo\n"), "carol", "create"); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.PutPage("projects/wiki", "", []byte("one\nthree\n"), "carol", "Fix the second line"); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.PutPage("projects/other", "", []byte("one\n"), "alice", "create"); err != nil {
+		t.Fatal(err)
+	}
+	notifier.Wait()
+
+	messages := smtp.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("Sent %d messages before digest, want 2", len(messages))
+	}
+	for i := range messages {
+		if messages[i].To[0] != "alice@example.com" {
+			t.Errorf("Message %d sent to %v, want alice", i, messages[i].To)
+		}
+	}
+
+	// Notifications are sent in the background, so may arrive in any order
+	edit := messages[0].Data
+	if !strings.Contains(edit, "edited") {
+		edit = messages[1].Data
+	}
+	for _, want := range []string{"Subject: [Test Wiki] projects/wiki changed by carol", "https://wiki.example.com/view/projects/wiki", "Fix the second line", "- two", "+ three"} {
+		if !strings.Contains(edit, want) {
+			t.Errorf("Edit notification does not contain %q:\n%s", want, edit)
+		}
+	}
+
+	notifier.SendDigests()
+	messages = smtp.Messages()
+	if len(messages) != 3 || messages[2].To[0] != "bob@example.com" {
+		t.Fatalf("Digest was not sent to bob: %+v", messages)
+	}
+	if digest := messages[2].Data; !strings.Contains(digest, "2 changes to pages you're watching") || strings.Contains(digest, "projects/other") {
+		t.Errorf("Unexpected digest:\n%s", digest)
+	}
+
+	notifier.SendDigests()
+	if len(smtp.Messages()) != 3 {
+		t.Errorf("Empty digest should not be sent")
+	}
+}
+
+// testReadChecker stops everyone from reading anything under secret.
+type testReadChecker struct{}
+
+func (testReadChecker) CanReadPath(_ *config.User, path string) bool {
+	return !strings.HasPrefix(path, "secret/")
+}
+
+func TestWatchNotifier_Unreadable(t *testing.T) {
+	smtp := mailtest.NewServer()
+	defer smtp.Close()
+
+	alice := &config.User{Name: "alice", Email: "alice@example.com", Watches: []string{"projects"}}
+	settings := testMailSettings{config.MailServer{Host: smtp.Host(), Port: smtp.Port(), From: "wiki@example.com"}}
+	notifier := NewWatchNotifier(testUsers{alice}, settings, nil, testReadChecker{}, &config.Site{Name: "Test Wiki"}, "https://wiki.example.com/", mail.Send)
+
+	notifier.Notify(&Change{
+		Type:    ChangeRename,
+		User:    "carol",
+		Message: "Move secret/plans to projects/plans",
+		Time:    time.Now(),
+		Paths:   []string{"projects/plans.md", "secret/plans.md"},
+	})
+	notifier.Wait()
+
+	messages := smtp.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("Sent %d messages, want 1", len(messages))
+	}
+	if data := messages[0].Data; !strings.Contains(data, "Subject: [Test Wiki] projects/plans changed by carol") || strings.Contains(data, "secret") {
+		t.Errorf("Notification mentions a path alice can't read:\n%s", data)
+	}
+}
@@ -0,0 +1,198 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider performs the authorization code flow against an OpenID Connect identity provider.
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+
+	authorizationEndpoint string
+	tokenEndpoint         string
+	jwksURI               string
+	endSessionEndpoint    string
+
+	mutex sync.Mutex
+	keys  map[string]*rsa.PublicKey
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// Discover fetches the configuration of the identity provider at the given issuer URL.
+func Discover(ctx context.Context, issuer, clientID, clientSecret string) (*Provider, error) {
+	p := &Provider{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}
+
+	doc := &discoveryDocument{}
+	if err := p.getJSON(ctx, p.issuer+"/.well-known/openid-configuration", doc); err != nil {
+		return nil, fmt.Errorf("unable to fetch provider configuration: %w", err)
+	}
+
+	if strings.TrimSuffix(doc.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("provider configuration is for issuer %s, expected %s", doc.Issuer, p.issuer)
+	}
+
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JwksURI == "" {
+		return nil, fmt.Errorf("provider configuration is missing required endpoints")
+	}
+
+	p.issuer = doc.Issuer
+	p.authorizationEndpoint = doc.AuthorizationEndpoint
+	p.tokenEndpoint = doc.TokenEndpoint
+	p.jwksURI = doc.JwksURI
+	p.endSessionEndpoint = doc.EndSessionEndpoint
+	return p, nil
+}
+
+// Flow holds the secrets generated at the start of a login, which must be kept by the client (e.g. in a session)
+// until the provider redirects back with the result.
+type Flow struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewFlow generates the secrets for a new login.
+func NewFlow() (*Flow, error) {
+	var values [3]string
+	for i := range values {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return &Flow{State: values[0], Nonce: values[1], Verifier: values[2]}, nil
+}
+
+// AuthURL returns the URL to send the user to in order to log in. The provider will redirect back to redirectURI.
+func (p *Provider) AuthURL(flow *Flow, redirectURI string) string {
+	challenge := sha256.Sum256([]byte(flow.Verifier))
+
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", p.clientID)
+	values.Set("redirect_uri", redirectURI)
+	values.Set("scope", "openid profile email")
+	values.Set("state", flow.State)
+	values.Set("nonce", flow.Nonce)
+	values.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	values.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.authorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.authorizationEndpoint + separator + values.Encode()
+}
+
+// Exchange swaps the code the provider redirected back with for an ID token, and verifies it.
+func (p *Provider) Exchange(ctx context.Context, flow *Flow, code, redirectURI string) (*IDToken, error) {
+	values := url.Values{}
+	values.Set("grant_type", "authorization_code")
+	values.Set("code", code)
+	values.Set("redirect_uri", redirectURI)
+	values.Set("code_verifier", flow.Verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("unable to parse token response (status %d): %w", res.StatusCode, err)
+	}
+
+	if body.Error != "" {
+		return nil, fmt.Errorf("token request failed: %s %s", body.Error, body.ErrorDescription)
+	}
+
+	if res.StatusCode != http.StatusOK || body.IDToken == "" {
+		return nil, fmt.Errorf("token request failed with status %d", res.StatusCode)
+	}
+
+	return p.verify(ctx, body.IDToken, flow.Nonce)
+}
+
+// EndSessionURL returns the URL to send the user to in order to log out of the provider, or an empty string if the
+// provider doesn't support it.
+func (p *Provider) EndSessionURL(idToken, redirectURI string) string {
+	if p.endSessionEndpoint == "" {
+		return ""
+	}
+
+	values := url.Values{}
+	values.Set("client_id", p.clientID)
+	if idToken != "" {
+		values.Set("id_token_hint", idToken)
+	}
+	if redirectURI != "" {
+		values.Set("post_logout_redirect_uri", redirectURI)
+	}
+
+	separator := "?"
+	if strings.Contains(p.endSessionEndpoint, "?") {
+		separator = "&"
+	}
+	return p.endSessionEndpoint + separator + values.Encode()
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", res.StatusCode, endpoint)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(target)
+}
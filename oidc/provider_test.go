@@ -0,0 +1,133 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdbot/wiki/oidc/oidctest"
+)
+
+const testRedirectURI = "https://wiki.example.com/wiki/sso/callback"
+
+// authorize follows the login flow until the identity provider redirects back, and returns the code it issued.
+func authorize(t *testing.T, p *Provider, flow *Flow) string {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	res, err := client.Get(p.AuthURL(flow, testRedirectURI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), testRedirectURI) {
+		t.Fatalf("Provider redirected to %q, want %s", res.Header.Get("Location"), testRedirectURI)
+	}
+
+	if state := location.Query().Get("state"); state != flow.State {
+		t.Fatalf("Provider returned state %q, want %q", state, flow.State)
+	}
+	return location.Query().Get("code")
+}
+
+func TestProvider_Login(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+	idp.SetClaims(map[string]interface{}{"sub": "1234", "preferred_username": "alice", "groups": []string{"a", "b"}})
+
+	p, err := Discover(context.Background(), idp.URL, oidctest.ClientID, oidctest.ClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flow, err := NewFlow()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := authorize(t, p, flow)
+	token, err := p.Exchange(context.Background(), flow, code, testRedirectURI)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	if token.Subject != "1234" || token.String("preferred_username") != "alice" {
+		t.Errorf("Exchange() returned claims %v", token.Claims)
+	}
+	if got := token.Strings("groups"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("Strings(groups) = %v", got)
+	}
+
+	// Codes can only be used once, and only with the right verifier
+	if _, err := p.Exchange(context.Background(), flow, code, testRedirectURI); err == nil {
+		t.Errorf("Exchange() with a used code succeeded")
+	}
+
+	code = authorize(t, p, flow)
+	if _, err := p.Exchange(context.Background(), &Flow{State: flow.State, Nonce: flow.Nonce, Verifier: "wrong"}, code, testRedirectURI); err == nil {
+		t.Errorf("Exchange() with the wrong verifier succeeded")
+	}
+
+	if got := p.EndSessionURL("token", "https://wiki.example.com/"); !strings.HasPrefix(got, idp.URL+"/logout?") {
+		t.Errorf("EndSessionURL() = %q", got)
+	}
+}
+
+func TestProvider_Verify(t *testing.T) {
+	idp := oidctest.NewServer()
+	defer idp.Close()
+
+	p, err := Discover(context.Background(), idp.URL, oidctest.ClientID, oidctest.ClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   idp.URL,
+			"aud":   oidctest.ClientID,
+			"sub":   "1234",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": "nonce",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(claims map[string]interface{})
+		wantErr bool
+	}{
+		{"valid", func(map[string]interface{}) {}, false},
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "https://example.com" }, true},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = "other" }, true},
+		{"audience list", func(c map[string]interface{}) {
+			c["aud"] = []string{"other", oidctest.ClientID}
+			c["azp"] = oidctest.ClientID
+		}, false},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, true},
+		{"wrong nonce", func(c map[string]interface{}) { c["nonce"] = "other" }, true},
+		{"no subject", func(c map[string]interface{}) { delete(c, "sub") }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.modify(claims)
+			if _, err := p.verify(context.Background(), idp.Sign(claims), "nonce"); (err != nil) != tt.wantErr {
+				t.Errorf("verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	token := idp.Sign(valid())
+	tampered := token[:len(token)-4] + "AAAA"
+	if _, err := p.verify(context.Background(), tampered, "nonce"); err == nil {
+		t.Errorf("verify() accepted a tampered signature")
+	}
+}
@@ -0,0 +1,213 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// clockSkew is how far the provider's clock may differ from ours when checking expiry times.
+const clockSkew = time.Minute
+
+var signingAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+// IDToken is a verified ID token issued by the provider.
+type IDToken struct {
+	// Raw is the encoded token, which can be passed back to the provider when logging out.
+	Raw     string
+	Subject string
+	Claims  map[string]interface{}
+}
+
+// String returns the value of a claim if it is a string, or an empty string otherwise.
+func (t *IDToken) String(claim string) string {
+	s, _ := t.Claims[claim].(string)
+	return s
+}
+
+// Strings returns the value of a claim that may be either a single string or an array of strings.
+func (t *IDToken) Strings(claim string) []string {
+	switch v := t.Claims[claim].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var res []string
+		for i := range v {
+			if s, ok := v[i].(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	default:
+		return nil
+	}
+}
+
+// verify checks the token's signature and standard claims.
+func (p *Provider) verify(ctx context.Context, raw, nonce string) (*IDToken, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header: %w", err)
+	}
+
+	hash, ok := signingAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported ID token algorithm %s", header.Alg)
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature: %w", err)
+	}
+
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, hash, h.Sum(nil), signature); err != nil {
+		return nil, errors.New("invalid ID token signature")
+	}
+
+	token := &IDToken{Raw: raw}
+	if err := decodeSegment(parts[1], &token.Claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+
+	if iss := token.String("iss"); iss != p.issuer {
+		return nil, fmt.Errorf("ID token issued by %s, expected %s", iss, p.issuer)
+	}
+
+	audience := token.Strings("aud")
+	if !contains(audience, p.clientID) {
+		return nil, errors.New("ID token was not issued for this client")
+	}
+	if azp := token.String("azp"); len(audience) > 1 && azp != p.clientID {
+		return nil, errors.New("ID token was authorised for a different client")
+	}
+
+	now := time.Now()
+	if exp, ok := token.Claims["exp"].(float64); !ok || now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return nil, errors.New("ID token has expired")
+	}
+	if nbf, ok := token.Claims["nbf"].(float64); ok && now.Add(clockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("ID token is not valid yet")
+	}
+
+	if token.String("nonce") != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	if token.Subject = token.String("sub"); token.Subject == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+
+	return token, nil
+}
+
+// key returns the provider's signing key with the given ID, fetching the provider's keys again if it isn't known
+// (as the provider may have rotated them).
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.jwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("unable to fetch provider keys: %w", err)
+	}
+
+	p.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			continue
+		}
+
+		p.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown ID token signing key %q", kid)
+}
+
+func (p *Provider) findKey(kid string) *rsa.PublicKey {
+	if key, ok := p.keys[kid]; ok {
+		return key
+	}
+
+	// Tokens don't have to specify a key if the provider only has one
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+func decodeSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	return decoder.Decode(target)
+}
+
+func contains(values []string, value string) bool {
+	for i := range values {
+		if values[i] == value {
+			return true
+		}
+	}
+	return false
+}
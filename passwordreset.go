@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/mdbot/wiki/config"
+	"github.com/mdbot/wiki/mail"
+)
+
+// PasswordResetMailer emails users links that let them set a new password.
+type PasswordResetMailer struct {
+	mail    MailSettings
+	site    *config.Site
+	baseUrl string
+	send    MailSender
+
+	wg sync.WaitGroup
+}
+
+func NewPasswordResetMailer(settings MailSettings, site *config.Site, baseUrl string, send MailSender) *PasswordResetMailer {
+	return &PasswordResetMailer{
+		mail:    settings,
+		site:    site,
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		send:    send,
+	}
+}
+
+// Available determines whether reset links can be sent. Links must point at the wiki's public URL, rather than
+// whatever host the request was made to, so they can't be redirected elsewhere.
+func (m *PasswordResetMailer) Available() bool {
+	return m.baseUrl != "" && m.mail.Configured()
+}
+
+// SendReset emails the user a link to reset their password using the given token. The message is sent in the
+// background, so that how long the request takes doesn't reveal whether the account exists.
+func (m *PasswordResetMailer) SendReset(user *config.User, token string) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		err := m.send(mailServer(m.mail.Server()), &mail.Message{
+			To:      []string{user.Email},
+			Subject: fmt.Sprintf("[%s] Reset your password", m.site.Name),
+			Body: fmt.Sprintf("Someone asked to reset the password for your account, %s. To choose a new password, "+
+				"open this link within the next hour:\n\n%s/wiki/reset?token=%s\n\nIf you didn't ask for this, "+
+				"you can ignore this email and your password won't be changed.\n", user.Name, m.baseUrl, token),
+		})
+		if err != nil {
+			log.Printf("Unable to send password reset link to user %s: %v", user.Name, err)
+		} else {
+			log.Printf("Sent password reset link to user %s", user.Name)
+		}
+	}()
+}
+
+// Wait blocks until all reset links passed to SendReset have been sent.
+func (m *PasswordResetMailer) Wait() {
+	m.wg.Wait()
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mdbot/wiki/config"
+	"github.com/mdbot/wiki/mail"
+	"github.com/mdbot/wiki/mail/mailtest"
+)
+
+func TestPasswordResetMailer(t *testing.T) {
+	smtp := mailtest.NewServer()
+	defer smtp.Close()
+
+	settings := testMailSettings{config.MailServer{Host: smtp.Host(), Port: smtp.Port(), From: "wiki@example.com"}}
+	site := &config.Site{Name: "Test Wiki"}
+
+	if NewPasswordResetMailer(settings, site, "", mail.Send).Available() {
+		t.Error("Available() = true without a public URL")
+	}
+	if NewPasswordResetMailer(testMailSettings{}, site, "https://wiki.example.com", mail.Send).Available() {
+		t.Error("Available() = true without a mail server")
+	}
+
+	mailer := NewPasswordResetMailer(settings, site, "https://wiki.example.com/", mail.Send)
+	if !mailer.Available() {
+		t.Fatal("Available() = false with a mail server and public URL")
+	}
+
+	mailer.SendReset(&config.User{Name: "alice", Email: "alice@example.com"}, "secret-token")
+	mailer.Wait()
+
+	messages := smtp.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("Sent %d messages, want 1", len(messages))
+	}
+	if messages[0].To[0] != "alice@example.com" {
+		t.Errorf("Message sent to %v, want alice", messages[0].To)
+	}
+	if !strings.Contains(messages[0].Data, "https://wiki.example.com/wiki/reset?token=secret-token") {
+		t.Errorf("Message doesn't contain the reset link:\n%s", messages[0].Data)
+	}
+}
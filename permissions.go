@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/mdbot/wiki/config"
 )
@@ -10,6 +11,7 @@ import (
 type PermissionChecker struct {
 	requireAuthForWrites bool
 	requireAuthForReads  bool
+	acl                  *config.Acl
 }
 
 func (p *PermissionChecker) CanRead(user *config.User) bool {
@@ -32,6 +34,36 @@ func (p *PermissionChecker) CanAdmin(user *config.User) bool {
 	return user != nil && user.Has(config.PermissionAdmin)
 }
 
+// CanReadPath determines whether the user can read the given page or file, taking into account any access control
+// rules. Admins are not subject to access control rules.
+func (p *PermissionChecker) CanReadPath(user *config.User, path string) bool {
+	if !p.CanRead(user) {
+		return false
+	}
+	return p.acl == nil || p.CanAdmin(user) || p.acl.CanRead(user, path)
+}
+
+// CanWritePath determines whether the user can modify the given page or file, taking into account any access control
+// rules. Admins are not subject to access control rules.
+func (p *PermissionChecker) CanWritePath(user *config.User, path string) bool {
+	if !p.CanWrite(user) || !p.CanReadPath(user, path) {
+		return false
+	}
+	return p.acl == nil || p.CanAdmin(user) || p.acl.CanWrite(user, path)
+}
+
+// FilterReadable returns the subset of the given pages or files that the user making the request can read.
+func (p *PermissionChecker) FilterReadable(r *http.Request, paths []string) []string {
+	user := getUserForRequest(r)
+	res := make([]string, 0, len(paths))
+	for i := range paths {
+		if p.CanReadPath(user, paths[i]) {
+			res = append(res, paths[i])
+		}
+	}
+	return res
+}
+
 func (p *PermissionChecker) RequireRead(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := getUserForRequest(r)
@@ -88,3 +120,35 @@ func (p *PermissionChecker) RequireAccount(next http.Handler) http.Handler {
 		}
 	})
 }
+
+// RequireReadPath checks that the user can read the page or file named in the request path, after the given prefix.
+func (p *PermissionChecker) RequireReadPath(prefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := getUserForRequest(r)
+		if p.CanReadPath(user, strings.TrimPrefix(r.URL.Path, prefix)) {
+			next.ServeHTTP(w, r)
+		} else if user == nil {
+			log.Printf("Anonymous user tried to access read-protected resource %s", r.URL)
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			log.Printf("User %s (permissions: %s) tried to access read-protected resource %s", user.Name, user.Permissions.String(), r.URL)
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})
+}
+
+// RequireWritePath checks that the user can modify the page or file named in the request path, after the given prefix.
+func (p *PermissionChecker) RequireWritePath(prefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := getUserForRequest(r)
+		if p.CanWritePath(user, strings.TrimPrefix(r.URL.Path, prefix)) {
+			next.ServeHTTP(w, r)
+		} else if user == nil {
+			log.Printf("Anonymous user tried to access write-protected resource %s", r.URL)
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			log.Printf("User %s (permissions: %s) tried to access write-protected resource %s", user.Name, user.Permissions.String(), r.URL)
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})
+}
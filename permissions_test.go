@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mdbot/wiki/config"
+)
+
+func TestPermissionChecker_Paths(t *testing.T) {
+	pm := &PermissionChecker{
+		requireAuthForWrites: true,
+		acl: &config.Acl{Rules: []config.AclRule{
+			{Prefix: "handbook", Write: []string{"Editor"}},
+			{Prefix: "hr", Read: []string{"alice"}},
+			{Prefix: "hr/payroll", Read: []string{"alice", "bob"}, Write: []string{"bob"}},
+		}},
+	}
+
+	alice := &config.User{Name: "alice", Permissions: config.PermissionWrite}
+	bob := &config.User{Name: "bob", Permissions: config.PermissionWrite}
+	editor := &config.User{Name: "editor", Permissions: config.PermissionWrite}
+	admin := &config.User{Name: "admin", Permissions: config.PermissionAdmin}
+
+	tests := []struct {
+		name      string
+		user      *config.User
+		path      string
+		wantRead  bool
+		wantWrite bool
+	}{
+		{"unrestricted page", nil, "mainpage", true, false},
+		{"unrestricted page with user", bob, "mainpage", true, true},
+		{"write restricted page", bob, "handbook/leave", true, false},
+		{"write restricted page by editor", editor, "Handbook/Leave", true, true},
+		{"prefix must match whole segment", bob, "handbooks", true, true},
+		{"read restricted page", bob, "hr/policies", false, false},
+		{"read restricted page by reader", alice, "hr/policies", true, true},
+		{"anonymous read restricted page", nil, "hr", false, false},
+		{"all matching rules apply", bob, "hr/payroll", false, false},
+		{"all matching rules apply to writes", alice, "hr/payroll/2024", true, false},
+		{"admins bypass rules", admin, "hr/payroll", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pm.CanReadPath(tt.user, tt.path); got != tt.wantRead {
+				t.Errorf("CanReadPath() = %v, want %v", got, tt.wantRead)
+			}
+			if got := pm.CanWritePath(tt.user, tt.path); got != tt.wantWrite {
+				t.Errorf("CanWritePath() = %v, want %v", got, tt.wantWrite)
+			}
+		})
+	}
+}
+
+func TestFilterChanges(t *testing.T) {
+	backend := newTestBackend(t)
+	if err := backend.PutPage("hr/secret", "", []byte("secret"), "alice", "create"); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.PutPage("public", "", []byte("public"), "alice", "create"); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.RenamePage("hr/secret", "leaked", nil, false, "rename", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := backend.RecentChanges("", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pm := &PermissionChecker{acl: &config.Acl{Rules: []config.AclRule{{Prefix: "hr", Read: []string{"alice"}}}}}
+	req := httptest.NewRequest(http.MethodGet, "/wiki/changes", nil)
+	var messages []string
+	for _, change := range filterChanges(req, pm, changes) {
+		messages = append(messages, strings.TrimSpace(change.Message))
+	}
+	if strings.Join(messages, ",") != "create" {
+		t.Errorf("filterChanges() kept %v, want only the public page's creation", messages)
+	}
+}
@@ -0,0 +1,244 @@
+package search
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// indexVersion is stored alongside the index, and should be incremented whenever the format or the way documents
+// are tokenized changes. Indexes with a different version are discarded and rebuilt.
+const indexVersion = 1
+
+// Index is an inverted index of wiki pages, which can be persisted to disk and updated incrementally.
+type Index struct {
+	mutex       sync.RWMutex
+	path        string
+	dirty       bool
+	totalLength int
+	data        indexData
+}
+
+// indexData is the part of the index that is persisted to disk.
+type indexData struct {
+	Version int
+	// Commit is the revision of the wiki that the index was last updated to.
+	Commit string
+	Docs   map[string]*document
+	// Postings maps each term to the documents that contain it.
+	Postings map[string]map[string]*posting
+}
+
+type document struct {
+	Content string
+	// Length is the number of terms in the content.
+	Length int
+	// Title contains the terms in the name of the page.
+	Title []string
+}
+
+type posting struct {
+	// Title is the number of times the term appears in the page's name.
+	Title int
+	// Positions are the indices of the term within the content's terms.
+	Positions []int
+}
+
+// Open loads the index stored at the given path. If the file doesn't exist, or was written by an incompatible
+// version, an empty index is returned which will be saved to that path.
+func Open(path string) (*Index, error) {
+	i := &Index{path: path}
+	i.reset()
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return i, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var data indexData
+	if err := gob.NewDecoder(f).Decode(&data); err != nil {
+		log.Printf("Discarding unreadable search index: %v", err)
+		return i, nil
+	}
+
+	if data.Version != indexVersion {
+		log.Printf("Discarding search index with version %d (want %d)", data.Version, indexVersion)
+		return i, nil
+	}
+
+	i.data = data
+	for _, doc := range i.data.Docs {
+		i.totalLength += doc.Length
+	}
+	return i, nil
+}
+
+func (i *Index) reset() {
+	i.data = indexData{
+		Version:  indexVersion,
+		Docs:     make(map[string]*document),
+		Postings: make(map[string]map[string]*posting),
+	}
+	i.totalLength = 0
+	i.dirty = true
+}
+
+// Clear removes all pages from the index.
+func (i *Index) Clear() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.reset()
+}
+
+// Commit returns the revision that the index was last updated to, or an empty string if it has never been updated.
+func (i *Index) Commit() string {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	return i.data.Commit
+}
+
+// SetCommit records the revision that the index has been updated to.
+func (i *Index) SetCommit(commit string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.data.Commit = commit
+	i.dirty = true
+}
+
+// Put adds the page to the index, replacing any previous version of it.
+func (i *Index) Put(name string, content []byte) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.remove(name)
+
+	doc := &document{Content: string(content)}
+	for _, t := range tokenize(name) {
+		doc.Title = append(doc.Title, t.term)
+		i.postingFor(t.term, name).Title++
+	}
+
+	tokens := tokenize(doc.Content)
+	for pos, t := range tokens {
+		p := i.postingFor(t.term, name)
+		p.Positions = append(p.Positions, pos)
+	}
+	doc.Length = len(tokens)
+
+	i.data.Docs[name] = doc
+	i.totalLength += doc.Length
+	i.dirty = true
+}
+
+// Remove deletes the page from the index, if it is present.
+func (i *Index) Remove(name string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.remove(name)
+}
+
+func (i *Index) remove(name string) {
+	doc, ok := i.data.Docs[name]
+	if !ok {
+		return
+	}
+
+	for _, term := range doc.Title {
+		i.removePosting(term, name)
+	}
+	for _, t := range tokenize(doc.Content) {
+		i.removePosting(t.term, name)
+	}
+
+	delete(i.data.Docs, name)
+	i.totalLength -= doc.Length
+	i.dirty = true
+}
+
+func (i *Index) postingFor(term, name string) *posting {
+	docs, ok := i.data.Postings[term]
+	if !ok {
+		docs = make(map[string]*posting)
+		i.data.Postings[term] = docs
+	}
+
+	p, ok := docs[name]
+	if !ok {
+		p = &posting{}
+		docs[name] = p
+	}
+	return p
+}
+
+func (i *Index) removePosting(term, name string) {
+	docs := i.data.Postings[term]
+	delete(docs, name)
+	if len(docs) == 0 {
+		delete(i.data.Postings, term)
+	}
+}
+
+// Save writes the index to disk if it has changed since it was last saved.
+func (i *Index) Save() error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if !i.dirty {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(i.path), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a crash can't leave a partially written index behind.
+	tmp := i.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := gob.NewEncoder(f).Encode(&i.data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("unable to encode search index: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, i.path); err != nil {
+		return err
+	}
+
+	i.dirty = false
+	return nil
+}
+
+// SaveEvery saves the index at the given interval until the process exits. Any changes made since the last save
+// will be lost if the process exits uncleanly, but as the index records the revision it was saved at they can be
+// recovered from the repository.
+func (i *Index) SaveEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := i.Save(); err != nil {
+			log.Printf("Unable to save search index: %v", err)
+		}
+	}
+}
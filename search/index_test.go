@@ -0,0 +1,167 @@
+package search
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []clause
+	}{
+		{"single term", "Hello", []clause{{terms: []string{"hello"}}}},
+		{"multiple terms", "hello  world", []clause{{terms: []string{"hello"}}, {terms: []string{"world"}}}},
+		{"phrase", `"hello world" foo`, []clause{{terms: []string{"hello", "world"}}, {terms: []string{"foo"}}}},
+		{"unterminated phrase", `foo "hello world`, []clause{{terms: []string{"foo"}}, {terms: []string{"hello", "world"}}}},
+		{"prefix", "hel*", []clause{{terms: []string{"hel"}, prefix: true}}},
+		{"punctuated word", "foo-bar", []clause{{terms: []string{"foo", "bar"}}}},
+		{"only punctuation", `* "" -`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseQuery(tt.query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseQuery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestIndex(t *testing.T) *Index {
+	index, err := Open(filepath.Join(t.TempDir(), "search.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index.Put("deployment", []byte("How we deploy the wiki.\n\nRun the release process, then check the logs."))
+	index.Put("release/process", []byte("The release process is documented elsewhere. See deployment."))
+	index.Put("cooking", []byte("Process the onions, then release the steam from the pan."))
+	index.Put("misc", []byte("Nothing to see here. <b>Deployments</b> are fun."))
+	return index
+}
+
+func resultNames(results []Result) []string {
+	var names []string
+	for i := range results {
+		names = append(names, results[i].Name)
+	}
+	return names
+}
+
+func TestIndex_Search(t *testing.T) {
+	index := newTestIndex(t)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"title matches rank first", "deployment", []string{"deployment", "release/process"}},
+		{"all terms must match", "release onions", []string{"cooking"}},
+		{"phrase", `"release process"`, []string{"release/process", "deployment"}},
+		{"prefix", "deploy*", []string{"deployment", "release/process", "misc"}},
+		{"no matches", "kubernetes", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, total := index.Search(tt.query, 10, nil)
+			if got := resultNames(results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search() = %v, want %v", got, tt.want)
+			}
+			if total != len(tt.want) {
+				t.Errorf("Search() total = %d, want %d", total, len(tt.want))
+			}
+		})
+	}
+
+	results, total := index.Search("the", 2, nil)
+	if len(results) != 2 || total != 3 {
+		t.Errorf("Search() with limit returned %d results of %d, want 2 of 3", len(results), total)
+	}
+
+	results, total = index.Search("release", 10, func(name string) bool { return !strings.HasPrefix(name, "release/") })
+	if got := resultNames(results); !reflect.DeepEqual(got, []string{"cooking", "deployment"}) || total != 2 {
+		t.Errorf("Search() with filter = %v (%d total), want [cooking deployment]", got, total)
+	}
+}
+
+func TestIndex_Snippets(t *testing.T) {
+	index := newTestIndex(t)
+
+	results, _ := index.Search(`"release process"`, 1, nil)
+	if len(results) != 1 {
+		t.Fatalf("Search() returned %d results, want 1", len(results))
+	}
+	if want := "The <mark>release</mark> <mark>process</mark> is documented elsewhere. See deployment."; results[0].Snippet != want {
+		t.Errorf("Snippet = %q, want %q", results[0].Snippet, want)
+	}
+
+	results, _ = index.Search("deployments", 1, nil)
+	if len(results) != 1 || !strings.Contains(results[0].Snippet, "<mark>Deployments</mark>&lt;/b&gt; are fun.") {
+		t.Errorf("Search() = %v, want escaped snippet", results)
+	}
+
+	index.Put("long", []byte(strings.Repeat("filler ", 100)+"needle "+strings.Repeat("filler ", 100)))
+	results, _ = index.Search("needle", 1, nil)
+	if len(results) != 1 || !strings.HasPrefix(results[0].Snippet, "… filler") || !strings.HasSuffix(results[0].Snippet, "filler …") {
+		t.Errorf("Search() = %v, want truncated snippet", results)
+	}
+}
+
+func TestIndex_Updates(t *testing.T) {
+	index := newTestIndex(t)
+
+	index.Put("cooking", []byte("Fry the onions."))
+	if results, _ := index.Search("release", 10, nil); !reflect.DeepEqual(resultNames(results), []string{"release/process", "deployment"}) {
+		t.Errorf("Search() after update = %v", resultNames(results))
+	}
+
+	index.Remove("release/process")
+	if results, _ := index.Search("release", 10, nil); !reflect.DeepEqual(resultNames(results), []string{"deployment"}) {
+		t.Errorf("Search() after removal = %v", resultNames(results))
+	}
+
+	if _, ok := index.data.Postings["elsewhere"]; ok {
+		t.Errorf("Postings for removed page were retained")
+	}
+}
+
+func TestIndex_Persistence(t *testing.T) {
+	index := newTestIndex(t)
+	index.SetCommit("abc123")
+
+	if err := index.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Open(index.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Commit() != "abc123" {
+		t.Errorf("Commit() = %q, want abc123", loaded.Commit())
+	}
+
+	want, _ := index.Search("the", 10, nil)
+	got, _ := loaded.Search("the", 10, nil)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Search() after loading = %v, want %v", got, want)
+	}
+
+	if err := os.WriteFile(index.path, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err = Open(index.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Commit() != "" || len(loaded.data.Docs) != 0 {
+		t.Errorf("Open() of corrupt index returned data")
+	}
+}
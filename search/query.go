@@ -0,0 +1,264 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// bm25K1 controls how quickly repeated occurrences of a term stop increasing the score.
+	bm25K1 = 1.2
+	// bm25B controls how much longer documents are penalised.
+	bm25B = 0.75
+	// titleBoost is how many occurrences in the body an occurrence in the page's name is worth.
+	titleBoost = 3
+)
+
+// Result is a single page matching a search query.
+type Result struct {
+	Name  string
+	Score float64
+	// Snippet is an HTML excerpt of the page, with the matching terms highlighted.
+	Snippet string
+}
+
+type token struct {
+	term  string
+	start int
+	end   int
+}
+
+// tokenize splits the text into lower case terms consisting of letters and digits, recording where each term was
+// found in the original text.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for pos, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start == -1 {
+				start = pos
+			}
+		} else if start != -1 {
+			tokens = append(tokens, token{term: strings.ToLower(text[start:pos]), start: start, end: pos})
+			start = -1
+		}
+	}
+	if start != -1 {
+		tokens = append(tokens, token{term: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+// clause is a single part of a query, all of which must match for a page to be returned.
+type clause struct {
+	// terms contains one term, or more for a phrase that must match consecutive terms.
+	terms []string
+	// prefix indicates that the (single) term should match any term that it's a prefix of.
+	prefix bool
+}
+
+// parseQuery splits a query into clauses. Words are matched individually, text within double quotes is matched as
+// a phrase, and words ending with an asterisk match any term they are a prefix of.
+func parseQuery(query string) []clause {
+	var clauses []clause
+	add := func(text string, prefix bool) {
+		var terms []string
+		for _, t := range tokenize(text) {
+			terms = append(terms, t.term)
+		}
+		if len(terms) > 0 {
+			clauses = append(clauses, clause{terms: terms, prefix: prefix && len(terms) == 1})
+		}
+	}
+
+	for query != "" {
+		if strings.HasPrefix(query, `"`) {
+			phrase, rest, _ := strings.Cut(query[1:], `"`)
+			add(phrase, false)
+			query = rest
+			continue
+		}
+
+		end := strings.IndexFunc(query, func(r rune) bool { return unicode.IsSpace(r) || r == '"' })
+		if end == -1 {
+			end = len(query)
+		}
+		word := query[:end]
+		add(word, strings.HasSuffix(word, "*"))
+		query = strings.TrimLeftFunc(query[end:], unicode.IsSpace)
+	}
+	return clauses
+}
+
+// match records how a clause matched a single page.
+type match struct {
+	body  int
+	title int
+	// positions are the terms in the body that matched, for highlighting.
+	positions []int
+}
+
+// Search finds the pages that match all parts of the query, and returns up to limit of them ordered by relevance,
+// along with the total number of pages that matched. If filter is non-nil, only pages it returns true for are
+// included.
+func (i *Index) Search(query string, limit int, filter func(name string) bool) ([]Result, int) {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+
+	clauses := parseQuery(query)
+	if len(clauses) == 0 || len(i.data.Docs) == 0 {
+		return nil, 0
+	}
+
+	matches := make([]map[string]*match, len(clauses))
+	for n := range clauses {
+		matches[n] = i.matchClause(clauses[n])
+	}
+
+	// Start with the clause that matched the fewest pages, and discard anything the others didn't match.
+	sort.Slice(matches, func(a, b int) bool {
+		return len(matches[a]) < len(matches[b])
+	})
+
+	docCount := float64(len(i.data.Docs))
+	averageLength := float64(i.totalLength) / docCount
+
+	var results []Result
+	highlights := make(map[string][]int)
+candidates:
+	for name := range matches[0] {
+		if filter != nil && !filter(name) {
+			continue
+		}
+
+		doc := i.data.Docs[name]
+		score := 0.0
+		for n := range matches {
+			m, ok := matches[n][name]
+			if !ok {
+				continue candidates
+			}
+
+			df := float64(len(matches[n]))
+			idf := math.Log(1 + (docCount-df+0.5)/(df+0.5))
+			tf := float64(m.body + titleBoost*m.title)
+			norm := 1 - bm25B + bm25B*float64(doc.Length)/math.Max(averageLength, 1)
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+
+			highlights[name] = append(highlights[name], m.positions...)
+		}
+		results = append(results, Result{Name: name, Score: score})
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		if results[a].Score != results[b].Score {
+			return results[a].Score > results[b].Score
+		}
+		return results[a].Name < results[b].Name
+	})
+
+	total := len(results)
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	for n := range results {
+		results[n].Snippet = snippet(i.data.Docs[results[n].Name].Content, highlights[results[n].Name])
+	}
+	return results, total
+}
+
+// matchClause finds all pages matching the clause.
+func (i *Index) matchClause(c clause) map[string]*match {
+	res := make(map[string]*match)
+
+	if len(c.terms) > 1 {
+		i.matchPhrase(c.terms, res)
+		return res
+	}
+
+	if !c.prefix {
+		i.matchTerm(c.terms[0], res)
+		return res
+	}
+
+	for term := range i.data.Postings {
+		if strings.HasPrefix(term, c.terms[0]) {
+			i.matchTerm(term, res)
+		}
+	}
+	return res
+}
+
+// matchTerm adds the occurrences of a single term to the matches.
+func (i *Index) matchTerm(term string, res map[string]*match) {
+	for name, p := range i.data.Postings[term] {
+		m, ok := res[name]
+		if !ok {
+			m = &match{}
+			res[name] = m
+		}
+		m.body += len(p.Positions)
+		m.title += p.Title
+		m.positions = append(m.positions, p.Positions...)
+	}
+}
+
+// matchPhrase adds pages where the terms appear consecutively to the matches.
+func (i *Index) matchPhrase(terms []string, res map[string]*match) {
+	for name, first := range i.data.Postings[terms[0]] {
+		// Build a set of the positions of each subsequent term, bailing early if the page doesn't contain one.
+		following := make([]map[int]bool, len(terms)-1)
+		for n := range following {
+			p, ok := i.data.Postings[terms[n+1]][name]
+			if !ok {
+				following = nil
+				break
+			}
+			following[n] = make(map[int]bool, len(p.Positions))
+			for _, pos := range p.Positions {
+				following[n][pos] = true
+			}
+		}
+		if following == nil {
+			continue
+		}
+
+		m := &match{}
+	positions:
+		for _, pos := range first.Positions {
+			for n := range following {
+				if !following[n][pos+n+1] {
+					continue positions
+				}
+			}
+			m.body++
+			for n := range terms {
+				m.positions = append(m.positions, pos+n)
+			}
+		}
+
+		if containsPhrase(i.data.Docs[name].Title, terms) {
+			m.title++
+		}
+
+		if m.body > 0 || m.title > 0 {
+			res[name] = m
+		}
+	}
+}
+
+func containsPhrase(haystack, phrase []string) bool {
+outer:
+	for start := 0; start+len(phrase) <= len(haystack); start++ {
+		for n := range phrase {
+			if haystack[start+n] != phrase[n] {
+				continue outer
+			}
+		}
+		return true
+	}
+	return false
+}
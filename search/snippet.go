@@ -0,0 +1,98 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// snippetLength is the number of terms included in a snippet.
+const snippetLength = 30
+
+// snippet returns an HTML excerpt of the content, choosing the part with the most highlighted terms and wrapping
+// them in mark tags.
+func snippet(content string, highlights []int) string {
+	tokens := tokenize(content)
+	if len(tokens) == 0 {
+		return ""
+	}
+
+	sort.Ints(highlights)
+	highlighted := make(map[int]bool, len(highlights))
+	for _, pos := range highlights {
+		highlighted[pos] = true
+	}
+
+	// Slide a window over the highlighted positions to find the start of the densest part of the content.
+	start, best := 0, 0
+	for first, last := 0, 0; last < len(highlights); last++ {
+		for highlights[last]-highlights[first] >= snippetLength {
+			first++
+		}
+		if count := last - first + 1; count > best {
+			start, best = highlights[first], count
+		}
+	}
+
+	// Centre the matches within the snippet, so there's some context either side of them.
+	if best > 0 {
+		lastHighlight := start
+		for _, pos := range highlights {
+			if pos >= start && pos < start+snippetLength {
+				lastHighlight = pos
+			}
+		}
+		start -= (snippetLength - (lastHighlight - start + 1)) / 2
+	}
+	start = max(0, min(start, len(tokens)-snippetLength))
+	end := min(start+snippetLength, len(tokens))
+
+	var res strings.Builder
+	if start > 0 {
+		res.WriteString("… ")
+	} else {
+		res.WriteString(html.EscapeString(strings.TrimLeftFunc(collapseSpace(content[:tokens[0].start]), unicode.IsSpace)))
+	}
+	for pos := start; pos < end; pos++ {
+		if pos > start {
+			res.WriteString(html.EscapeString(collapseSpace(content[tokens[pos-1].end:tokens[pos].start])))
+		}
+
+		text := html.EscapeString(content[tokens[pos].start:tokens[pos].end])
+		if highlighted[pos] {
+			res.WriteString("<mark>")
+			res.WriteString(text)
+			res.WriteString("</mark>")
+		} else {
+			res.WriteString(text)
+		}
+	}
+	if end < len(tokens) {
+		res.WriteString(" …")
+	} else {
+		res.WriteString(html.EscapeString(strings.TrimRightFunc(collapseSpace(content[tokens[end-1].end:]), unicode.IsSpace)))
+	}
+	return res.String()
+}
+
+// collapseSpace replaces each run of whitespace in the text with a single space.
+func collapseSpace(text string) string {
+	var res strings.Builder
+	space := false
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			res.WriteRune(' ')
+			space = false
+		}
+		res.WriteRune(r)
+	}
+	if space {
+		res.WriteRune(' ')
+	}
+	return res.String()
+}
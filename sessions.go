@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sessionExpiry is how long a session can go unused before it's forgotten. It matches the lifetime of the cookie.
+	sessionExpiry = 31 * 24 * time.Hour
+	// sessionTouchInterval limits how often the last seen time of a session is updated.
+	sessionTouchInterval = time.Minute
+)
+
+// ActiveSession describes a logged in session. The ID is a hash of the secret stored in the user's cookie, so can be
+// shown to users and admins without allowing the session to be taken over.
+type ActiveSession struct {
+	ID        string
+	Username  string
+	Created   time.Time
+	LastSeen  time.Time
+	IP        string
+	UserAgent string
+}
+
+// Device returns a short description of the browser and operating system the session was started from.
+func (s *ActiveSession) Device() string {
+	return describeUserAgent(s.UserAgent)
+}
+
+// SessionTracker keeps a record of every logged in session, so that they can be listed and revoked. Sessions are
+// kept in memory and saved to a file, as they're specific to this instance of the wiki.
+type SessionTracker struct {
+	path string
+
+	mutex    sync.Mutex
+	sessions map[string]*ActiveSession
+	dirty    bool
+}
+
+// OpenSessionTracker loads the sessions saved at the given path, if there are any.
+func OpenSessionTracker(path string) (*SessionTracker, error) {
+	t := &SessionTracker{
+		path:     path,
+		sessions: make(map[string]*ActiveSession),
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var sessions []*ActiveSession
+	if err := json.Unmarshal(b, &sessions); err != nil {
+		return nil, fmt.Errorf("unable to read sessions: %w", err)
+	}
+
+	for i := range sessions {
+		t.sessions[sessions[i].ID] = sessions[i]
+	}
+	return t, nil
+}
+
+// Start records a new session for the user, returning the secret that identifies it.
+func (t *SessionTracker) Start(username, ip, userAgent string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("unable to generate session ID: %w", err)
+	}
+	secret := hex.EncodeToString(b)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	id := sessionID(secret)
+	t.sessions[id] = &ActiveSession{
+		ID:        id,
+		Username:  username,
+		Created:   now,
+		LastSeen:  now,
+		IP:        ip,
+		UserAgent: userAgent,
+	}
+	return secret, t.save()
+}
+
+// Touch checks that the session identified by the secret belongs to the user and hasn't been revoked, and updates
+// when it was last seen.
+func (t *SessionTracker) Touch(secret, username, ip string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s, ok := t.sessions[sessionID(secret)]
+	if !ok || !strings.EqualFold(s.Username, username) || time.Since(s.LastSeen) > sessionExpiry {
+		return false
+	}
+
+	if time.Since(s.LastSeen) > sessionTouchInterval || s.IP != ip {
+		s.LastSeen = time.Now()
+		s.IP = ip
+		t.dirty = true
+	}
+	return true
+}
+
+// End removes the session identified by the secret, when the user logs out.
+func (t *SessionTracker) End(secret string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if _, ok := t.sessions[sessionID(secret)]; ok {
+		delete(t.sessions, sessionID(secret))
+		if err := t.save(); err != nil {
+			log.Printf("Unable to save sessions: %v", err)
+		}
+	}
+}
+
+// Revoke removes the user's session with the given ID.
+func (t *SessionTracker) Revoke(username, id string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if s, ok := t.sessions[id]; !ok || !strings.EqualFold(s.Username, username) {
+		return errors.New("session not found")
+	}
+
+	delete(t.sessions, id)
+	return t.save()
+}
+
+// RevokeAll removes all the user's sessions, apart from the one with the given ID (which may be empty), and returns
+// how many were removed.
+func (t *SessionTracker) RevokeAll(username, except string) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	count := 0
+	for id, s := range t.sessions {
+		if strings.EqualFold(s.Username, username) && id != except {
+			delete(t.sessions, id)
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+	return count, t.save()
+}
+
+// Sessions returns copies of the user's sessions, most recently used first.
+func (t *SessionTracker) Sessions(username string) []ActiveSession {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var res []ActiveSession
+	for _, s := range t.sessions {
+		if strings.EqualFold(s.Username, username) && time.Since(s.LastSeen) <= sessionExpiry {
+			res = append(res, *s)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].LastSeen.After(res[j].LastSeen)
+	})
+	return res
+}
+
+// Save writes the sessions to disk if they have changed since they were last saved.
+func (t *SessionTracker) Save() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.dirty {
+		return nil
+	}
+	return t.save()
+}
+
+// SaveEvery saves the sessions at the given interval until the process exits. Only last seen times can be lost if
+// the process exits uncleanly, as starting and revoking sessions saves them immediately.
+func (t *SessionTracker) SaveEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := t.Save(); err != nil {
+			log.Printf("Unable to save sessions: %v", err)
+		}
+	}
+}
+
+// save writes the sessions to disk, forgetting any that have expired. The mutex must be held.
+func (t *SessionTracker) save() error {
+	var sessions []*ActiveSession
+	for id, s := range t.sessions {
+		if time.Since(s.LastSeen) > sessionExpiry {
+			delete(t.sessions, id)
+		} else {
+			sessions = append(sessions, s)
+		}
+	}
+
+	b, err := json.Marshal(sessions)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that a crash can't leave a partially written file behind.
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, t.path); err != nil {
+		return err
+	}
+
+	t.dirty = false
+	return nil
+}
+
+func sessionID(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:16])
+}
+
+// describeUserAgent makes a rough guess at the browser and operating system from a User-Agent header.
+func describeUserAgent(ua string) string {
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"git/", "Git"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, platform := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, platform.token) {
+			return browser + " on " + platform.name
+		}
+	}
+	return browser
+}
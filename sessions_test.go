@@ -0,0 +1,105 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSessionTracker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	tracker, err := OpenSessionTracker(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := tracker.Start("alice", "10.0.0.1", "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tracker.Start("Alice", "10.0.0.2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := tracker.Start("bob", "10.0.0.3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !tracker.Touch(first, "alice", "10.0.0.4") {
+		t.Error("Touch() rejected a valid session")
+	}
+	if tracker.Touch(bob, "alice", "10.0.0.4") {
+		t.Error("Touch() accepted another user's session")
+	}
+	if tracker.Touch("made up", "alice", "10.0.0.4") {
+		t.Error("Touch() accepted an unknown session")
+	}
+
+	sessions := tracker.Sessions("alice")
+	if len(sessions) != 2 {
+		t.Fatalf("Sessions() returned %d sessions, want 2", len(sessions))
+	}
+	if sessions[0].ID != sessionID(first) || sessions[0].IP != "10.0.0.4" {
+		t.Errorf("Sessions()[0] = %+v, want the most recently used session with its new address", sessions[0])
+	}
+
+	// Reopening the tracker should keep the sessions
+	if err := tracker.Save(); err != nil {
+		t.Fatal(err)
+	}
+	tracker, err = OpenSessionTracker(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tracker.Touch(second, "alice", "10.0.0.2") {
+		t.Error("Touch() rejected a session after reloading")
+	}
+
+	if err := tracker.Revoke("alice", sessionID(bob)); err == nil {
+		t.Error("Revoke() removed another user's session")
+	}
+	if err := tracker.Revoke("alice", sessionID(second)); err != nil {
+		t.Errorf("Revoke() returned error: %v", err)
+	}
+	if tracker.Touch(second, "alice", "10.0.0.2") {
+		t.Error("Touch() accepted a revoked session")
+	}
+
+	if _, err := tracker.Start("alice", "10.0.0.5", ""); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := tracker.RevokeAll("alice", sessionID(first)); err != nil || count != 1 {
+		t.Errorf("RevokeAll() = %d, %v, want 1, nil", count, err)
+	}
+	if !tracker.Touch(first, "alice", "10.0.0.4") {
+		t.Error("RevokeAll() revoked the excluded session")
+	}
+	if !tracker.Touch(bob, "bob", "10.0.0.3") {
+		t.Error("RevokeAll() revoked another user's session")
+	}
+
+	tracker.End(first)
+	if got := len(tracker.Sessions("alice")); got != 0 {
+		t.Errorf("Sessions() returned %d sessions after ending the last, want 0", got)
+	}
+}
+
+func TestDescribeUserAgent(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", "Chrome on Windows"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0", "Edge on Windows"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.1; rv:120.0) Gecko/20100101 Firefox/120.0", "Firefox on macOS"},
+		{"curl/8.4.0", "curl"},
+		{"", "Unknown browser"},
+	}
+	for _, tt := range tests {
+		if got := describeUserAgent(tt.ua); got != tt.want {
+			t.Errorf("describeUserAgent(%q) = %q, want %q", tt.ua, got, tt.want)
+		}
+	}
+}
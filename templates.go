@@ -1,24 +1,37 @@
 package main
 
 import (
+	"encoding/base64"
 	"fmt"
 	"html/template"
 	"io/fs"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/csrf"
 	"github.com/mdbot/wiki/config"
+	"github.com/mdbot/wiki/search"
+	"github.com/mdbot/wiki/totp"
+	"github.com/mdbot/wiki/webhook"
 	"github.com/sergi/go-diff/diffmatchpatch"
+	"github.com/skip2/go-qrcode"
 )
 
 type Templates struct {
 	fs              fs.FS
 	siteConfig      *config.Site
 	checker         *PermissionChecker
+	singleSignOn    bool
 	version         string
 	sidebarProvider func() string
+	// passwordReset determines whether users can reset their own passwords by email.
+	passwordReset func() bool
+	// registrationOpen determines whether people can create their own accounts without an invite.
+	registrationOpen func() bool
+	// settingsInMemory indicates that settings aren't being saved, because there's no encryption key.
+	settingsInMemory bool
 }
 
 type SiteArgs struct {
@@ -30,6 +43,14 @@ type SiteArgs struct {
 	CanWrite    bool
 	CanAdmin    bool
 	WikiVersion string
+	// SingleSignOn indicates that users can log in with an OpenID Connect provider.
+	SingleSignOn bool
+	// PasswordReset indicates that users can reset forgotten passwords by email.
+	PasswordReset bool
+	// Registration indicates that people can sign up for an account without an invite.
+	Registration bool
+	// SettingsInMemory indicates that settings will be lost when the wiki stops, because there's no encryption key.
+	SettingsInMemory bool
 }
 
 type CommonArgs struct {
@@ -53,46 +74,67 @@ type LastModifiedDetails struct {
 }
 
 type ViewPageArgs struct {
-	Common      CommonArgs
-	PageContent template.HTML
+	Common         CommonArgs
+	PageContent    template.HTML
+	RedirectedFrom string
 }
 
-func (t *Templates) RenderPage(w http.ResponseWriter, r *http.Request, title, content string, log *LastModifiedDetails) {
+func (t *Templates) RenderPage(w http.ResponseWriter, r *http.Request, title, content string, log *LastModifiedDetails, redirectedFrom string) {
 	t.render("index.gohtml", http.StatusOK, w, &ViewPageArgs{
 		Common: t.populateArgs(w, r, CommonArgs{
 			PageTitle:    title,
 			IsWikiPage:   true,
 			LastModified: log,
 		}),
-		PageContent: template.HTML(content),
+		PageContent:    template.HTML(content),
+		RedirectedFrom: redirectedFrom,
 	})
 }
 
 type EditPageArgs struct {
 	Common      CommonArgs
 	PageContent string
+	Base        string
+	Message     string
+	Conflict    bool
 }
 
-func (t *Templates) RenderEditPage(w http.ResponseWriter, r *http.Request, title, content string) {
+func (t *Templates) RenderEditPage(w http.ResponseWriter, r *http.Request, title, content, base string) {
 	t.render("edit.gohtml", http.StatusOK, w, &EditPageArgs{
 		Common: t.populateArgs(w, r, CommonArgs{
 			PageTitle:      title,
 			ShowLinkToView: true,
 		}),
 		PageContent: content,
+		Base:        base,
+	})
+}
+
+func (t *Templates) RenderEditConflict(w http.ResponseWriter, r *http.Request, title string, conflict *EditConflictError, message string) {
+	t.render("edit.gohtml", http.StatusConflict, w, &EditPageArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle:      title,
+			ShowLinkToView: true,
+		}),
+		PageContent: string(conflict.Content),
+		Base:        conflict.Head,
+		Message:     message,
+		Conflict:    true,
 	})
 }
 
 type DeletePageArgs struct {
-	Common CommonArgs
+	Common    CommonArgs
+	Backlinks []string
 }
 
-func (t *Templates) RenderDeletePage(w http.ResponseWriter, r *http.Request, pageName string) {
+func (t *Templates) RenderDeletePage(w http.ResponseWriter, r *http.Request, pageName string, backlinks []string) {
 	t.render("delete.gohtml", http.StatusOK, w, &DeletePageArgs{
 		Common: t.populateArgs(w, r, CommonArgs{
 			PageTitle:      pageName,
 			ShowLinkToView: true,
 		}),
+		Backlinks: backlinks,
 	})
 }
 
@@ -112,15 +154,32 @@ func (t *Templates) RenderRevertPage(w http.ResponseWriter, r *http.Request, pag
 }
 
 type RenamePageArgs struct {
-	Common CommonArgs
+	Common    CommonArgs
+	Backlinks []string
 }
 
-func (t *Templates) RenderRenamePage(w http.ResponseWriter, r *http.Request, oldName string) {
+func (t *Templates) RenderRenamePage(w http.ResponseWriter, r *http.Request, oldName string, backlinks []string) {
 	t.render("rename.gohtml", http.StatusOK, w, &RenamePageArgs{
 		Common: t.populateArgs(w, r, CommonArgs{
 			PageTitle:      oldName,
 			ShowLinkToView: true,
 		}),
+		Backlinks: backlinks,
+	})
+}
+
+type LinksPageArgs struct {
+	Common CommonArgs
+	Links  *PageLinks
+}
+
+func (t *Templates) RenderLinks(w http.ResponseWriter, r *http.Request, links *PageLinks) {
+	t.render("links.gohtml", http.StatusOK, w, &LinksPageArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle:      links.Page,
+			ShowLinkToView: true,
+		}),
+		Links: links,
 	})
 }
 
@@ -153,14 +212,16 @@ func (t *Templates) RenderFileList(w http.ResponseWriter, r *http.Request, files
 }
 
 type DeleteFileArgs struct {
-	Common CommonArgs
+	Common     CommonArgs
+	EmbeddedBy []string
 }
 
-func (t *Templates) RenderDeleteFile(w http.ResponseWriter, r *http.Request, fileName string) {
+func (t *Templates) RenderDeleteFile(w http.ResponseWriter, r *http.Request, fileName string, embeddedBy []string) {
 	t.render("delete_file.gohtml", http.StatusOK, w, &DeleteFileArgs{
 		Common: t.populateArgs(w, r, CommonArgs{
 			PageTitle: fileName,
 		}),
+		EmbeddedBy: embeddedBy,
 	})
 }
 
@@ -232,19 +293,32 @@ func (t *Templates) RenderRecentChangesFeed(w http.ResponseWriter, r *http.Reque
 type ManageUsersArgs struct {
 	Common CommonArgs
 	Users  []UserInfo
+	Groups []GroupInfo
 }
 
 type UserInfo struct {
+	Name         string
+	Permissions  string
+	Email        string
+	Groups       []string
+	SingleSignOn bool
+	TwoFactor    bool
+	Sessions     []SessionInfo
+}
+
+type GroupInfo struct {
 	Name        string
 	Permissions string
+	Members     []string
 }
 
-func (t *Templates) RenderManageUsers(w http.ResponseWriter, r *http.Request, users []UserInfo) {
+func (t *Templates) RenderManageUsers(w http.ResponseWriter, r *http.Request, users []UserInfo, groups []GroupInfo) {
 	t.render("users.gohtml", http.StatusOK, w, &ManageUsersArgs{
 		Common: t.populateArgs(w, r, CommonArgs{
 			PageTitle: "Manage users",
 		}),
-		Users: users,
+		Users:  users,
+		Groups: groups,
 	})
 }
 
@@ -260,15 +334,243 @@ func (t *Templates) RenderViewSiteConfig(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-type AccountArgs struct {
+type AclArgs struct {
 	Common CommonArgs
+	Rules  []config.AclRule
+}
+
+func (t *Templates) RenderAcl(w http.ResponseWriter, r *http.Request, rules []config.AclRule) {
+	t.render("acl.gohtml", http.StatusOK, w, &AclArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Access control",
+		}),
+		Rules: rules,
+	})
+}
+
+type WebhooksArgs struct {
+	Common     CommonArgs
+	Webhooks   []config.Webhook
+	Events     []ChangeType
+	Deliveries []webhook.Delivery
 }
 
-func (t *Templates) RenderAccount(w http.ResponseWriter, r *http.Request) {
-	t.render("account.gohtml", http.StatusOK, w, &AccountArgs{
+func (t *Templates) RenderWebhooks(w http.ResponseWriter, r *http.Request, webhooks []config.Webhook, deliveries []webhook.Delivery) {
+	t.render("webhooks.gohtml", http.StatusOK, w, &WebhooksArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Webhooks",
+		}),
+		Webhooks:   webhooks,
+		Events:     webhookEvents,
+		Deliveries: deliveries,
+	})
+}
+
+type MailSettingsArgs struct {
+	Common      CommonArgs
+	Server      config.MailServer
+	HasPassword bool
+}
+
+func (t *Templates) RenderMailSettings(w http.ResponseWriter, r *http.Request, server config.MailServer) {
+	hasPassword := server.Password != ""
+	server.Password = ""
+	t.render("mail.gohtml", http.StatusOK, w, &MailSettingsArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Email",
+		}),
+		Server:      server,
+		HasPassword: hasPassword,
+	})
+}
+
+type SecurityArgs struct {
+	Common           CommonArgs
+	RequireTwoFactor bool
+	LockedAccounts   []LockedAccount
+}
+
+func (t *Templates) RenderSecurity(w http.ResponseWriter, r *http.Request, requireTwoFactor bool, locked []LockedAccount) {
+	t.render("security.gohtml", http.StatusOK, w, &SecurityArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Security",
+		}),
+		RequireTwoFactor: requireTwoFactor,
+		LockedAccounts:   locked,
+	})
+}
+
+type AuditArgs struct {
+	Common  CommonArgs
+	Filter  AuditFilter
+	Actions []string
+	Events  []AuditEvent
+	// Query is the query string of the current filter, to export the same events.
+	Query string
+	// Truncated indicates that there are more matching events than are shown.
+	Truncated bool
+}
+
+func (t *Templates) RenderAudit(w http.ResponseWriter, r *http.Request, filter AuditFilter, events []AuditEvent, query string, truncated bool) {
+	t.render("audit.gohtml", http.StatusOK, w, &AuditArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Audit log",
+		}),
+		Filter:    filter,
+		Actions:   []string{"login", "logout", "user", "group", "token", "invite", "password", "config"},
+		Events:    events,
+		Query:     query,
+		Truncated: truncated,
+	})
+}
+
+type RegistrationArgs struct {
+	Common  CommonArgs
+	Policy  config.RegistrationPolicy
+	Pending []*config.PendingUser
+	Invites []*config.Invite
+	// NewInvite is the link for an invite that has just been created, which can't be shown again.
+	NewInvite string
+}
+
+func (t *Templates) RenderRegistration(w http.ResponseWriter, r *http.Request, policy config.RegistrationPolicy, pending []*config.PendingUser, invites []*config.Invite, newInvite string) {
+	t.render("registration.gohtml", http.StatusOK, w, &RegistrationArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Registration",
+		}),
+		Policy:    policy,
+		Pending:   pending,
+		Invites:   invites,
+		NewInvite: newInvite,
+	})
+}
+
+type SignupArgs struct {
+	Common CommonArgs
+	// Invite is the token of the invite being used, if any, and Permissions are those it grants.
+	Invite      string
+	Permissions string
+}
+
+func (t *Templates) RenderSignup(w http.ResponseWriter, r *http.Request, invite, permissions string) {
+	t.render("signup.gohtml", http.StatusOK, w, &SignupArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Sign up",
+		}),
+		Invite:      invite,
+		Permissions: permissions,
+	})
+}
+
+type RemoteSyncArgs struct {
+	Common CommonArgs
+	Status SyncStatus
+}
+
+func (t *Templates) RenderRemoteSync(w http.ResponseWriter, r *http.Request, status SyncStatus) {
+	t.render("remote.gohtml", http.StatusOK, w, &RemoteSyncArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Remote repository",
+		}),
+		Status: status,
+	})
+}
+
+type AccountArgs struct {
+	Common   CommonArgs
+	Tokens   []TokenInfo
+	Sessions []SessionInfo
+	NewToken string
+	// TotpSecret, TotpUrl and TotpQRCode describe the secret the user is enrolling for two-factor authentication, if
+	// any. TotpQRCode is a data URL of an image of TotpUrl for authenticator apps to scan.
+	TotpSecret    string
+	TotpUrl       template.URL
+	TotpQRCode    template.URL
+	RecoveryCodes []string
+}
+
+type TokenInfo struct {
+	Name        string
+	Permissions string
+	Created     time.Time
+}
+
+// SessionInfo describes a session a user is logged in with. Current is set for the session viewing the page.
+type SessionInfo struct {
+	ID       string
+	Device   string
+	IP       string
+	Created  time.Time
+	LastSeen time.Time
+	Current  bool
+}
+
+func (t *Templates) RenderAccount(w http.ResponseWriter, r *http.Request, tokens []TokenInfo, sessions []SessionInfo, newToken string, recoveryCodes []string) {
+	args := &AccountArgs{
 		Common: t.populateArgs(w, r, CommonArgs{
 			PageTitle: "My account",
 		}),
+		Tokens:        tokens,
+		Sessions:      sessions,
+		NewToken:      newToken,
+		RecoveryCodes: recoveryCodes,
+	}
+
+	if user := getUserForRequest(r); user != nil && user.PendingTotpSecret() != nil && !user.TwoFactorEnabled() {
+		args.TotpSecret = totp.Encode(user.PendingTotpSecret())
+		args.TotpUrl = template.URL(totp.URL(t.siteConfig.Name, user.Name, user.PendingTotpSecret()))
+
+		if png, err := qrcode.Encode(string(args.TotpUrl), qrcode.Medium, 256); err != nil {
+			log.Printf("Unable to generate QR code for user %s: %v", user.Name, err)
+		} else {
+			args.TotpQRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+		}
+	}
+
+	t.render("account.gohtml", http.StatusOK, w, args)
+}
+
+type TotpLoginArgs struct {
+	Common   CommonArgs
+	Redirect string
+}
+
+func (t *Templates) RenderTotpLogin(w http.ResponseWriter, r *http.Request, redirect string) {
+	t.render("totp.gohtml", http.StatusOK, w, &TotpLoginArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Two-factor authentication",
+		}),
+		Redirect: redirect,
+	})
+}
+
+type ForgotPasswordArgs struct {
+	Common    CommonArgs
+	Available bool
+}
+
+func (t *Templates) RenderForgotPassword(w http.ResponseWriter, r *http.Request, available bool) {
+	t.render("forgot.gohtml", http.StatusOK, w, &ForgotPasswordArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Forgotten password",
+		}),
+		Available: available,
+	})
+}
+
+type ResetPasswordArgs struct {
+	Common   CommonArgs
+	Username string
+	Token    string
+}
+
+func (t *Templates) RenderResetPassword(w http.ResponseWriter, r *http.Request, username, token string) {
+	t.render("reset.gohtml", http.StatusOK, w, &ResetPasswordArgs{
+		Common: t.populateArgs(w, r, CommonArgs{
+			PageTitle: "Reset password",
+		}),
+		Username: username,
+		Token:    token,
 	})
 }
 
@@ -338,16 +640,18 @@ func (t *Templates) RenderBadRequest(w http.ResponseWriter, r *http.Request) {
 
 type SearchPageArgs struct {
 	Common  CommonArgs
-	Results []SearchResult
+	Results []search.Result
+	Total   int
 	Pattern string
 }
 
-func (t *Templates) RenderSearch(w http.ResponseWriter, r *http.Request, pattern string, results []SearchResult) {
+func (t *Templates) RenderSearch(w http.ResponseWriter, r *http.Request, pattern string, results []search.Result, total int) {
 	t.render("search.gohtml", http.StatusOK, w, &SearchPageArgs{
 		Common: t.populateArgs(w, r, CommonArgs{
 			PageTitle: "Search",
 		}),
 		Results: results,
+		Total:   total,
 		Pattern: pattern,
 	})
 }
@@ -371,6 +675,7 @@ func (t *Templates) render(name string, statusCode int, w http.ResponseWriter, d
 	tpl := template.New(name)
 	tpl.Funcs(map[string]interface{}{
 		"bytes": t.formatBytes,
+		"join":  strings.Join,
 		"unsafeHtml": func(html string) template.HTML {
 			return template.HTML(html)
 		},
@@ -400,16 +705,24 @@ func (t *Templates) formatBytes(size int64) string {
 func (t *Templates) populateArgs(w http.ResponseWriter, r *http.Request, args CommonArgs) CommonArgs {
 	user := getUserForRequest(r)
 	args.Site = &SiteArgs{
-		SiteName:    t.siteConfig.Name,
-		HasMainLogo: t.siteConfig.MainLogo != nil,
-		HasDarkLogo: t.siteConfig.DarkLogo != nil,
-		HasFavicon:  t.siteConfig.Favicon != nil,
-		CanRead:     t.checker.CanRead(user),
-		CanWrite:    t.checker.CanWrite(user),
-		CanAdmin:    t.checker.CanAdmin(user),
-		WikiVersion: t.version,
+		SiteName:     t.siteConfig.Name,
+		HasMainLogo:  t.siteConfig.MainLogo != nil,
+		HasDarkLogo:  t.siteConfig.DarkLogo != nil,
+		HasFavicon:   t.siteConfig.Favicon != nil,
+		CanRead:      t.checker.CanRead(user),
+		CanWrite:     t.checker.CanWrite(user),
+		CanAdmin:     t.checker.CanAdmin(user),
+		WikiVersion:  t.version,
+		SingleSignOn: t.singleSignOn,
 	}
+	args.Site.SettingsInMemory = t.settingsInMemory
+	args.Site.PasswordReset = t.passwordReset != nil && t.passwordReset()
+	args.Site.Registration = t.registrationOpen != nil && t.registrationOpen()
 	args.User = user
+	if args.IsWikiPage {
+		// Only offer to edit the page if the access control rules allow it
+		args.Site.CanWrite = t.checker.CanWritePath(user, args.PageTitle)
+	}
 
 	if args.Error = getErrorForRequest(r); args.Error != "" {
 		clearSessionKey(w, r, sessionErrorKey)
@@ -0,0 +1,62 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret used by the test vectors in RFC 6238.
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// The RFC's vectors are eight digits long; six digit codes are the last six digits.
+	tests := []struct {
+		time int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := Code(rfcSecret, time.Unix(tt.time, 0)); got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		name  string
+		input string
+		want  bool
+	}{
+		{"current code", Code(rfcSecret, now), true},
+		{"previous code", Code(rfcSecret, now.Add(-Period)), true},
+		{"next code", Code(rfcSecret, now.Add(Period)), true},
+		{"old code", Code(rfcSecret, now.Add(-2*Period)), false},
+		{"code with spaces", Code(rfcSecret, now)[:3] + " " + Code(rfcSecret, now)[3:], true},
+		{"wrong length", "12345", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, got := Validate(rfcSecret, tt.input, now); got != tt.want {
+				t.Errorf("Validate(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURL(t *testing.T) {
+	got := URL("My Wiki", "alice", rfcSecret)
+	for _, want := range []string{"otpauth://totp/My%20Wiki:alice?", "secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", "issuer=My+Wiki"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("URL() = %s, want it to contain %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Package webhook delivers notifications of changes to external HTTP endpoints.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// SignatureHeader contains the hex-encoded HMAC-SHA256 of the request body, prefixed with "sha256=".
+	SignatureHeader = "X-Wiki-Signature-256"
+	// EventHeader contains the type of event being delivered.
+	EventHeader = "X-Wiki-Event"
+	// DeliveryHeader contains a unique ID for the delivery, which is the same for each retry.
+	DeliveryHeader = "X-Wiki-Delivery"
+
+	// logSize is the number of deliveries kept in the log.
+	logSize = 100
+)
+
+// Event is the payload sent to webhooks.
+type Event struct {
+	Event   string    `json:"event"`
+	Commit  string    `json:"commit,omitempty"`
+	User    string    `json:"user,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+	Paths   []string  `json:"paths"`
+}
+
+// Target is an endpoint that events are delivered to.
+type Target struct {
+	ID     string
+	URL    string
+	Secret string
+}
+
+// Delivery records the progress of sending an event to a target.
+type Delivery struct {
+	ID       string
+	Target   string
+	URL      string
+	Event    string
+	Commit   string
+	Started  time.Time
+	Attempts int
+	// Status is the HTTP status code of the last attempt, or zero if no response was received.
+	Status int
+	// Error describes why the last attempt failed, if it did.
+	Error     string
+	Succeeded bool
+	// Finished is true once the delivery has succeeded or all attempts have been used.
+	Finished bool
+}
+
+// Dispatcher sends events to webhooks in the background, retrying failed deliveries with exponential backoff.
+type Dispatcher struct {
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+
+	mutex      sync.Mutex
+	deliveries []*Delivery
+	wg         sync.WaitGroup
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		attempts: 5,
+		backoff:  10 * time.Second,
+	}
+}
+
+// Send starts delivering the event to the target, returning immediately.
+func (d *Dispatcher) Send(target Target, event *Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Unable to encode webhook event: %v", err)
+		return
+	}
+
+	id, err := randomID()
+	if err != nil {
+		log.Printf("Unable to generate webhook delivery ID: %v", err)
+		return
+	}
+
+	delivery := &Delivery{
+		ID:      id,
+		Target:  target.ID,
+		URL:     target.URL,
+		Event:   event.Event,
+		Commit:  event.Commit,
+		Started: time.Now(),
+	}
+
+	d.mutex.Lock()
+	d.deliveries = append([]*Delivery{delivery}, d.deliveries...)
+	if len(d.deliveries) > logSize {
+		d.deliveries = d.deliveries[:logSize]
+	}
+	d.mutex.Unlock()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.deliver(target, delivery, body)
+	}()
+}
+
+// Deliveries returns a copy of the most recent deliveries, newest first.
+func (d *Dispatcher) Deliveries() []Delivery {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	res := make([]Delivery, len(d.deliveries))
+	for i := range d.deliveries {
+		res[i] = *d.deliveries[i]
+	}
+	return res
+}
+
+// Wait blocks until all deliveries in progress have finished.
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) deliver(target Target, delivery *Delivery, body []byte) {
+	delay := d.backoff
+	for attempt := 1; attempt <= d.attempts; attempt++ {
+		status, err := d.post(target, delivery, body)
+
+		d.mutex.Lock()
+		delivery.Attempts = attempt
+		delivery.Status = status
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		delivery.Succeeded = err == nil
+		delivery.Finished = err == nil || attempt == d.attempts
+		d.mutex.Unlock()
+
+		if err == nil {
+			return
+		}
+
+		log.Printf("Webhook delivery %s to webhook %s at %s failed (attempt %d of %d): %v", delivery.ID, target.ID, urlHost(target.URL), attempt, d.attempts, withoutURL(err))
+		if attempt < d.attempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+func (d *Dispatcher) post(target Target, delivery *Delivery, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mdbot-wiki-webhook")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	if target.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(target.Secret, body))
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// Sign returns the value of the signature header for a request with the given body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// urlHost returns the host a webhook is sent to. Only the host is logged, as the rest of the URL may contain secrets
+// such as access tokens.
+func urlHost(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "an invalid URL"
+	}
+	return u.Host
+}
+
+// withoutURL removes the URL that the HTTP client adds to the errors it returns, for the same reason.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
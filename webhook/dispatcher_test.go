@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestDispatcher() *Dispatcher {
+	d := NewDispatcher()
+	d.attempts = 3
+	d.backoff = time.Millisecond
+	return d
+}
+
+func TestDispatcher_Send(t *testing.T) {
+	var mutex sync.Mutex
+	var requests int
+	var deliveryIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get(SignatureHeader); got != Sign("secret", body) {
+			t.Errorf("Signature = %q, want %q", got, Sign("secret", body))
+		}
+
+		event := &Event{}
+		if err := json.Unmarshal(body, event); err != nil || event.Event != "edit" || event.Commit != "abc" {
+			t.Errorf("Received event %s, error %v", body, err)
+		}
+
+		deliveryIDs = append(deliveryIDs, r.Header.Get(DeliveryHeader))
+		requests++
+		if requests < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	d := newTestDispatcher()
+	d.Send(Target{ID: "hook", URL: server.URL, Secret: "secret"}, &Event{Event: "edit", Commit: "abc", Paths: []string{"page.md"}})
+	d.Wait()
+
+	deliveries := d.Deliveries()
+	if len(deliveries) != 1 {
+		t.Fatalf("Deliveries() returned %d deliveries, want 1", len(deliveries))
+	}
+	if got := deliveries[0]; !got.Succeeded || !got.Finished || got.Attempts != 2 || got.Status != http.StatusOK || got.Error != "" {
+		t.Errorf("Delivery = %+v, want success on the second attempt", got)
+	}
+	if len(deliveryIDs) != 2 || deliveryIDs[0] != deliveryIDs[1] || deliveryIDs[0] != deliveries[0].ID {
+		t.Errorf("Retries used delivery IDs %v, want %s each time", deliveryIDs, deliveries[0].ID)
+	}
+}
+
+func TestDispatcher_Failure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(SignatureHeader) != "" {
+			t.Errorf("Request without a secret was signed")
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	d := newTestDispatcher()
+	d.Send(Target{URL: server.URL}, &Event{Event: "delete"})
+	d.Wait()
+
+	if got := d.Deliveries()[0]; got.Succeeded || !got.Finished || got.Attempts != 3 || got.Status != http.StatusNotFound || got.Error == "" {
+		t.Errorf("Delivery = %+v, want failure after 3 attempts", got)
+	}
+}
+
+func TestDispatcher_FailureLog(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	target := Target{ID: "hook-1", URL: server.URL + "/hooks/secret-token?key=secret-key"}
+	server.Close()
+
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	d := newTestDispatcher()
+	d.Send(target, &Event{Event: "delete"})
+	d.Wait()
+
+	if !strings.Contains(logs.String(), "hook-1") || strings.Contains(logs.String(), "secret") {
+		t.Errorf("failed delivery logged as %q, want the webhook ID without its secrets", logs.String())
+	}
+}